@@ -4,6 +4,7 @@ require (
 	github.com/Azure/sonic-mgmt-common v0.0.0-00010101000000-000000000000
 	github.com/golang/glog v1.2.5
 	github.com/gorilla/mux v1.7.4
+	github.com/openconfig/goyang v0.0.0-20200309174518-a00bece872fc
 	github.com/pkg/profile v1.7.0
 	golang.org/x/crypto v0.50.0
 	gopkg.in/go-playground/validator.v9 v9.31.0
@@ -29,7 +30,6 @@ require (
 	github.com/leodido/go-urn v1.2.0 // indirect
 	github.com/maruel/natural v1.1.1 // indirect
 	github.com/openconfig/gnmi v0.0.0-20200617225440-d2b4e6a45802 // indirect
-	github.com/openconfig/goyang v0.0.0-20200309174518-a00bece872fc // indirect
 	github.com/openconfig/ygot v0.7.1 // indirect
 	github.com/philopon/go-toposort v0.0.0-20170620085441-9be86dbd762f // indirect
 	github.com/redis/go-redis/v9 v9.6.1 // indirect
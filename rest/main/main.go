@@ -51,6 +51,10 @@ var (
 	// readTimeout is the deadline for receiving a full request (TLS+header+body)
 	// once the connection is made. Value 0 indicates no timeout.
 	readTimeout time.Duration = 15 * time.Second
+
+	// writeTimeout is the deadline for writing the response, from the end
+	// of reading the request headers. Value 0 indicates no timeout.
+	writeTimeout time.Duration
 )
 
 func init() {
@@ -60,8 +64,10 @@ func init() {
 	flag.StringVar(&certFile, "cert", "", "Server certificate file path")
 	flag.StringVar(&keyFile, "key", "", "Server private key file path")
 	flag.StringVar(&caFile, "cacert", "", "CA certificate for client certificate validation")
-	flag.StringVar(&clientAuth, "client_auth", "none", "Client auth mode - none|cert|user")
+	flag.StringVar(&clientAuth, "client_auth", "none", "Client auth mode - none|cert|user. "+
+		"Admin-only operations like set-read-only are open to all clients unless 'user'")
 	flag.DurationVar(&readTimeout, "readtimeout", readTimeout, "Maximum duration for reading entire request")
+	flag.DurationVar(&writeTimeout, "write_timeout", writeTimeout, "Maximum duration for writing the response; 0 for no limit")
 	flag.Parse()
 }
 
@@ -87,7 +93,9 @@ func main() {
 
 	openapi.Load()
 
-	rtrConfig := server.RouterConfig{}
+	serverCerts := prepareServerCertificate()
+
+	rtrConfig := server.RouterConfig{Certificates: serverCerts}
 	if clientAuth == "user" {
 		rtrConfig.AuthEnable = true
 	}
@@ -102,7 +110,7 @@ func main() {
 	// Prepare TLSConfig from the parameters
 	tlsConfig := tls.Config{
 		ClientAuth:               getTLSClientAuthType(),
-		Certificates:             prepareServerCertificate(),
+		Certificates:             serverCerts,
 		ClientCAs:                prepareCACertificates(),
 		MinVersion:               tls.VersionTLS12,
 		PreferServerCipherSuites: true,
@@ -110,15 +118,17 @@ func main() {
 
 	// Prepare HTTPS server
 	restServer := &http.Server{
-		Addr:        address,
-		Handler:     router,
-		TLSConfig:   &tlsConfig,
-		ReadTimeout: readTimeout,
-		ErrorLog:    serverLog,
+		Addr:         address,
+		Handler:      router,
+		TLSConfig:    &tlsConfig,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		ErrorLog:     serverLog,
 	}
 
 	if glog.V(1) {
 		glog.Infof("Read timeout = %v", readTimeout)
+		glog.Infof("Write timeout = %v", writeTimeout)
 		glog.Infof("Authentication modes = %v", clientAuth)
 	}
 
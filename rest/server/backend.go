@@ -0,0 +1,542 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/sonic-mgmt-common/cvl"
+	"github.com/Azure/sonic-mgmt-common/translib"
+	"github.com/Azure/sonic-mgmt-common/translib/tlerr"
+	"github.com/golang/glog"
+)
+
+// putCreationCheck enables the existence check of list instances before
+// replacing them through translib; see translibBackend.Replace.
+var putCreationCheck bool
+
+func init() {
+	flag.BoolVar(&putCreationCheck, "put_creation_check", false,
+		"Check the existence of list instances before PUT through translib, "+
+			"to return 201 status for creations. Costs an extra translib get per PUT")
+}
+
+// Backend is the interface for data providers which serve the RESTCONF
+// requests. Process function dispatches the requests to the Backend
+// registered for the request path; translib by default.
+//
+// Backend functions should return translib errors (tlerr package) for
+// proper mapping to HTTP status codes; like tlerr.NotFoundError for 404.
+// Other errors result in 500 status.
+type Backend interface {
+	// Get returns data for the path, in RFC7951 json format.
+	Get(req BackendRequest) (BackendResponse, error)
+
+	// Create creates the child resources (from payload) under the path.
+	// Serves POST requests.
+	Create(req BackendRequest) (BackendResponse, error)
+
+	// Replace replaces the resource at path with payload. Serves PUT.
+	Replace(req BackendRequest) (BackendResponse, error)
+
+	// Update merges the payload into the resource at path. Serves PATCH.
+	Update(req BackendRequest) (BackendResponse, error)
+
+	// Delete deletes the resource at path.
+	Delete(req BackendRequest) (BackendResponse, error)
+
+	// Action invokes the RPC or action at path with payload as input.
+	// Response payload should contain the RPC output.
+	Action(req BackendRequest) (BackendResponse, error)
+}
+
+// ContextBackend is implemented by the Backends which honour the
+// BackendRequest.Context - stop processing and return the context error
+// (context.DeadlineExceeded or context.Canceled) once it is done.
+// Requests to other Backends cannot be interrupted; see callBackend.
+type ContextBackend interface {
+	Backend
+
+	// HonoursContext returns true if the Backend honours the context.
+	HonoursContext() bool
+}
+
+// BackendRequest holds the parameters of a request to a Backend.
+type BackendRequest struct {
+	// Context is cancelled when the request is aborted; like when
+	// an asynchronous job is deleted or the operation deadline expires.
+	// Backends may ignore it, unless they implement ContextBackend.
+	Context context.Context
+
+	// RequestID is the id of the REST request, for correlating backend
+	// logs with the REST server logs.
+	RequestID string
+
+	// Path is the target resource path in gNMI style syntax, with list
+	// keys in "[name=value]" format. RESTCONF path prefixes are removed.
+	// Eg: "/openconfig-acl:acl/acl-sets/acl-set[name=X][type=Y]"
+	Path string
+
+	// Payload is the request body. Only json is supported for now.
+	Payload []byte
+
+	// User is the name of the authenticated user; empty if
+	// authentication is not enabled.
+	User string
+
+	// ClientVersion is the version from Accept-Version header
+	ClientVersion translib.Version
+
+	// Depth, Content and Fields are the RESTCONF query parameters
+	// for Get requests. Depth 0 indicates unlimited depth.
+	Depth   uint
+	Content string
+	Fields  []string
+
+	// DeleteEmptyEntry indicates that the parent entry should be
+	// deleted if it becomes empty after a Delete request.
+	DeleteEmptyEntry bool
+}
+
+// BackendResponse holds the response data from a Backend.
+type BackendResponse struct {
+	// Payload is the response data; for Get and Action requests only.
+	Payload []byte
+
+	// Created indicates that a Replace request created a new resource,
+	// instead of replacing an existing one.
+	Created bool
+}
+
+// BulkBackend is implemented by the Backends which can apply multiple
+// write requests in a single transaction. Used by the batch API for
+// atomic batches.
+type BulkBackend interface {
+	Backend
+
+	// Bulk applies all the operations atomically; either all of them
+	// succeed or none. Returns the index of the failed operation along
+	// with the error; or -1 if it cannot be identified. If more than one
+	// operation failed, the error can be a MultiError of
+	// BulkOperationErrors, and the index is of the first failed operation.
+	Bulk(ops []BulkOperation) (int, error)
+}
+
+// BulkOperationError is the error of an operation in a Bulk request.
+type BulkOperationError struct {
+	Index int // index of the operation
+	Err   error
+}
+
+func (e BulkOperationError) Error() string {
+	return e.Err.Error()
+}
+
+// BulkOperation is a write request in a BulkBackend transaction.
+type BulkOperation struct {
+	// Method is the HTTP method of the write request - "POST", "PUT",
+	// "PATCH" or "DELETE".
+	Method string
+
+	Request BackendRequest
+
+	// Response is set by the BulkBackend if the transaction succeeds;
+	// like Created for a PUT which created the resource.
+	Response BackendResponse
+}
+
+// backendEntry is an entry in the backend registry
+type backendEntry struct {
+	prefix  string
+	backend Backend
+}
+
+var (
+	// backends is the list of registered backends, sorted by
+	// descending order of prefix length.
+	backends   []backendEntry
+	backendsMu sync.RWMutex
+
+	// defaultBackend serves requests not matched by any of the
+	// registered backends.
+	defaultBackend Backend = translibBackend{}
+)
+
+// RegisterBackend registers a Backend to serve RESTCONF requests for
+// the paths starting with the given prefix. Prefix is matched against
+// the route's path template; like "/restconf/data/openconfig-acl:" or
+// "/restconf/operations/sonic-foo:". Backend with longest matching
+// prefix is selected for a request. Replaces the backend registered
+// earlier for the same prefix.
+func RegisterBackend(prefix string, b Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	glog.Infof("Registering backend %T for prefix \"%s\"", b, prefix)
+	removeBackendEntry(prefix)
+
+	k := 0
+	for k < len(backends) && len(backends[k].prefix) >= len(prefix) {
+		k++
+	}
+
+	backends = append(backends, backendEntry{})
+	copy(backends[k+1:], backends[k:])
+	backends[k] = backendEntry{prefix: prefix, backend: b}
+}
+
+// UnregisterBackend removes the Backend registered for the prefix.
+// Requests for those paths will be served by the next matching backend.
+func UnregisterBackend(prefix string) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	removeBackendEntry(prefix)
+}
+
+func removeBackendEntry(prefix string) {
+	for i, be := range backends {
+		if be.prefix == prefix {
+			backends = append(backends[:i], backends[i+1:]...)
+			return
+		}
+	}
+}
+
+// getBackend returns the Backend for a route path.
+func getBackend(path string) Backend {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	for _, be := range backends {
+		if strings.HasPrefix(path, be.prefix) {
+			return be.backend
+		}
+	}
+
+	return defaultBackend
+}
+
+// translibBackend is the default Backend, which uses translib APIs.
+// Translib APIs do not accept a request id. Hence every translib call
+// is logged with the request id when it starts and ends, so that the
+// translib logs in between can be associated with the REST request.
+type translibBackend struct{}
+
+// callTranslib logs and invokes a translib API function f, for the
+// request req. Api is the translib API name, for logging.
+func callTranslib(req *BackendRequest, api string, f func() error) error {
+	log := (&logEntry{}).with("request_id", req.RequestID).with("api", "translib."+api)
+	log.Infof("Calling translib; path=%s", redactTranslibPath(req.Path))
+
+	start := time.Now()
+	err := f()
+
+	log = log.with("latency", time.Since(start))
+	if err != nil {
+		log.Infof("Translib call failed; %T", err)
+	} else {
+		log.Infof("Translib call completed")
+	}
+	return err
+}
+
+func (translibBackend) Get(req BackendRequest) (BackendResponse, error) {
+	var resp translib.GetResponse
+	err := callTranslib(&req, "Get", func() (err error) {
+		resp, err = translib.Get(translib.GetRequest{
+			Path:          req.Path,
+			ClientVersion: req.ClientVersion,
+			QueryParams: translib.QueryParameters{
+				Depth:   req.Depth,
+				Content: req.Content,
+				Fields:  req.Fields,
+			},
+		})
+		return
+	})
+	return BackendResponse{Payload: resp.Payload}, translibError(&req, err)
+}
+
+func (translibBackend) Create(req BackendRequest) (BackendResponse, error) {
+	err := callTranslib(&req, "Create", func() error {
+		_, err := translib.Create(req.toSetRequest())
+		return err
+	})
+	return BackendResponse{}, translibError(&req, err)
+}
+
+// Replace replaces the resource through translib.Replace API. Translib
+// does not report whether the resource was created; it is always reported
+// as replaced. If the put_creation_check option is enabled, the existence
+// of a list instance is checked before replacing it, to report the
+// creation of new list entries. The check costs an extra translib.Get
+// call and is not atomic with the replace.
+func (b translibBackend) Replace(req BackendRequest) (BackendResponse, error) {
+	var created bool
+	if putCreationCheck && isListInstancePath(req.Path) {
+		var err error
+		if created, err = b.isNewListInstance(&req); err != nil {
+			return BackendResponse{}, err
+		}
+	}
+
+	err := callTranslib(&req, "Replace", func() error {
+		_, err := translib.Replace(req.toSetRequest())
+		return err
+	})
+	return BackendResponse{Created: created}, translibError(&req, err)
+}
+
+// isNewListInstance checks whether the list instance at req.Path does not
+// exist. The check is bound by the request context, like a GET request.
+// Returns the context error if the context gets done before the check
+// completes; the caller should not proceed with the write then. Other
+// errors are only logged, and the instance is treated as existing.
+func (b translibBackend) isNewListInstance(req *BackendRequest) (bool, error) {
+	_, err := callBackend(req.Context, b, "GET", func() (BackendResponse, error) {
+		return BackendResponse{}, callTranslib(req, "Get", func() error {
+			_, err := translib.Get(translib.GetRequest{
+				Path:          req.Path,
+				ClientVersion: req.ClientVersion,
+				QueryParams:   translib.QueryParameters{Depth: 1},
+			})
+			return err
+		})
+	})
+	if req.Context != nil && req.Context.Err() != nil {
+		return false, contextError(req.Context.Err())
+	}
+	if err != nil && !isNotFoundError(err) {
+		(&logEntry{}).with("request_id", req.RequestID).
+			Warningf("Existence check failed for %s; %v", redactTranslibPath(req.Path), err)
+	}
+	return isNotFoundError(err), nil
+}
+
+func (translibBackend) Update(req BackendRequest) (BackendResponse, error) {
+	err := callTranslib(&req, "Update", func() error {
+		_, err := translib.Update(req.toSetRequest())
+		return err
+	})
+	return BackendResponse{}, translibError(&req, err)
+}
+
+func (translibBackend) Delete(req BackendRequest) (BackendResponse, error) {
+	sr := req.toSetRequest()
+	sr.Payload = nil
+	err := callTranslib(&req, "Delete", func() error {
+		_, err := translib.Delete(sr)
+		return err
+	})
+	return BackendResponse{}, translibError(&req, err)
+}
+
+func (translibBackend) Action(req BackendRequest) (BackendResponse, error) {
+	var resp translib.ActionResponse
+	err := callTranslib(&req, "Action", func() (err error) {
+		resp, err = translib.Action(translib.ActionRequest{
+			Path:          req.Path,
+			Payload:       req.Payload,
+			ClientVersion: req.ClientVersion,
+		})
+		return
+	})
+	return BackendResponse{Payload: resp.Payload}, translibError(&req, err)
+}
+
+// Bulk applies the operations through translib.Bulk API. Translib
+// processes the deletes first, followed by replaces, updates and creates.
+// Hence the operations should be in the same order.
+func (translibBackend) Bulk(ops []BulkOperation) (int, error) {
+	if len(ops) == 0 {
+		return -1, nil
+	}
+
+	var req translib.BulkRequest
+	var lists = map[string]*[]translib.SetRequest{
+		"DELETE": &req.DeleteRequest,
+		"PUT":    &req.ReplaceRequest,
+		"PATCH":  &req.UpdateRequest,
+		"POST":   &req.CreateRequest,
+	}
+
+	lastOrder := 0
+	for i, op := range ops {
+		order := bulkOrder[op.Method]
+		if order == 0 {
+			return i, tlerr.NotSupported("Operation '%s' not supported in a bulk request", op.Method)
+		}
+		if order < lastOrder {
+			return i, tlerr.InvalidArgs("Bulk request operations should be ordered as DELETE, PUT, PATCH and POST")
+		}
+		lastOrder = order
+
+		sr := op.Request.toSetRequest()
+		if op.Method == "DELETE" {
+			sr.Payload = nil
+		}
+
+		*lists[op.Method] = append(*lists[op.Method], sr)
+		req.ClientVersion = op.Request.ClientVersion
+	}
+
+	var resp translib.BulkResponse
+	err := callTranslib(&ops[0].Request, "Bulk", func() (err error) {
+		resp, err = translib.Bulk(req)
+		return
+	})
+	if err == nil {
+		return -1, nil
+	}
+
+	return bulkResponseError(ops, resp, err)
+}
+
+// bulkResponseError locates the failed operations of a translib.Bulk
+// call from the per-request errors in resp. Returns the index of the
+// first failed operation and its error; or a MultiError of
+// BulkOperationErrors if more than one operation failed. Returns -1
+// and err if the failed operations cannot be identified.
+func bulkResponseError(ops []BulkOperation, resp translib.BulkResponse, err error) (int, error) {
+	var errs MultiError
+	first, index := -1, 0
+	for _, r := range [][]translib.SetResponse{
+		resp.DeleteResponse, resp.ReplaceResponse, resp.UpdateResponse, resp.CreateResponse} {
+		for _, x := range r {
+			if x.Err != nil && index < len(ops) {
+				if first < 0 {
+					first = index
+				}
+				errs = append(errs, BulkOperationError{Index: index, Err: translibError(&ops[index].Request, x.Err)})
+			}
+			index++
+		}
+	}
+
+	switch len(errs) {
+	case 0:
+		return -1, err
+	case 1:
+		return first, errs[0].(BulkOperationError).Err
+	}
+	return first, errs
+}
+
+// translibError adds the error path to translib errors which do not
+// carry one. CVL errors identify the failed data by the DB table, keys
+// and field, instead of a path. Their error path is the sonic YANG path
+// of the field if the YANG schema has the table; the request path
+// otherwise. Errors of a MultiError are converted individually.
+func translibError(req *BackendRequest, err error) error {
+	switch e := err.(type) {
+	case tlerr.TranslibCVLFailure:
+		return pathError{err: err, path: cvlErrorPath(req.Path, e.CVLErrorInfo)}
+	case MultiError:
+		var errs MultiError
+		for _, x := range e {
+			errs = append(errs, translibError(req, x))
+		}
+		return errs
+	}
+	return err
+}
+
+// cvlErrorPath returns the translib path of the DB table entry and field
+// reported in a CVL error, as per the sonic YANG module of the table.
+// Sonic YANG modules have the tables as containers under the top level
+// container, with a list for the table entries; like
+// "/sonic-port:sonic-port/PORT/PORT_LIST[name=Ethernet0]/mtu".
+// Returns reqPath if the table is not found in the YANG schema.
+func cvlErrorPath(reqPath string, info cvl.CVLErrorInfo) string {
+	s := getYangSchema()
+	if s == nil || len(info.TableName) == 0 {
+		return reqPath
+	}
+
+	// CVL keys can be the DB key, with table name and "|" separators
+	keys := strings.Split(strings.Join(info.Keys, "|"), "|")
+	if len(keys) != 0 && keys[0] == info.TableName {
+		keys = keys[1:]
+	}
+
+	for modName, m := range s.modules {
+		for _, top := range m.Dir {
+			table := top.Dir[info.TableName]
+			if table == nil || !table.IsContainer() {
+				continue
+			}
+			for _, list := range table.Dir {
+				keyNames := strings.Fields(list.Key)
+				if !list.IsList() || len(keyNames) != len(keys) {
+					continue
+				}
+
+				p := "/" + modName + ":" + top.Name + "/" + table.Name + "/" + list.Name
+				for i, k := range keyNames {
+					p += "[" + k + "=" + escapeKeyValue(keys[i]) + "]"
+				}
+				if f := list.Dir[info.Field]; f != nil {
+					p += "/" + f.Name
+				}
+				return p
+			}
+		}
+	}
+
+	return reqPath
+}
+
+// bulkOrder is the order of translib.Bulk operations.
+var bulkOrder = map[string]int{"DELETE": 1, "PUT": 2, "PATCH": 3, "POST": 4}
+
+// isNotFoundError checks if err is a translib error indicating
+// a missing resource.
+func isNotFoundError(err error) bool {
+	switch err.(type) {
+	case tlerr.NotFoundError, tlerr.TranslibRedisClientEntryNotExist:
+		return true
+	}
+	return false
+}
+
+// isListInstancePath checks if the translib path points to a list
+// instance; i.e, the last path element has keys.
+func isListInstancePath(path string) bool {
+	elems := splitTranslibPath(path)
+	if len(elems) == 0 {
+		return false
+	}
+	_, keys := splitElemKeys(elems[len(elems)-1])
+	return len(keys) != 0
+}
+
+// toSetRequest creates a translib.SetRequest from a BackendRequest.
+func (req *BackendRequest) toSetRequest() translib.SetRequest {
+	return translib.SetRequest{
+		Path:             req.Path,
+		Payload:          req.Payload,
+		ClientVersion:    req.ClientVersion,
+		DeleteEmptyEntry: req.DeleteEmptyEntry,
+	}
+}
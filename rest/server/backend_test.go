@@ -0,0 +1,399 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/sonic-mgmt-common/cvl"
+	"github.com/Azure/sonic-mgmt-common/translib"
+	"github.com/Azure/sonic-mgmt-common/translib/tlerr"
+)
+
+// recordingBackend is a Backend which records the last request and
+// returns a fixed response.
+type recordingBackend struct {
+	name   string
+	method string
+	req    BackendRequest
+	resp   []byte
+	err    error
+}
+
+func (b *recordingBackend) record(method string, req BackendRequest) (BackendResponse, error) {
+	b.method = method
+	b.req = req
+	return BackendResponse{Payload: b.resp}, b.err
+}
+
+func (b *recordingBackend) Get(req BackendRequest) (BackendResponse, error) {
+	return b.record("Get", req)
+}
+
+func (b *recordingBackend) Create(req BackendRequest) (BackendResponse, error) {
+	return b.record("Create", req)
+}
+
+func (b *recordingBackend) Replace(req BackendRequest) (BackendResponse, error) {
+	return b.record("Replace", req)
+}
+
+func (b *recordingBackend) Update(req BackendRequest) (BackendResponse, error) {
+	return b.record("Update", req)
+}
+
+func (b *recordingBackend) Delete(req BackendRequest) (BackendResponse, error) {
+	return b.record("Delete", req)
+}
+
+func (b *recordingBackend) Action(req BackendRequest) (BackendResponse, error) {
+	return b.record("Action", req)
+}
+
+// useBackend registers a Backend for a prefix. Returns a function
+// to unregister it.
+func useBackend(prefix string, b Backend) func() {
+	RegisterBackend(prefix, b)
+	return func() { UnregisterBackend(prefix) }
+}
+
+func TestBackendDispatch(t *testing.T) {
+	b := &recordingBackend{resp: []byte(`{"backend-test:x":1}`)}
+	defer useBackend("/restconf/data/backend-test:", b)()
+
+	t.Run("GET", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Process(w, prepareRequest(t, "GET", "/backend-test:top?depth=3", ""))
+		verifyResponseData(t, w, 200, jsonObj{"backend-test:x": 1})
+		verifyBackendRequest(t, b, "Get", "/backend-test:top", "")
+		if b.req.Depth != 3 {
+			t.Fatalf("Expected depth 3; found %d", b.req.Depth)
+		}
+	})
+
+	t.Run("PUT", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Process(w, prepareRequest(t, "PUT", "/backend-test:top", `{"a":1}`))
+		verifyResponse(t, w, 204)
+		verifyBackendRequest(t, b, "Replace", "/backend-test:top", `{"a":1}`)
+		if w.Body.Len() != 0 {
+			t.Fatalf("Expecting no body; found %s", w.Body.String())
+		}
+	})
+
+	t.Run("PATCH", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Process(w, prepareRequest(t, "PATCH", "/backend-test:top", `{"a":2}`))
+		verifyResponse(t, w, 204)
+		verifyBackendRequest(t, b, "Update", "/backend-test:top", `{"a":2}`)
+	})
+
+	t.Run("POST", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Process(w, prepareRequest(t, "POST", "/backend-test:top", `{"a":3}`))
+		verifyResponse(t, w, 201)
+		verifyBackendRequest(t, b, "Create", "/backend-test:top", `{"a":3}`)
+	})
+
+	t.Run("DELETE", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Process(w, prepareRequest(t, "DELETE", "/backend-test:top", ""))
+		verifyResponse(t, w, 204)
+		verifyBackendRequest(t, b, "Delete", "/backend-test:top", "")
+	})
+
+	t.Run("error", func(t *testing.T) {
+		b.err = tlerr.NotFoundError{Format: "not there"}
+		defer func() { b.err = nil }()
+
+		w := httptest.NewRecorder()
+		Process(w, prepareRequest(t, "GET", "/backend-test:top", ""))
+		verifyResponse(t, w, 404)
+	})
+
+	t.Run("other_path", func(t *testing.T) {
+		b.method = ""
+		w := httptest.NewRecorder()
+		Process(w, prepareRequest(t, "GET", "/api-tests:sample", ""))
+		verifyResponseData(t, w, 200, jsonObj{"path": "/api-tests:sample"})
+		if b.method != "" {
+			t.Fatalf("Request for other path dispatched to test backend")
+		}
+	})
+}
+
+func TestBackendDispatch_RPC(t *testing.T) {
+	b := &recordingBackend{resp: []byte(`{"backend-test:output":{"status":"ok"}}`)}
+	defer useBackend("/restconf/operations/backend-test:", b)()
+
+	w := httptest.NewRecorder()
+	Process(w, prepareRequest(t, "POST", "/restconf/operations/backend-test:reset",
+		`{"backend-test:input":{}}`))
+	verifyResponse(t, w, 200)
+	verifyBackendRequest(t, b, "Action", "/backend-test:reset", `{"backend-test:input":{}}`)
+}
+
+func TestBackendPrefix(t *testing.T) {
+	b1 := &recordingBackend{name: "b1"}
+	b2 := &recordingBackend{name: "b2"}
+	defer useBackend("/restconf/data/backend-test:", b1)()
+	defer useBackend("/restconf/data/backend-test:top/sub", b2)()
+
+	verifyBackend(t, "/restconf/data/backend-test:top", b1)
+	verifyBackend(t, "/restconf/data/backend-test:top/sub/x", b2)
+	verifyBackend(t, "/restconf/data/api-tests:sample", defaultBackend)
+
+	// Replace b2 with b1
+	RegisterBackend("/restconf/data/backend-test:top/sub", b1)
+	verifyBackend(t, "/restconf/data/backend-test:top/sub/x", b1)
+
+	UnregisterBackend("/restconf/data/backend-test:top/sub")
+	verifyBackend(t, "/restconf/data/backend-test:top/sub/x", b1)
+}
+
+func verifyBackend(t *testing.T, path string, exp Backend) {
+	t.Helper()
+	if b := getBackend(path); b != exp {
+		t.Fatalf("Expected backend %v for path %s; found %v", exp, path, b)
+	}
+}
+
+func verifyBackendRequest(t *testing.T, b *recordingBackend, method, path, payload string) {
+	t.Helper()
+	if b.method != method {
+		t.Fatalf("Expected backend method %s; found %s", method, b.method)
+	}
+	if b.req.Path != path {
+		t.Fatalf("Expected backend path %s; found %s", path, b.req.Path)
+	}
+	if string(b.req.Payload) != payload {
+		t.Fatalf("Expected payload %s; found %s", payload, b.req.Payload)
+	}
+}
+
+func TestBackendRequestID(t *testing.T) {
+	b := &recordingBackend{}
+	defer useBackend("/restconf/data/backend-test:", b)()
+
+	s := newEmptyRouter()
+	s.addRoute("x", "PATCH", "/restconf/data/backend-test:x", Process)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "PATCH", "/restconf/data/backend-test:x", `{"backend-test:x":1}`))
+	verifyResponse(t, w, 204)
+	if b.req.RequestID != t.Name() {
+		t.Fatalf("Expected backend request id %s; found '%s'", t.Name(), b.req.RequestID)
+	}
+}
+
+func TestTranslibCallLogs(t *testing.T) {
+	var buf bytes.Buffer
+	defer useJSONLogs(&buf)()
+
+	b := translibBackend{}
+	b.Update(BackendRequest{RequestID: "req-1", Path: "/api-tests:top"})
+	b.Delete(BackendRequest{RequestID: "req-2", Path: "/api-tests:top/error/not-found"})
+
+	records := parseJSONLogs(t, &buf)
+	if len(records) != 4 {
+		t.Fatalf("Expected 4 log records; found %d\n%s", len(records), buf.String())
+	}
+	for i, exp := range []string{"req-1", "req-1", "req-2", "req-2"} {
+		if records[i]["request_id"] != exp || !strings.HasPrefix(fmt.Sprint(records[i]["api"]), "translib.") {
+			t.Fatalf("Unexpected log record %d: %v", i, records[i])
+		}
+	}
+	if !strings.Contains(fmt.Sprint(records[3]["msg"]), "NotFoundError") {
+		t.Fatalf("Error not logged: %v", records[3])
+	}
+}
+
+func TestTranslibReplace_creationCheck(t *testing.T) {
+	defer func(v bool) { putCreationCheck = v }(putCreationCheck)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	t.Run("disabled", testTranslibReplace(false, context.Background(), false, "translib.Replace"))
+	t.Run("enabled", testTranslibReplace(true, context.Background(), false, "translib.Get", "translib.Replace"))
+	t.Run("cancelled", testTranslibReplace(true, cancelled, true))
+}
+
+func testTranslibReplace(check bool, ctx context.Context, expErr bool, expAPIs ...string) func(*testing.T) {
+	return func(t *testing.T) {
+		var buf bytes.Buffer
+		defer useJSONLogs(&buf)()
+		putCreationCheck = check
+
+		_, err := translibBackend{}.Replace(BackendRequest{
+			Path: "/api-tests:top/list[name=x]", Context: ctx})
+		if (err != nil) != expErr {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		var apis []string
+		if buf.Len() != 0 {
+			for _, rec := range parseJSONLogs(t, &buf) {
+				if rec["msg"] != "Translib call completed" {
+					apis = append(apis, fmt.Sprint(rec["api"]))
+				}
+			}
+		}
+		if strings.Join(apis, ",") != strings.Join(expAPIs, ",") {
+			t.Fatalf("Expected translib calls %v; found %v", expAPIs, apis)
+		}
+	}
+}
+
+func TestIsListInstancePath(t *testing.T) {
+	for path, exp := range map[string]bool{
+		"/a:top":                         false,
+		"/a:top/list":                    false,
+		"/a:top/list[name=x]":            true,
+		"/a:top/list[name=x]/config/mtu": false,
+		"/a:top/list[name=a/b]":          true,
+		"/a:top/list[name=x]/sub[id=1]":  true,
+		"":                               false,
+	} {
+		if v := isListInstancePath(path); v != exp {
+			t.Errorf("isListInstancePath(%q) returned %v", path, v)
+		}
+	}
+}
+
+var testSonicYang = `
+module sonic-test {
+	namespace "http://test/sonic-test";
+	prefix st;
+	container sonic-test {
+		container TEST_TABLE {
+			list TEST_TABLE_LIST {
+				key "name id";
+				leaf name { type string; }
+				leaf id { type uint32; }
+				leaf mtu { type uint32; }
+			}
+		}
+	}
+}`
+
+func TestCVLErrorPath(t *testing.T) {
+	defer useYangSchema(loadTestYangSchema(t, testSonicYang))()
+
+	for _, tc := range []struct {
+		info cvl.CVLErrorInfo
+		exp  string
+	}{
+		{cvl.CVLErrorInfo{TableName: "TEST_TABLE", Keys: []string{"a]b", "1"}, Field: "mtu"},
+			`/sonic-test:sonic-test/TEST_TABLE/TEST_TABLE_LIST[name=a\]b][id=1]/mtu`},
+		{cvl.CVLErrorInfo{TableName: "TEST_TABLE", Keys: []string{"TEST_TABLE|x|2"}},
+			`/sonic-test:sonic-test/TEST_TABLE/TEST_TABLE_LIST[name=x][id=2]`},
+		{cvl.CVLErrorInfo{TableName: "TEST_TABLE", Keys: []string{"x"}}, "/api-tests:top"},
+		{cvl.CVLErrorInfo{TableName: "UNKNOWN", Keys: []string{"x", "1"}}, "/api-tests:top"},
+	} {
+		if p := cvlErrorPath("/api-tests:top", tc.info); p != tc.exp {
+			t.Errorf("cvlErrorPath(%v) returned \"%s\"; expected \"%s\"", tc.info, p, tc.exp)
+		}
+	}
+}
+
+func TestBulkResponseErrors(t *testing.T) {
+	defer useYangSchema(loadTestYangSchema(t, testSonicYang))()
+
+	cvlErr := func(key string) error {
+		return tlerr.TranslibCVLFailure{
+			Code:         int(cvl.CVL_SEMANTIC_ERROR),
+			CVLErrorInfo: cvl.CVLErrorInfo{TableName: "TEST_TABLE", Keys: []string{key, "1"}, Field: "mtu"},
+		}
+	}
+	ops := []BulkOperation{
+		{Method: "DELETE", Request: BackendRequest{Path: "/api-tests:top/a"}},
+		{Method: "PUT", Request: BackendRequest{Path: "/api-tests:top/b"}},
+		{Method: "PATCH", Request: BackendRequest{Path: "/api-tests:top/c"}},
+	}
+	resp := translib.BulkResponse{
+		DeleteResponse:  []translib.SetResponse{{}},
+		ReplaceResponse: []translib.SetResponse{{Err: cvlErr("x")}},
+		UpdateResponse:  []translib.SetResponse{{Err: cvlErr("y")}},
+	}
+
+	index, err := bulkResponseError(ops, resp, cvlErr("x"))
+	if index != 1 {
+		t.Fatalf("Expected failed operation index 1; found %d", index)
+	}
+	errs, ok := err.(MultiError)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("Expected MultiError of 2 errors; found %T %v", err, err)
+	}
+	for i, e := range errs {
+		if be, ok := e.(BulkOperationError); !ok || be.Index != i+1 {
+			t.Fatalf("Unexpected error %d: %#v", i, e)
+		}
+	}
+
+	// Single failure is returned as is
+	resp.UpdateResponse[0].Err = nil
+	if index, err = bulkResponseError(ops, resp, cvlErr("x")); index != 1 {
+		t.Fatalf("Expected failed operation index 1; found %d", index)
+	}
+	if _, ok := err.(pathError); !ok {
+		t.Fatalf("Expected a pathError; found %T", err)
+	}
+
+	// Unknown failure
+	index, err = bulkResponseError(ops, translib.BulkResponse{}, tlerr.New("failed"))
+	if index != -1 || err == nil {
+		t.Fatalf("Unexpected result %d, %v", index, err)
+	}
+}
+
+func TestCVLErrorResponse(t *testing.T) {
+	defer useYangSchema(loadTestYangSchema(t, testSonicYang))()
+
+	req := &BackendRequest{Path: "/api-tests:top"}
+	err := translibError(req, MultiError{
+		tlerr.TranslibCVLFailure{Code: int(cvl.CVL_SEMANTIC_ERROR),
+			CVLErrorInfo: cvl.CVLErrorInfo{TableName: "TEST_TABLE", Keys: []string{"x", "1"}, Field: "mtu"}},
+		tlerr.TranslibCVLFailure{Code: int(cvl.CVL_SEMANTIC_ERROR),
+			CVLErrorInfo: cvl.CVLErrorInfo{TableName: "OTHER", Keys: []string{"y"}}},
+	})
+
+	r := httptest.NewRequest("PUT", "/restconf/data/api-tests:top", nil)
+	_, data, _ := prepareErrorResponse(err, r)
+
+	var resp errorResponse
+	json.Unmarshal(data, &resp)
+	if len(resp.Err.Arr) != 2 {
+		t.Fatalf("Expected 2 error entries; found %s", data)
+	}
+	for i, exp := range []string{
+		"/sonic-test:sonic-test/TEST_TABLE/TEST_TABLE_LIST[name='x'][id='1']/mtu",
+		"/api-tests:top",
+	} {
+		if p := resp.Err.Arr[i].Path; p != exp {
+			t.Errorf("Expected error-path \"%s\" for entry %d; found \"%s\"", exp, i, p)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"strings"
+)
+
+// batchMaxOperations is the maximum number of operations accepted
+// in a batch request.
+var batchMaxOperations = 1000
+
+func init() {
+	flag.IntVar(&batchMaxOperations, "batch_max_operations", batchMaxOperations,
+		"Maximum number of operations in a batch request")
+
+	AddRoute("batch", "POST", "/restconf/operations/sonic-rest-server:batch", batchHandler)
+}
+
+// batchInput is the input of the batch RPC. Operations of an atomic batch
+// through translib should be ordered as DELETEs, PUTs, PATCHes and then
+// POSTs; since translib applies them in that order in a transaction.
+// Atomic batch is rejected otherwise, with the index of the first out of
+// order operation. Eg:
+//
+//	{"sonic-rest-server:input": {
+//	  "atomic": true,
+//	  "operation": [
+//	    {"method": "DELETE", "path": "/restconf/data/openconfig-acl:acl/acl-sets"},
+//	    {"method": "PATCH", "path": "/restconf/data/openconfig-acl:acl", "body": {...}}
+//	  ]
+//	}}
+type batchInput struct {
+	Atomic    bool             `json:"atomic"`
+	Operation []batchOperation `json:"operation"`
+}
+
+// batchOperation is a RESTCONF write request within a batch.
+type batchOperation struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// batchResult is the result of one operation in a batch, in the same
+// order as the input operations.
+type batchResult struct {
+	Index  int         `json:"index"`
+	Status int         `json:"status"`
+	Error  *errorEntry `json:"error,omitempty"`
+}
+
+// batchHandler serves the batch RPC, which executes an ordered list of
+// RESTCONF write operations in one request. Each operation is validated
+// like a request to its path, before executing any of them; see
+// prepareBatchOp. Operations are executed atomically in a single
+// transaction if "atomic" is true. The backend of all the operations
+// should support BulkBackend interface in that case; and a failure fails
+// the whole request. Otherwise the operations are executed one after the
+// other, irrespective of failures; and the response contains the status
+// of each operation. Errors of the operations carry their index in the
+// "operation-index" error-info.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	rc, r := GetContext(r)
+	log := requestLog(rc)
+
+	input, err := parseBatchInput(r)
+	var ops []*batchOp
+	if err == nil {
+		ops, err = prepareBatchOps(r, rc, input.Operation)
+	}
+
+	var endWrite func()
+	if err == nil {
+		var paths []string
+		for _, op := range ops {
+			paths = append(paths, op.args.path)
+		}
+		endWrite, err = startConfigWrite(r, rc, paths...)
+	}
+	if err != nil {
+		log.Warningf("Invalid batch request; %v", redactError(err))
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	defer endWrite()
+	log.Infof("Batch of %d operations; atomic=%v", len(ops), input.Atomic)
+	var results []batchResult
+	if input.Atomic {
+		results, err = runAtomicBatch(r, rc, ops)
+	} else {
+		results = runBatch(r, rc, ops)
+	}
+
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	var output struct {
+		Result []batchResult `json:"result"`
+	}
+	output.Result = results
+	data, _ := json.Marshal(map[string]interface{}{"sonic-rest-server:output": &output})
+
+	w.Header().Set("Content-Type", mimeYangDataJSON)
+	w.Write(data)
+}
+
+// parseBatchInput reads the batch RPC input from request body.
+func parseBatchInput(r *http.Request) (*batchInput, error) {
+	var input batchInput
+	if err := parseServerRPCInput(r, &input); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(input.Operation) == 0:
+		return nil, httpBadRequest("No operations in batch")
+	case len(input.Operation) > batchMaxOperations:
+		return nil, httpBadRequest("Too many operations in batch; max %d", batchMaxOperations)
+	}
+
+	return &input, nil
+}
+
+// batchOp is a batch operation resolved into translib arguments.
+// Process function fills it, instead of invoking the backend, for the
+// requests prepared by prepareBatchOp.
+type batchOp struct {
+	args     translibArgs
+	backend  Backend
+	err      error // validation error
+	prepared bool  // set by Process
+}
+
+// prepareBatchOps validates the batch operations and resolves their
+// translib arguments and backends. Returns a batchError with the index
+// of the first invalid operation.
+func prepareBatchOps(r *http.Request, rc *RequestContext, operations []batchOperation) ([]*batchOp, error) {
+	routes := getContextValue(r, routerObjContextKey).(*Router).getRoutes()
+	var ops []*batchOp
+
+	for i, op := range operations {
+		bop, err := prepareBatchOp(r, rc, routes, op)
+		if err == nil {
+			err = checkConfigLock(r, rc, bop.args.path)
+		}
+		if err != nil {
+			return nil, batchError{index: i, err: err}
+		}
+		ops = append(ops, bop)
+	}
+
+	return ops, nil
+}
+
+// prepareBatchOp validates a batch operation like a request to its path.
+// Path is matched against the RESTCONF routes of current Router; and the
+// handler of the route is invoked with a copy of the batch request, with
+// the operation's method, path and body (as yang-data json). Handlers of
+// the RESTCONF data routes are expected to set up the RequestContext and
+// call Process, like the generated handlers do. Process validates the
+// request as usual - including the payload model, YANG schema and list
+// keys - and returns the translib arguments through the batchOp, without
+// invoking the backend.
+func prepareBatchOp(r *http.Request, rc *RequestContext, routes *routeStore, op batchOperation) (*batchOp, error) {
+	method := strings.ToUpper(op.Method)
+	switch method {
+	case "POST", "PUT", "PATCH", "DELETE":
+	default:
+		return nil, httpBadRequest("Method '%s' not supported in batch", op.Method)
+	}
+
+	path := cleanPath(op.Path)
+	if !strings.HasPrefix(path, restconfDataPathPrefix) || strings.ContainsAny(op.Path, "?#") {
+		return nil, httpBadRequest("Invalid path '%s'", op.Path)
+	}
+
+	var match routeMatchInfo
+	node, err := routes.rcRoutes.match(path, &match)
+	if err != nil {
+		return nil, err
+	}
+	h, ok := node.handlers[method].(routeHandler)
+	if !ok {
+		return nil, httpError(http.StatusMethodNotAllowed, "Method '%s' not allowed for '%s'", method, op.Path)
+	}
+
+	var body []byte
+	if method != "DELETE" {
+		body = op.Body
+	}
+	opReq, err := http.NewRequest(method, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, httpBadRequest("Invalid path '%s'", op.Path)
+	}
+	opReq.RequestURI = path
+	opReq.Header = r.Header.Clone()
+	opReq.Header.Del("Content-Type")
+	if len(body) != 0 {
+		opReq.Header.Set("Content-Type", mimeYangDataJSON)
+	}
+
+	opRC := &RequestContext{
+		ID:           rc.ID,
+		TraceID:      rc.TraceID,
+		ParentSpanID: rc.ParentSpanID,
+		TraceFlags:   rc.TraceFlags,
+		Name:         h.route.name,
+		Username:     rc.Username,
+	}
+	opRC.Consumes.Add(mimeYangDataJSON)
+
+	bop := new(batchOp)
+	opReq = opReq.WithContext(r.Context())
+	opReq = setContextValue(opReq, requestContextKey, opRC)
+	opReq = setContextValue(opReq, routeMatchContextKey, &match)
+	opReq = setContextValue(opReq, batchOpContextKey, bop)
+	h.route.handler(discardResponseWriter{}, opReq)
+
+	switch {
+	case !bop.prepared:
+		return nil, httpBadRequest("Operation on '%s' not supported in batch", op.Path)
+	case bop.err != nil:
+		return nil, bop.err
+	case bop.args.method == "ACTION":
+		return nil, httpBadRequest("RPCs and actions are not supported in batch")
+	}
+
+	return bop, nil
+}
+
+// discardResponseWriter is a http.ResponseWriter which discards
+// the response.
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Header() http.Header         { return make(http.Header) }
+func (discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardResponseWriter) WriteHeader(int)             {}
+
+// runBatch executes the batch operations one by one and returns
+// their results.
+func runBatch(r *http.Request, rc *RequestContext, ops []*batchOp) []batchResult {
+	results := make([]batchResult, len(ops))
+	for i, op := range ops {
+		status, _, err := invokeBackend(r.Context(), op.backend, &op.args, rc)
+		results[i] = newBatchResult(r, i, status, err)
+	}
+	return results
+}
+
+// runAtomicBatch executes all batch operations in one BulkBackend
+// transaction. Returns an error if the transaction fails; with the index
+// of the failed operation in the error-info.
+func runAtomicBatch(r *http.Request, rc *RequestContext, ops []*batchOp) ([]batchResult, error) {
+	bb, ok := ops[0].backend.(BulkBackend)
+	for _, op := range ops {
+		if !ok || op.backend != ops[0].backend {
+			return nil, httpBadRequest("Atomic batch not supported for the requested paths")
+		}
+	}
+
+	var bulkOps []BulkOperation
+	for _, op := range ops {
+		bulkOps = append(bulkOps, BulkOperation{
+			Method: op.args.method,
+			Request: BackendRequest{
+				Context:       r.Context(),
+				RequestID:     rc.ID,
+				Path:          op.args.path,
+				Payload:       op.args.data,
+				User:          rc.Username,
+				ClientVersion: op.args.version,
+			},
+		})
+	}
+
+	if index, err := bb.Bulk(bulkOps); err != nil {
+		requestLog(rc).Warningf("Atomic batch failed at operation %d; %v", index, redactError(err))
+		if errs, ok := err.(MultiError); ok {
+			var batchErrs MultiError
+			for _, e := range errs {
+				if be, ok := e.(BulkOperationError); ok {
+					e = batchError{index: be.Index, err: be.Err}
+				}
+				batchErrs = append(batchErrs, e)
+			}
+			return nil, batchErrs
+		}
+		if index >= 0 {
+			return nil, batchError{index: index, err: err}
+		}
+		return nil, err
+	}
+
+	results := make([]batchResult, len(ops))
+	for i, op := range bulkOps {
+		results[i] = batchResult{Index: i, Status: http.StatusNoContent}
+		if op.Method == "POST" || (op.Method == "PUT" && op.Response.Created) {
+			results[i].Status = http.StatusCreated
+		}
+	}
+	return results, nil
+}
+
+// newBatchResult creates a batchResult for the status and error
+// of a batch operation.
+func newBatchResult(r *http.Request, index, status int, err error) batchResult {
+	if err == nil {
+		return batchResult{Index: index, Status: status}
+	}
+	status, entry := toErrorEntry(err, r)
+	return batchResult{Index: index, Status: status, Error: &entry}
+}
+
+// batchError is the error from an atomic batch, for the operation at index.
+type batchError struct {
+	index int
+	err   error
+}
+
+func (e batchError) Error() string {
+	return e.err.Error()
+}
@@ -0,0 +1,329 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/sonic-mgmt-common/translib/tlerr"
+)
+
+// bulkTestBackend is a BulkBackend which records the operations
+// and fails the operation at failIndex. Like translib, it rejects the
+// operations not ordered as DELETE, PUT, PATCH and POST. PUT requests
+// are reported as creations.
+type bulkTestBackend struct {
+	recordingBackend
+	ops       []string
+	failIndex int
+}
+
+func (b *bulkTestBackend) Bulk(ops []BulkOperation) (int, error) {
+	b.ops = nil
+	for _, op := range ops {
+		b.ops = append(b.ops, op.Method+" "+op.Request.Path+" "+string(op.Request.Payload))
+	}
+	lastOrder := 0
+	for i, op := range ops {
+		if bulkOrder[op.Method] < lastOrder {
+			return i, tlerr.InvalidArgs("Bulk request operations should be ordered as DELETE, PUT, PATCH and POST")
+		}
+		lastOrder = bulkOrder[op.Method]
+	}
+	if b.failIndex >= 0 && b.failIndex < len(ops) {
+		return b.failIndex, tlerr.NotFound("Resource not found")
+	}
+	for i := range ops {
+		ops[i].Response.Created = (ops[i].Method == "PUT")
+	}
+	return -1, nil
+}
+
+func newBatchTestRouter() *Router {
+	s := newEmptyRouter()
+	s.addRoute("batch", "POST", "/restconf/operations/sonic-rest-server:batch", batchHandler)
+	for _, m := range []string{"PUT", "PATCH", "POST", "DELETE"} {
+		s.addRoute("top", m, "/restconf/data/batch-test:top", Process)
+	}
+	s.addRoute("item", "PUT", "/restconf/data/batch-test:top/item={name}", Process)
+	s.addRoute("item", "DELETE", "/restconf/data/batch-test:top/item={name}", Process)
+	return s
+}
+
+// doBatch invokes the batch RPC with given operations; each one
+// in "METHOD PATH [BODY]" format.
+func doBatch(t *testing.T, s *Router, atomic bool, ops ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	var input batchInput
+	input.Atomic = atomic
+	for _, op := range ops {
+		f := strings.SplitN(op, " ", 3)
+		bop := batchOperation{Method: f[0], Path: f[1]}
+		if len(f) == 3 {
+			bop.Body = json.RawMessage(f[2])
+		}
+		input.Operation = append(input.Operation, bop)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"sonic-rest-server:input": &input})
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "POST", "/restconf/operations/sonic-rest-server:batch", string(body)))
+	return w
+}
+
+// verifyBatchResults checks the status of each operation from
+// a batch response.
+func verifyBatchResults(t *testing.T, w *httptest.ResponseRecorder, expStatus ...int) []batchResult {
+	t.Helper()
+	verifyResponse(t, w, 200)
+
+	var resp map[string]struct {
+		Result []batchResult `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid batch response '%s'; %v", w.Body.String(), err)
+	}
+
+	results := resp["sonic-rest-server:output"].Result
+	var status []int
+	for i, r := range results {
+		if r.Index != i {
+			t.Fatalf("Unexpected index %d for result %d", r.Index, i)
+		}
+		status = append(status, r.Status)
+	}
+	if fmt.Sprint(status) != fmt.Sprint(expStatus) {
+		t.Fatalf("Expected status %v; found %v", expStatus, status)
+	}
+	return results
+}
+
+func TestBatch(t *testing.T) {
+	b := NewMemoryBackend()
+	b.SetListKeys("/top/item", "name")
+	defer useBackend("/restconf/data/batch-test:", b)()
+	s := newBatchTestRouter()
+
+	w := doBatch(t, s, false,
+		`PUT /restconf/data/batch-test:top {"batch-test:top":{"a":1}}`,
+		`PATCH /restconf/data/batch-test:top {"batch-test:top":{"b":2}}`,
+		`DELETE /restconf/data/batch-test:top/item=x`,
+		`put /restconf/data/batch-test:top/item=y {"batch-test:item":[{"name":"y"}]}`,
+	)
+	results := verifyBatchResults(t, w, 201, 204, 404, 201)
+	if e := results[2].Error; e == nil || e.Tag != errtagInvalidValue {
+		t.Fatalf("Unexpected error for DELETE; %+v", e)
+	}
+
+	resp, _ := b.Get(BackendRequest{Path: "/batch-test:top"})
+	if string(resp.Payload) != `{"batch-test:top":{"a":1,"b":2,"item":[{"name":"y"}]}}` {
+		t.Fatalf("Unexpected data after batch; %s", resp.Payload)
+	}
+}
+
+func TestBatch_atomic(t *testing.T) {
+	b := &bulkTestBackend{failIndex: -1}
+	defer useBackend("/restconf/data/batch-test:", b)()
+	s := newBatchTestRouter()
+
+	w := doBatch(t, s, true,
+		`DELETE /restconf/data/batch-test:top/item=x`,
+		`PUT /restconf/data/batch-test:top/item=z {"batch-test:item":[{"name":"z"}]}`,
+		`PATCH /restconf/data/batch-test:top {"batch-test:top":{"b":2}}`,
+		`POST /restconf/data/batch-test:top {"batch-test:item":[{"name":"y"}]}`,
+	)
+	verifyBatchResults(t, w, 204, 201, 204, 201)
+
+	expOps := []string{
+		`DELETE /batch-test:top/item[name=x] `,
+		`PUT /batch-test:top/item[name=z] {"batch-test:item":[{"name":"z"}]}`,
+		`PATCH /batch-test:top {"batch-test:top":{"b":2}}`,
+		`POST /batch-test:top {"batch-test:item":[{"name":"y"}]}`,
+	}
+	if strings.Join(b.ops, "\n") != strings.Join(expOps, "\n") {
+		t.Fatalf("Unexpected bulk operations:\n%s", strings.Join(b.ops, "\n"))
+	}
+
+	b.failIndex = 1
+	w = doBatch(t, s, true,
+		`DELETE /restconf/data/batch-test:top/item=x`,
+		`DELETE /restconf/data/batch-test:top/item=y`,
+	)
+	verifyResponse(t, w, 404)
+	if !strings.Contains(w.Body.String(), `"error-info":{"operation-index":1}`) {
+		t.Fatalf("Operation index not found in error response; %s", w.Body.String())
+	}
+}
+
+func TestBatch_atomicOrder(t *testing.T) {
+	defer useBackend("/restconf/data/batch-test:", &bulkTestBackend{failIndex: -1})()
+	w := doBatch(t, newBatchTestRouter(), true,
+		`PATCH /restconf/data/batch-test:top {"batch-test:top":{"b":2}}`,
+		`DELETE /restconf/data/batch-test:top/item=x`,
+	)
+	verifyResponse(t, w, 400)
+	if !strings.Contains(w.Body.String(), `"operation-index":1`) {
+		t.Fatalf("Operation index not found in error response; %s", w.Body.String())
+	}
+}
+
+func TestBatch_routeHandler(t *testing.T) {
+	defer useBackend("/restconf/data/batch-test:", &bulkTestBackend{failIndex: -1})()
+	s := newBatchTestRouter()
+
+	var names []string
+	s.addRoute("custom", "PATCH", "/restconf/data/batch-test:top/item={name}",
+		func(w http.ResponseWriter, r *http.Request) {
+			rc, r := GetContext(r)
+			names = append(names, rc.Name)
+			Process(w, r)
+		})
+	s.addRoute("noProcess", "PATCH", "/restconf/data/batch-test:top/other",
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(204) })
+
+	w := doBatch(t, s, true,
+		`PATCH /restconf/data/batch-test:top/item=x {"batch-test:item":[{"name":"x"}]}`)
+	verifyBatchResults(t, w, 204)
+	if fmt.Sprint(names) != "[custom]" {
+		t.Fatalf("Route handler not invoked as expected; found %v", names)
+	}
+
+	w = doBatch(t, s, true,
+		`PATCH /restconf/data/batch-test:top/item=x {"batch-test:item":[{"name":"x"}]}`,
+		`PATCH /restconf/data/batch-test:top/other {"batch-test:other":{}}`)
+	verifyResponse(t, w, 400)
+	if !strings.Contains(w.Body.String(), `"operation-index":1`) {
+		t.Fatalf("Operation index not found in error response; %s", w.Body.String())
+	}
+}
+
+func TestBatch_yangValidation(t *testing.T) {
+	defer useYangSchema(loadTestYangSchema(t, payloadTestYang))()
+	defer useBackend("/restconf/data/payload-test:", &bulkTestBackend{failIndex: -1})()
+	defer func(v bool) { yangValidation = v }(yangValidation)
+	yangValidation = true
+
+	s := newBatchTestRouter()
+	s.addRoute("mtu", "PUT", "/restconf/data/payload-test:top/mtu", Process)
+
+	w := doBatch(t, s, false,
+		`PUT /restconf/data/payload-test:top/mtu {"payload-test:mtu":1500}`,
+		`PUT /restconf/data/payload-test:top/mtu {"payload-test:mtu":10}`)
+	verifyResponse(t, w, 400)
+	body := w.Body.String()
+	if !strings.Contains(body, `"operation-index":1`) || !strings.Contains(body, "range") {
+		t.Fatalf("Unexpected error response; %s", body)
+	}
+}
+
+func TestBatch_atomicNotSupported(t *testing.T) {
+	defer useBackend("/restconf/data/batch-test:", &recordingBackend{})()
+	w := doBatch(t, newBatchTestRouter(), true, `DELETE /restconf/data/batch-test:top`)
+	verifyResponse(t, w, 400)
+}
+
+func TestBatch_badInput(t *testing.T) {
+	defer useBackend("/restconf/data/batch-test:", NewMemoryBackend())()
+	s := newBatchTestRouter()
+
+	t.Run("no_ops", func(t *testing.T) {
+		verifyResponse(t, doBatch(t, s, false), 400)
+	})
+	t.Run("GET", func(t *testing.T) {
+		verifyResponse(t, doBatch(t, s, false, "GET /restconf/data/batch-test:top"), 400)
+	})
+	t.Run("not_data_path", func(t *testing.T) {
+		verifyResponse(t, doBatch(t, s, false, "DELETE /restconf/operations/batch-test:top"), 400)
+	})
+	t.Run("query", func(t *testing.T) {
+		verifyResponse(t, doBatch(t, s, false, "DELETE /restconf/data/batch-test:top?depth=1"), 400)
+	})
+	t.Run("unknown_path", func(t *testing.T) {
+		w := doBatch(t, s, false, "DELETE /restconf/data/batch-test:top", "DELETE /restconf/data/batch-test:xyz")
+		verifyResponse(t, w, 404)
+		if !strings.Contains(w.Body.String(), `"operation-index":1`) {
+			t.Fatalf("Operation index not found in error response; %s", w.Body.String())
+		}
+	})
+	t.Run("no_route", func(t *testing.T) {
+		verifyResponse(t, doBatch(t, s, false, `PATCH /restconf/data/batch-test:top/item=x {}`), 405)
+	})
+	t.Run("key_mismatch", func(t *testing.T) {
+		verifyResponse(t, doBatch(t, s, false,
+			`PUT /restconf/data/batch-test:top/item=x {"batch-test:item":[{"name":"y"}]}`), 400)
+	})
+	t.Run("action", func(t *testing.T) {
+		defer useYangSchema(nil)()
+		verifyResponse(t, doBatch(t, s, false, `POST /restconf/data/batch-test:top {"batch-test:input":{}}`), 400)
+	})
+	t.Run("not_input", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, prepareRequest(t, "POST", "/restconf/operations/sonic-rest-server:batch",
+			`{"sonic-rest-server:operation":[]}`))
+		verifyResponse(t, w, 400)
+	})
+	t.Run("too_many", func(t *testing.T) {
+		defer func(n int) { batchMaxOperations = n }(batchMaxOperations)
+		batchMaxOperations = 1
+		verifyResponse(t, doBatch(t, s, false,
+			"DELETE /restconf/data/batch-test:top", "DELETE /restconf/data/batch-test:top"), 400)
+	})
+}
+
+func TestTranslibBulk(t *testing.T) {
+	ops := func(methodPaths ...string) []BulkOperation {
+		var list []BulkOperation
+		for _, mp := range methodPaths {
+			f := strings.Fields(mp)
+			list = append(list, BulkOperation{Method: f[0], Request: BackendRequest{Path: f[1]}})
+		}
+		return list
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		i, err := translibBackend{}.Bulk(ops("DELETE /api-tests:a", "PUT /api-tests:b", "POST /api-tests:c"))
+		if i != -1 || err != nil {
+			t.Fatalf("Unexpected result %d, %v", i, err)
+		}
+	})
+	t.Run("bad_order", func(t *testing.T) {
+		i, err := translibBackend{}.Bulk(ops("PATCH /api-tests:a", "PUT /api-tests:b"))
+		if _, ok := err.(tlerr.InvalidArgsError); !ok || i != 1 {
+			t.Fatalf("Unexpected result %d, %v", i, err)
+		}
+	})
+	t.Run("bad_method", func(t *testing.T) {
+		i, err := translibBackend{}.Bulk(ops("GET /api-tests:a"))
+		if _, ok := err.(tlerr.NotSupportedError); !ok || i != 0 {
+			t.Fatalf("Unexpected result %d, %v", i, err)
+		}
+	})
+	t.Run("failure", func(t *testing.T) {
+		_, err := translibBackend{}.Bulk(ops("PUT /api-tests:a/error/not-found"))
+		if _, ok := err.(tlerr.NotFoundError); !ok {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	})
+}
@@ -21,11 +21,16 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"mime"
 	"net/http"
 	"regexp"
+	"strings"
 	"sync/atomic"
+	"time"
 )
 
 // RequestContext holds metadata about REST request.
@@ -34,9 +39,24 @@ type RequestContext struct {
 	// Unique reqiest id
 	ID string
 
+	// TraceID is the W3C trace-id for this request; either received
+	// through "traceparent" request header or generated locally.
+	TraceID string
+
+	// ParentSpanID is the parent-id from "traceparent" request header.
+	// Empty if the client did not send a trace context.
+	ParentSpanID string
+
+	// TraceFlags is the trace-flags value from "traceparent" header.
+	TraceFlags string
+
 	// Name represents the operationId from OpenAPI spec
 	Name string
 
+	// Username is the authenticated user name. Empty if
+	// authentication is not enabled or not done yet.
+	Username string
+
 	// "consumes" and "produces" data from OpenAPI spec
 	Consumes MediaTypes
 	Produces MediaTypes
@@ -61,16 +81,38 @@ const (
 	requestContextKey contextkey = iota + 1
 	routerObjContextKey
 	routeMatchContextKey
+	spanContextKey
+	batchOpContextKey
 )
 
-// Request Id generator
-var requestCounter uint64
+const (
+	// requestIDHeader is the http header for request id. Server accepts
+	// request id from client through this header and also echoes the
+	// request id in the response.
+	requestIDHeader = "X-Request-ID"
+
+	// traceParentHeader is the W3C trace context header
+	traceParentHeader = "traceparent"
+)
+
+// requestIDExpr is the regex to validate client provided request id.
+// Only a limited set of characters are allowed, to keep the logs sane.
+var requestIDExpr = regexp.MustCompile(`^[a-zA-Z0-9_.:@/+=-]{1,128}$`)
+
+// traceParentExpr is the regex to parse W3C traceparent header value,
+// "version-traceid-parentid-flags". Captures traceid, parentid & flags.
+var traceParentExpr = regexp.MustCompile(
+	`^[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(?:-.*)?$`)
 
 // GetContext function returns the RequestContext object for a
 // HTTP request. RequestContext is maintained as a context value of
 // the request. Creates a new RequestContext object is not already
 // available; in which case this function also creates a copy of
 // the HTTP request object with new context.
+//
+// Request id of the new RequestContext is taken from the X-Request-ID
+// header or the trace-id of traceparent header, if present. Otherwise
+// a new random id is generated.
 func GetContext(r *http.Request) (*RequestContext, *http.Request) {
 	cv := r.Context().Value(requestContextKey)
 	if cv != nil {
@@ -78,12 +120,49 @@ func GetContext(r *http.Request) (*RequestContext, *http.Request) {
 	}
 
 	rc := new(RequestContext)
-	rc.ID = fmt.Sprintf("REST-%v", atomic.AddUint64(&requestCounter, 1))
+
+	tp := traceParentExpr.FindStringSubmatch(r.Header.Get(traceParentHeader))
+	if tp != nil && !isAllZeros(tp[1]) && !isAllZeros(tp[2]) {
+		rc.TraceID = tp[1]
+		rc.ParentSpanID = tp[2]
+		rc.TraceFlags = tp[3]
+	} else {
+		rc.TraceID = newTraceID()
+	}
+
+	if id := r.Header.Get(requestIDHeader); requestIDExpr.MatchString(id) {
+		rc.ID = id
+	} else {
+		rc.ID = rc.TraceID
+	}
 
 	r = setContextValue(r, requestContextKey, rc)
 	return rc, r
 }
 
+// newTraceID returns a random 16 byte id, hex encoded. It is used as
+// the W3C trace-id and request id for requests without one.
+func newTraceID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		// Should not happen.. Fallback to time+counter based id
+		binary.BigEndian.PutUint64(id[:], uint64(time.Now().UnixNano()))
+		binary.BigEndian.PutUint64(id[8:], atomic.AddUint64(&requestCounter, 1))
+	}
+
+	return hex.EncodeToString(id[:])
+}
+
+// requestCounter is used to generate request ids when random
+// number generator fails.
+var requestCounter uint64
+
+// isAllZeros checks if a hex string s contains only zeros.
+// W3C trace context treats such ids as invalid.
+func isAllZeros(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
+
 // setContextValue sets a new value into http request's context.
 // Returns the new http.Request object containing the new context.
 func setContextValue(r *http.Request, k contextkey, v interface{}) *http.Request {
@@ -154,6 +233,12 @@ func (m *MediaType) isJSON() bool {
 	return m.TypeSuffix == "json"
 }
 
+// isXML function checks if this media type represents an xml
+// content. Uses the suffix part of media type string.
+func (m *MediaType) isXML() bool {
+	return m.TypeSuffix == "xml"
+}
+
 func matchPart(x, y string) bool {
 	return x == y || x == "*" || y == "*"
 }
@@ -22,6 +22,7 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
@@ -37,8 +38,6 @@ func TestGetContext(t *testing.T) {
 		t.Fatalf("Unexpected error; %v", err)
 	}
 
-	idSufix := fmt.Sprintf("-%v", requestCounter+1)
-
 	rc1, r := GetContext(r)
 	rc2, r := GetContext(r)
 	rc3, r := GetContext(r)
@@ -47,8 +46,74 @@ func TestGetContext(t *testing.T) {
 		t.Fatalf("Got duplicate contexts!!")
 	}
 
-	if !strings.HasSuffix(rc1.ID, idSufix) {
-		t.Fatalf("Unexpected id '%s'; expected suffix '%s", rc1.ID, idSufix)
+	if len(rc1.ID) != 32 || rc1.ID != rc1.TraceID {
+		t.Fatalf("Unexpected id '%s'; trace-id '%s'", rc1.ID, rc1.TraceID)
+	}
+
+	rc4, _ := GetContext(httptest.NewRequest("GET", "/index.html", nil))
+	if rc4.ID == rc1.ID {
+		t.Fatalf("Got duplicate request id '%s'", rc1.ID)
+	}
+}
+
+func TestGetContext_requestID(t *testing.T) {
+	t.Run("valid", testRequestID("abc-123.XYZ:1", "", "abc-123.XYZ:1", ""))
+	t.Run("invalid", testRequestID("bad id\nwith space", "", "", ""))
+	t.Run("too_long", testRequestID(strings.Repeat("x", 129), "", "", ""))
+	t.Run("traceparent", testRequestID("",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"0af7651916cd43dd8448eb211c80319c", "0af7651916cd43dd8448eb211c80319c"))
+	t.Run("both", testRequestID("myid",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"myid", "0af7651916cd43dd8448eb211c80319c"))
+	t.Run("bad_traceparent", testRequestID("",
+		"00-0af7651916cd43dd8448eb211c80319c-xxxx-01", "", ""))
+	t.Run("zero_traceparent", testRequestID("",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01", "", ""))
+}
+
+// testRequestID verifies request id and trace-id resolved from request
+// headers. Empty expID or expTraceID indicates a generated value.
+func testRequestID(reqID, traceparent, expID, expTraceID string) func(*testing.T) {
+	return func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/index.html", nil)
+		if reqID != "" {
+			r.Header.Set("X-Request-ID", reqID)
+		}
+		if traceparent != "" {
+			r.Header.Set("traceparent", traceparent)
+		}
+
+		rc, _ := GetContext(r)
+		if expID != "" && rc.ID != expID {
+			t.Fatalf("Expected request id '%s'; found '%s'", expID, rc.ID)
+		}
+		if expID == "" && (rc.ID == reqID || len(rc.ID) != 32) {
+			t.Fatalf("Expected a generated request id; found '%s'", rc.ID)
+		}
+		if expTraceID != "" && rc.TraceID != expTraceID {
+			t.Fatalf("Expected trace-id '%s'; found '%s'", expTraceID, rc.TraceID)
+		}
+		if expTraceID == "" && (len(rc.TraceID) != 32 || rc.ParentSpanID != "") {
+			t.Fatalf("Expected a generated trace-id; found '%s', parent '%s'",
+				rc.TraceID, rc.ParentSpanID)
+		}
+	}
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	s := newEmptyRouter()
+	s.addRoute("reqid", "GET", "/restconf/reqid", newHandler(200))
+
+	for _, path := range []string{"/restconf/reqid", "/restconf/unknown"} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("X-Request-ID", "test-req-1")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if id := w.Header().Get("X-Request-ID"); id != "test-req-1" {
+			t.Fatalf("Expected X-Request-ID 'test-req-1' for %s; found '%s'", path, id)
+		}
 	}
 }
 
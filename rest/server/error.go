@@ -23,6 +23,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/Azure/sonic-mgmt-common/cvl"
 	"github.com/Azure/sonic-mgmt-common/translib/tlerr"
@@ -63,8 +64,54 @@ const (
 	errtagResourceDenied        errtag = "resource-denied"
 	errtagInUse                 errtag = "in-use"
 	errtagMalformedMessage      errtag = "malformed-message"
+	errtagDataExists            errtag = "data-exists"
+	errtagDataMissing           errtag = "data-missing"
+	errtagMissingElement        errtag = "missing-element"
+	errtagBadElement            errtag = "bad-element"
+	errtagUnknownElement        errtag = "unknown-element"
+	errtagLockDenied            errtag = "lock-denied"
 )
 
+// cvlErrorMapping is the RESTCONF error mapping for a CVL error code.
+type cvlErrorMapping struct {
+	name   string // CVL error code name; used in message keys
+	status int
+	tag    errtag
+	appTag string // default error-app-tag, if CVL does not provide one
+	msgID  string // message id, to use instead of the CVL message
+}
+
+// cvlErrorMap is the RESTCONF error mapping for all CVL error codes.
+// Syntax errors in the data are 400; violations of constraints involving
+// other data (like leafref, must and when) are 422; conflicts with existing
+// data are 409; and min/max elements violations are 412, as per RFC8040
+// and RFC7950. CVL errors with unknown codes are treated as server errors.
+var cvlErrorMap = map[cvl.CVLRetCode]cvlErrorMapping{
+	cvl.CVL_ERROR:                           {"CVL_ERROR", http.StatusInternalServerError, errtagOperationFailed, "", ""},
+	cvl.CVL_NOT_IMPLEMENTED:                 {"CVL_NOT_IMPLEMENTED", http.StatusNotImplemented, errtagOperationNotSupported, "", ""},
+	cvl.CVL_INTERNAL_UNKNOWN:                {"CVL_INTERNAL_UNKNOWN", http.StatusInternalServerError, errtagOperationFailed, "", ""},
+	cvl.CVL_FAILURE:                         {"CVL_FAILURE", http.StatusInternalServerError, errtagOperationFailed, "", ""},
+	cvl.CVL_SYNTAX_ERROR:                    {"CVL_SYNTAX_ERROR", http.StatusBadRequest, errtagInvalidValue, "", ""},
+	cvl.CVL_SEMANTIC_ERROR:                  {"CVL_SEMANTIC_ERROR", http.StatusUnprocessableEntity, errtagInvalidValue, "", ""},
+	cvl.CVL_SYNTAX_MISSING_FIELD:            {"CVL_SYNTAX_MISSING_FIELD", http.StatusBadRequest, errtagMissingElement, "", ""},
+	cvl.CVL_SYNTAX_INVALID_FIELD:            {"CVL_SYNTAX_INVALID_FIELD", http.StatusBadRequest, errtagUnknownElement, "", ""},
+	cvl.CVL_SYNTAX_INVALID_INPUT_DATA:       {"CVL_SYNTAX_INVALID_INPUT_DATA", http.StatusBadRequest, errtagInvalidValue, "", ""},
+	cvl.CVL_SYNTAX_MULTIPLE_INSTANCE:        {"CVL_SYNTAX_MULTIPLE_INSTANCE", http.StatusBadRequest, errtagBadElement, "", ""},
+	cvl.CVL_SYNTAX_DUPLICATE:                {"CVL_SYNTAX_DUPLICATE", http.StatusConflict, errtagDataExists, "", ""},
+	cvl.CVL_SYNTAX_ENUM_INVALID:             {"CVL_SYNTAX_ENUM_INVALID", http.StatusBadRequest, errtagInvalidValue, "", ""},
+	cvl.CVL_SYNTAX_ENUM_INVALID_NAME:        {"CVL_SYNTAX_ENUM_INVALID_NAME", http.StatusBadRequest, errtagInvalidValue, "", ""},
+	cvl.CVL_SYNTAX_ENUM_WHITESPACE:          {"CVL_SYNTAX_ENUM_WHITESPACE", http.StatusBadRequest, errtagInvalidValue, "", ""},
+	cvl.CVL_SYNTAX_OUT_OF_RANGE:             {"CVL_SYNTAX_OUT_OF_RANGE", http.StatusBadRequest, errtagInvalidValue, "", ""},
+	cvl.CVL_SYNTAX_MINIMUM_INVALID:          {"CVL_SYNTAX_MINIMUM_INVALID", http.StatusPreconditionFailed, errtagOperationFailed, "too-few-elements", ""},
+	cvl.CVL_SYNTAX_MAXIMUM_INVALID:          {"CVL_SYNTAX_MAXIMUM_INVALID", http.StatusPreconditionFailed, errtagOperationFailed, "too-many-elements", ""},
+	cvl.CVL_SEMANTIC_DEPENDENT_DATA_MISSING: {"CVL_SEMANTIC_DEPENDENT_DATA_MISSING", http.StatusUnprocessableEntity, errtagDataMissing, "instance-required", ""},
+	cvl.CVL_SEMANTIC_MANDATORY_DATA_MISSING: {"CVL_SEMANTIC_MANDATORY_DATA_MISSING", http.StatusUnprocessableEntity, errtagDataMissing, "", ""},
+	cvl.CVL_SEMANTIC_KEY_ALREADY_EXIST:      {"CVL_SEMANTIC_KEY_ALREADY_EXIST", http.StatusConflict, errtagDataExists, "", msgEntryExists},
+	cvl.CVL_SEMANTIC_KEY_NOT_EXIST:          {"CVL_SEMANTIC_KEY_NOT_EXIST", http.StatusNotFound, errtagInvalidValue, "", msgEntryNotFound},
+	cvl.CVL_SEMANTIC_KEY_DUPLICATE:          {"CVL_SEMANTIC_KEY_DUPLICATE", http.StatusConflict, errtagDataExists, "", msgEntryExists},
+	cvl.CVL_SEMANTIC_KEY_INVALID:            {"CVL_SEMANTIC_KEY_INVALID", http.StatusBadRequest, errtagBadElement, "", ""},
+}
+
 // cvlErrorData holds error-info data for cvl errors.
 type cvlErrorData struct {
 	Code  int      `json:"error-code,omitempty"`
@@ -101,26 +148,103 @@ func httpServerError(msg string, args ...interface{}) error {
 	return httpError(http.StatusInternalServerError, msg, args...)
 }
 
+// MultiError is a collection of errors from a request; like validation
+// errors of different data nodes in the payload. Each error is reported
+// as a separate entry in the RESTCONF error response. HTTP status is
+// derived from the first error. Backends can return a MultiError to
+// report all the errors instead of the first one.
+type MultiError []error
+
+func (e MultiError) Error() string {
+	var msgs []string
+	for _, x := range e {
+		msgs = append(msgs, x.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// append adds err to the MultiError. Errors of a MultiError err
+// are added individually. Nil err is ignored.
+func (e MultiError) append(err error) MultiError {
+	if m, ok := err.(MultiError); ok {
+		return append(e, m...)
+	}
+	if err != nil {
+		return append(e, err)
+	}
+	return e
+}
+
+// err returns nil if there are no errors, the error itself if there
+// is only one; otherwise the MultiError.
+func (e MultiError) err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	}
+	return e
+}
+
+// pathError adds an error path to an error which does not carry one;
+// like the CVL errors. Path is a translib path.
+type pathError struct {
+	err  error
+	path string
+}
+
+func (e pathError) Error() string {
+	return e.err.Error()
+}
+
 // prepareErrorResponse returns HTTP status code and response payload
 // for an error object. Response payalod is formatted as per RESTCONF
 // specification (RFC8040, section 7.1). Uses json encoding.
 func prepareErrorResponse(err error, r *http.Request) (status int, data []byte, mimeType string) {
-	status, entry := toErrorEntry(err, r)
 	var resp errorResponse
-	resp.Err.Arr = append(resp.Err.Arr, entry)
+	status, resp.Err.Arr = toErrorEntries(err, r)
 	data, _ = json.Marshal(&resp)
 	mimeType = "application/yang-data+json"
 	return
 }
 
+// toErrorEntries translates an error object into HTTP status and a list
+// of errorEntry objects. Returns one errorEntry per error for MultiError;
+// and HTTP status of its first error.
+func toErrorEntries(err error, r *http.Request) (int, []errorEntry) {
+	errs, ok := err.(MultiError)
+	if !ok || len(errs) == 0 {
+		status, entry := toErrorEntry(err, r)
+		return status, []errorEntry{entry}
+	}
+
+	var entries []errorEntry
+	var status int
+	for i, e := range errs {
+		s, entry := toErrorEntry(e, r)
+		if i == 0 {
+			status = s
+		}
+		entries = append(entries, entry)
+	}
+	return status, entries
+}
+
 // toErrorEntry translates an error object into HTTP status and an
-// errorEntry object.
+// errorEntry object. Only the first error is translated for MultiError.
+// Error paths from translib errors are converted into RFC8040 instance
+// identifier format. Error message is localized using the message
+// catalog, based on the Accept-Language header of request r.
 func toErrorEntry(err error, r *http.Request) (status int, errInfo errorEntry) {
 	// By default everything is 500 Internal Server Error
 	status = http.StatusInternalServerError
 	errInfo.Type = errtypeApplication
 	errInfo.Tag = errtagOperationFailed
 
+	// Message catalog keys, other than the tags
+	var cvlKey, msgID string
+
 	switch e := err.(type) {
 	case httpErrorType:
 		status = e.status
@@ -161,13 +285,24 @@ func toErrorEntry(err error, r *http.Request) (status int, errInfo errorEntry) {
 	case tlerr.TranslibRedisClientEntryNotExist:
 		status = http.StatusNotFound
 		errInfo.Tag = errtagInvalidValue
-		errInfo.Message = "Entry not found"
+		msgID = msgEntryNotFound
 
 	case tlerr.TranslibCVLFailure:
-		status = http.StatusInternalServerError
-		errInfo.Tag = errtagInvalidValue
 		errInfo.Message = e.CVLErrorInfo.ConstraintErrMsg
+		if len(errInfo.Message) == 0 {
+			errInfo.Message = e.CVLErrorInfo.CVLErrDetails
+		}
 		errInfo.AppTag = e.CVLErrorInfo.ErrAppTag
+		cvlKey = cvlMessageKey(e.Code)
+
+		if m, ok := cvlErrorMap[cvl.CVLRetCode(e.Code)]; ok {
+			status = m.status
+			errInfo.Tag = m.tag
+			msgID = m.msgID
+			if len(errInfo.AppTag) == 0 {
+				errInfo.AppTag = m.appTag
+			}
+		}
 
 		errInfo.ErrInfo = map[string]interface{}{
 			"cvl-error": cvlErrorData{
@@ -181,53 +316,138 @@ func toErrorEntry(err error, r *http.Request) (status int, errInfo errorEntry) {
 			},
 		}
 
-		switch cvl.CVLRetCode(e.Code) {
-		case cvl.CVL_SEMANTIC_KEY_ALREADY_EXIST, cvl.CVL_SEMANTIC_KEY_DUPLICATE:
-			status = http.StatusConflict
-			errInfo.Tag = errtagResourceDenied
-			errInfo.Message = "Entry already exists"
-
-		case cvl.CVL_SEMANTIC_KEY_NOT_EXIST:
-			status = http.StatusNotFound
-			errInfo.Tag = errtagInvalidValue
-			errInfo.Message = "Entry not found"
-		}
-
 	case tlerr.TranslibTransactionFail:
 		status = http.StatusConflict
 		errInfo.Type = errtypeProtocol
 		errInfo.Tag = errtagInUse
-		errInfo.Message = "Transaction failed. Please try again."
+		msgID = msgTransactionFailed
 
 	case tlerr.InternalError:
 		errInfo.Message = e.Error()
-		errInfo.Path = e.Path
+		errInfo.Path = toInstanceID(e.Path)
 
 	case tlerr.NotSupportedError:
 		status = http.StatusMethodNotAllowed
 		errInfo.Tag = errtagOperationNotSupported
 		errInfo.Message = e.Error()
-		errInfo.Path = e.Path
+		errInfo.Path = toInstanceID(e.Path)
 
 	case tlerr.InvalidArgsError:
 		status = http.StatusBadRequest
 		errInfo.Tag = errtagInvalidValue
 		errInfo.Message = e.Error()
-		errInfo.Path = e.Path
+		errInfo.Path = toInstanceID(e.Path)
 
 	case tlerr.NotFoundError:
 		status = http.StatusNotFound
 		errInfo.Tag = errtagInvalidValue
 		errInfo.Message = e.Error()
-		errInfo.Path = e.Path
+		errInfo.Path = toInstanceID(e.Path)
 
 	case tlerr.AlreadyExistsError:
 		status = http.StatusConflict
 		errInfo.Tag = errtagResourceDenied
 		errInfo.Message = e.Error()
-		errInfo.Path = e.Path
+		errInfo.Path = toInstanceID(e.Path)
 
+	case payloadError:
+		status = http.StatusBadRequest
+		errInfo.Type = errtypeProtocol
+		errInfo.Tag = errtagInvalidValue
+		errInfo.Message = e.Message
+		errInfo.Path = toInstanceID(e.Path)
+		errInfo.ErrInfo = map[string]interface{}{"validation-error": e.Info}
+
+	case lockDeniedError:
+		status = http.StatusConflict
+		errInfo.Type = errtypeProtocol
+		errInfo.Tag = errtagLockDenied
+		errInfo.Message = e.Error()
+		errInfo.ErrInfo = map[string]interface{}{"lock-holder": e.holder}
+
+	case writeInProgressError:
+		status = http.StatusConflict
+		errInfo.Type = errtypeProtocol
+		errInfo.Tag = errtagInUse
+		errInfo.Message = e.Error()
+
+	case MultiError:
+		if len(e) != 0 {
+			return toErrorEntry(e[0], r)
+		}
+
+	case pathError:
+		status, errInfo = toErrorEntry(e.err, r)
+		if len(errInfo.Path) == 0 {
+			errInfo.Path = toInstanceID(e.path)
+		}
+		return // message was localized already
+
+	case batchError:
+		status, errInfo = toErrorEntry(e.err, r)
+		if m, ok := errInfo.ErrInfo.(map[string]interface{}); ok {
+			m["operation-index"] = e.index
+		} else if errInfo.ErrInfo == nil {
+			errInfo.ErrInfo = map[string]interface{}{"operation-index": e.index}
+		}
+		return // message was localized already
+
+	default:
+		// Unknown errors are server errors; but retain their message
+		if err != nil {
+			errInfo.Message = err.Error()
+		}
+	}
+
+	// Error-tag messages are generic; they should not replace the
+	// detailed message of an error.
+	keys := []string{cvlKey, msgID}
+	if len(errInfo.Message) == 0 {
+		keys = append(keys, "tag:"+string(errInfo.Tag))
+	}
+	if len(errInfo.AppTag) != 0 {
+		keys = append([]string{"app-tag:" + errInfo.AppTag}, keys...)
 	}
+	errInfo.Message = localizeMessage(r, errInfo.Message, keys...)
 
 	return
 }
+
+// toInstanceID converts a translib path into an instance-identifier
+// string, as used in the RESTCONF error-path (RFC8040, section 7.1).
+// List key predicates "[name=value]" are changed to "[name='value']";
+// with double quotes if the value contains a single quote. Escape
+// characters of the translib path are removed from the values. Other
+// predicates, like list positions, are retained as is.
+//
+// Eg, "/openconfig-acl:acl/acl-sets/acl-set[name=X][type=ACL_IPV4]"
+// becomes "/openconfig-acl:acl/acl-sets/acl-set[name='X'][type='ACL_IPV4']".
+func toInstanceID(path string) string {
+	if strings.IndexByte(path, '[') < 0 {
+		return path
+	}
+
+	var buf strings.Builder
+	for _, elem := range splitTranslibPath(path) {
+		name, keys := splitElemKeys(elem)
+		buf.WriteString("/" + name)
+
+		// splitElemKeys drops the predicates without '='
+		if len(keys) == 0 {
+			buf.WriteString(elem[len(name):])
+			continue
+		}
+
+		for _, kv := range keys {
+			k := strings.IndexByte(kv, '=')
+			v := unescapeKeyValue(kv[k+1:])
+			if strings.IndexByte(v, '\'') >= 0 {
+				fmt.Fprintf(&buf, "[%s=\"%s\"]", kv[:k], v)
+			} else {
+				fmt.Fprintf(&buf, "[%s='%s']", kv[:k], v)
+			}
+		}
+	}
+
+	return buf.String()
+}
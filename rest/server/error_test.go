@@ -92,7 +92,7 @@ func TestErrorEntry(t *testing.T) {
 
 	t.Run("UnknownError", testErrorEntry(
 		errors.New("hii"),
-		500, "application", "operation-failed", "", ""))
+		500, "application", "operation-failed", "", "hii"))
 
 	// errorEntry mapping for app errors
 
@@ -100,6 +100,10 @@ func TestErrorEntry(t *testing.T) {
 		tlerr.InvalidArgsError{Format: "hii", Path: "xyz"},
 		400, "application", "invalid-value", "xyz", "hii"))
 
+	t.Run("InvalidArgs_listPath", testErrorEntry(
+		tlerr.InvalidArgsError{Format: "hii", Path: "/a:x/y[k1=v1][k2=v\\]2]/z"},
+		400, "application", "invalid-value", "/a:x/y[k1='v1'][k2='v]2']/z", "hii"))
+
 	t.Run("ResourceNotFound", testErrorEntry(
 		tlerr.NotFoundError{Format: "hii", Path: "xyz"},
 		404, "application", "invalid-value", "xyz", "hii"))
@@ -116,6 +120,10 @@ func TestErrorEntry(t *testing.T) {
 		tlerr.InternalError{Format: "hii", Path: "xyz"},
 		500, "application", "operation-failed", "xyz", "hii"))
 
+	t.Run("PayloadError", testErrorEntry(
+		payloadError{Path: "/a:x/y[k=v]/z", Message: "hii"},
+		400, "protocol", "invalid-value", "/a:x/y[k='v']/z", "hii"))
+
 	// errorEntry mapping for DB errors
 
 	t.Run("DB_EntryNotExist", testErrorEntry(
@@ -128,15 +136,15 @@ func TestErrorEntry(t *testing.T) {
 
 	t.Run("DB_CannotOpen", testErrorEntry(
 		tlerr.TranslibDBCannotOpen{},
-		500, "application", "operation-failed", "", ""))
+		500, "application", "operation-failed", "", "!"))
 
 	t.Run("DB_NotInit", testErrorEntry(
 		tlerr.TranslibDBNotInit{},
-		500, "application", "operation-failed", "", ""))
+		500, "application", "operation-failed", "", "!"))
 
 	t.Run("DB_SubscribeFailed", testErrorEntry(
 		tlerr.TranslibDBSubscribeFail{},
-		500, "application", "operation-failed", "", ""))
+		500, "application", "operation-failed", "", "!"))
 
 	// errorEntry mapping for CVL errors
 
@@ -146,15 +154,55 @@ func TestErrorEntry(t *testing.T) {
 
 	t.Run("CVL_KeyExists", testErrorEntry(
 		cvlError(cvl.CVL_SEMANTIC_KEY_ALREADY_EXIST, "hii"),
-		409, "application", "resource-denied", "", "Entry already exists"))
+		409, "application", "data-exists", "", "Entry already exists"))
 
 	t.Run("CVL_KeyDup", testErrorEntry(
 		cvlError(cvl.CVL_SEMANTIC_KEY_DUPLICATE, "hii"),
-		409, "application", "resource-denied", "", "Entry already exists"))
+		409, "application", "data-exists", "", "Entry already exists"))
 
 	t.Run("CVL_SemanticErr", testErrorEntry(
 		cvlError(cvl.CVL_SEMANTIC_ERROR, "hii"),
-		500, "application", "invalid-value", "", "hii"))
+		422, "application", "invalid-value", "", "hii"))
+
+	t.Run("CVL_LeafrefMissing", testErrorEntry(
+		cvlError(cvl.CVL_SEMANTIC_DEPENDENT_DATA_MISSING, "hii"),
+		422, "application", "data-missing", "", "hii"))
+
+	t.Run("CVL_MandatoryMissing", testErrorEntry(
+		cvlError(cvl.CVL_SEMANTIC_MANDATORY_DATA_MISSING, "hii"),
+		422, "application", "data-missing", "", "hii"))
+
+	t.Run("CVL_OutOfRange", testErrorEntry(
+		cvlError(cvl.CVL_SYNTAX_OUT_OF_RANGE, "hii"),
+		400, "application", "invalid-value", "", "hii"))
+
+	t.Run("CVL_MissingField", testErrorEntry(
+		cvlError(cvl.CVL_SYNTAX_MISSING_FIELD, "hii"),
+		400, "application", "missing-element", "", "hii"))
+
+	t.Run("CVL_InvalidField", testErrorEntry(
+		cvlError(cvl.CVL_SYNTAX_INVALID_FIELD, "hii"),
+		400, "application", "unknown-element", "", "hii"))
+
+	t.Run("CVL_MultipleInstance", testErrorEntry(
+		cvlError(cvl.CVL_SYNTAX_MULTIPLE_INSTANCE, "hii"),
+		400, "application", "bad-element", "", "hii"))
+
+	t.Run("CVL_MaxElements", testErrorEntry(
+		cvlError(cvl.CVL_SYNTAX_MAXIMUM_INVALID, "hii"),
+		412, "application", "operation-failed", "", "hii"))
+
+	t.Run("CVL_Internal", testErrorEntry(
+		cvlError(cvl.CVL_INTERNAL_UNKNOWN, "hii"),
+		500, "application", "operation-failed", "", "hii"))
+
+	t.Run("CVL_UnknownCode", testErrorEntry(
+		cvlError(cvl.CVLRetCode(9999), "hii"),
+		500, "application", "operation-failed", "", "hii"))
+
+	t.Run("CVL_NoMessage", testErrorEntry(
+		cvlError(cvl.CVL_SYNTAX_ERROR, ""),
+		400, "application", "invalid-value", "", "blah blah blah"))
 
 	// errorEntry mapping for YGOT errors
 	t.Run("YGOT_400", testErrorEntry(
@@ -168,6 +216,21 @@ func TestErrorEntry(t *testing.T) {
 
 }
 
+func TestErrorEntry_cvlAppTag(t *testing.T) {
+	_, entry := toErrorEntry(cvlError(cvl.CVL_SEMANTIC_DEPENDENT_DATA_MISSING, "hii"), nil)
+	if entry.AppTag != "instance-required" {
+		t.Errorf("Expected default error-app-tag 'instance-required'; found '%s'", entry.AppTag)
+	}
+
+	err := tlerr.TranslibCVLFailure{
+		Code:         int(cvl.CVL_SEMANTIC_DEPENDENT_DATA_MISSING),
+		CVLErrorInfo: cvl.CVLErrorInfo{ConstraintErrMsg: "hii", ErrAppTag: "port-missing"},
+	}
+	if _, entry = toErrorEntry(err, nil); entry.AppTag != "port-missing" {
+		t.Errorf("Expected error-app-tag 'port-missing'; found '%s'", entry.AppTag)
+	}
+}
+
 func testErrorEntry(err error,
 	expStatus int, expType, expTag, expPath, expMessage string) func(*testing.T) {
 	return func(t *testing.T) {
@@ -189,10 +252,27 @@ func TestErrorResponse(t *testing.T) {
 			"\"error-type\":\"application\",\"error-tag\":\"invalid-value\","+
 			"\"error-path\":\"xyz\",\"error-message\":\"hii\"}]}}"))
 
+	t.Run("Multi", testErrorResponse(
+		MultiError{
+			tlerr.NotFoundError{Format: "hii", Path: "/a:x"},
+			tlerr.InvalidArgsError{Format: "hello", Path: "/a:y[k=v]"},
+		},
+		404, "{\"ietf-restconf:errors\":{\"error\":[{"+
+			"\"error-type\":\"application\",\"error-tag\":\"invalid-value\","+
+			"\"error-path\":\"/a:x\",\"error-message\":\"hii\"},{"+
+			"\"error-type\":\"application\",\"error-tag\":\"invalid-value\","+
+			"\"error-path\":\"/a:y[k='v']\",\"error-message\":\"hello\"}]}}"))
+
 	t.Run("NoMsg", testErrorResponse(
-		errors.New("hii"),
+		errors.New(""),
 		500, "{\"ietf-restconf:errors\":{\"error\":[{"+
 			"\"error-type\":\"application\",\"error-tag\":\"operation-failed\"}]}}"))
+
+	t.Run("UnknownErr", testErrorResponse(
+		errors.New("hii"),
+		500, "{\"ietf-restconf:errors\":{\"error\":[{"+
+			"\"error-type\":\"application\",\"error-tag\":\"operation-failed\","+
+			"\"error-message\":\"hii\"}]}}"))
 }
 
 func testErrorResponse(err error, expStatus int, expData string) func(*testing.T) {
@@ -210,6 +290,23 @@ func testErrorResponse(err error, expStatus int, expData string) func(*testing.T
 	}
 }
 
+func TestToInstanceID(t *testing.T) {
+	for path, exp := range map[string]string{
+		"":                         "",
+		"xyz":                      "xyz",
+		"/a:x/y":                   "/a:x/y",
+		"/a:x/y[k=v]":              "/a:x/y[k='v']",
+		"/a:x/y[k=v][n=1]/b:z":     "/a:x/y[k='v'][n='1']/b:z",
+		"/a:x/y[k=it's]":           "/a:x/y[k=\"it's\"]",
+		"/a:x/y[k=a/b\\]c\\\\d]/z": "/a:x/y[k='a/b]c\\d']/z",
+		"/a:x/y[2]/z":              "/a:x/y[2]/z",
+	} {
+		if id := toInstanceID(path); id != exp {
+			t.Errorf("toInstanceID(%q) = %q; expected %q", path, id, exp)
+		}
+	}
+}
+
 func chkmsg(actual, expected string) bool {
 	if expected == "*" {
 		return true
@@ -20,10 +20,14 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"regexp"
 	"sort"
 	"strings"
 
@@ -36,25 +40,27 @@ import (
 // from all generated stub functions.
 func Process(w http.ResponseWriter, r *http.Request) {
 	rc, r := GetContext(r)
-	reqID := rc.ID
-	args := translibArgs{}
+	log := requestLog(rc)
 
+	var args translibArgs
 	var err error
 	var status int
 	var data []byte
 	var rtype string
-
-	glog.Infof("[%s] %s %s; content-len=%d", reqID, r.Method, r.URL.Path, r.ContentLength)
-	_, args.data, err = getRequestBody(r, rc)
-
-	if err == nil {
-		err = args.parseMethod(r, rc)
-	}
-	if err == nil {
-		err = args.parseClientVersion(r, rc)
-	}
-	if err == nil {
-		err = args.parseQueryParams(r)
+	var sp *span
+	var backend Backend
+	var endWrite = func() {}
+	defer func() { endWrite() }()
+
+	log.Infof("%s %s; content-len=%d", r.Method, redactURLPath(requestPath(r)), r.ContentLength)
+	args, err = parseRequestArgs(r, rc)
+
+	// Operations of a batch request are only validated here;
+	// batch handler runs them.
+	if op, ok := getContextValue(r, batchOpContextKey).(*batchOp); ok {
+		op.args, op.err, op.prepared = args, err, true
+		op.backend = getBackend(getRouteMatchInfo(r).path)
+		return
 	}
 
 	if err != nil {
@@ -62,16 +68,55 @@ func Process(w http.ResponseWriter, r *http.Request) {
 		goto write_resp
 	}
 
-	args.path = getPathForTranslib(r, rc)
-	glog.V(1).Infof("[%s] Translated path = %s", reqID, args.path)
+	if isWriteOperation(r) {
+		end, werr := startConfigWrite(r, rc, args.path)
+		if werr != nil {
+			status, data, rtype = prepareErrorResponse(werr, r)
+			goto write_resp
+		}
+		endWrite = end
+	}
 
-	status, data, err = invokeTranslib(&args, rc)
+	backend = getBackend(getRouteMatchInfo(r).path)
+	if args.method == "ACTION" && prefersAsync(r) {
+		var job *asyncJob
+		job, err = startAsyncJob(r, rc, backend, args, endWrite)
+		endWrite = func() {} // ended by the job
+		if err == nil {
+			w.Header().Set("Location", job.uri())
+			w.Header().Set("Preference-Applied", "respond-async")
+			status, data, rtype = http.StatusAccepted, job.marshal(), mimeYangDataJSON
+		} else {
+			status, data, rtype = prepareErrorResponse(err, r)
+		}
+		goto write_resp
+	}
+
+	sp, _ = startSpan(r, "backend."+args.method)
+	sp.setAttr("route.name", rc.Name)
+	sp.setAttr("backend.type", fmt.Sprintf("%T", backend))
+	sp.setAttr("translib.path", redactTranslibPath(args.path))
+	sp.setAttr("translib.method", args.method)
+	status, data, err = invokeBackend(r.Context(), backend, &args, rc)
 	if err != nil {
-		glog.Warningf("[%s] Translib error %T - %v", reqID, err, err)
+		log.Warningf("Backend error %T - %v", err, err)
+		sp.setError(err)
 		status, data, rtype = prepareErrorResponse(err, r)
+	}
+
+	sp.setAttr("http.status_code", status)
+	sp.finish()
+	if err != nil {
 		goto write_resp
 	}
 
+	// RFC8040 requires Location header for resources created by POST
+	if args.method == "POST" {
+		if loc := createdResourceURI(r, args.data, rc); len(loc) != 0 {
+			w.Header().Set("Location", loc)
+		}
+	}
+
 	// Special handling for HEAD -- ignore the data but set content-length.
 	// HTTP spec says HEAD can return content-length and content-type as if it was a GET.
 	if r.Method == "HEAD" {
@@ -81,14 +126,19 @@ func Process(w http.ResponseWriter, r *http.Request) {
 
 	rtype, err = resolveResponseContentType(data, r, rc)
 	if err != nil {
-		glog.Warningf("[%s] Failed to resolve response content-type, err=%v", rc.ID, err)
+		log.Warningf("Failed to resolve response content-type, err=%v", err)
 		status, data, rtype = prepareErrorResponse(err, r)
 		goto write_resp
 	}
 
 write_resp:
-	glog.Infof("[%s] Sending response %d, type=%s, size=%d", reqID, status, rtype, len(data))
-	glog.V(1).Infof("[%s] data=%s", reqID, data)
+	log.with("status", status).with("type", rtype).with("size", len(data)).
+		Infof("Sending response")
+	if glog.V(1) && status >= 400 {
+		log.Infof("data=%s", redactErrorPayload(data))
+	} else if glog.V(1) {
+		log.Infof("data=%s", redactPayload(data, payloadParentPath(r)))
+	}
 
 	// Write http response.. Following strict order should be
 	// maintained to form proper response.
@@ -105,6 +155,48 @@ write_resp:
 	}
 }
 
+// parseRequestArgs reads and validates the request body, method, headers
+// and query parameters; and resolves them into translibArgs. Method is
+// resolved to "ACTION" for the POST requests on RPCs and actions.
+func parseRequestArgs(r *http.Request, rc *RequestContext) (translibArgs, error) {
+	var args translibArgs
+	_, data, err := getRequestBody(r, rc)
+	args.data = data
+
+	if err == nil {
+		err = args.parseMethod(r, rc)
+	}
+	if err == nil {
+		err = args.parseClientVersion(r, rc)
+	}
+	if err == nil {
+		err = args.parseQueryParams(r)
+	}
+	if err != nil {
+		return args, err
+	}
+
+	args.path = getPathForTranslib(r, rc)
+	if glog.V(1) {
+		requestLog(rc).Infof("Translated path = %s", redactTranslibPath(args.path))
+	}
+
+	if args.method == "POST" {
+		isAction, err := isActionRequest(r, args.path, args.data)
+		if err != nil {
+			return args, err
+		}
+		if isAction {
+			args.method = "ACTION"
+		}
+	}
+	if args.method == "ACTION" && yangValidation {
+		err = validateRPCInput(args.path, args.data)
+	}
+
+	return args, err
+}
+
 // getRequestID returns the request ID for a http Request r.
 // ID is looked up from the RequestContext associated with this request.
 // Returns empty value if context is not initialized yet.
@@ -155,13 +247,41 @@ func getRequestBody(r *http.Request, rc *RequestContext) (*MediaType, []byte, er
 
 	// Do payload validation if model info is set in the context.
 	if rc.Model != nil {
-		body, err = RequestValidate(body, ct, rc)
+		sp, _ := startSpan(r, "RequestValidate")
+		var validBody []byte
+		validBody, err = RequestValidate(body, ct, rc)
+		if err != nil {
+			err = resolvePayloadErrorPaths(err, r, rc, body)
+			sp.setError(redactError(err))
+		}
+		body = validBody
+		sp.finish()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Validate against YANG schema, which does not need the model info
+	if yangValidation {
+		sp, _ := startSpan(r, "YangValidate")
+		if err = validateYangPayload(r, rc, ct, body); err != nil {
+			requestLog(rc).Warningf("YANG validation failed; %v", redactError(err))
+			sp.setError(redactError(err))
+		}
+		sp.finish()
 		if err != nil {
 			return nil, nil, err
 		}
 	}
 
-	glog.Infof("[%s] Content-type=%s; data=%s", rc.ID, ctype, body)
+	if ct.isJSON() {
+		if err = checkPayloadKeys(r.Method, getPathForTranslib(r, rc), body); err != nil {
+			requestLog(rc).Warningf("Key mismatch; %v", redactError(err))
+			return nil, nil, err
+		}
+	}
+
+	requestLog(rc).Infof("Content-type=%s; data=%s", ctype, redactPayload(body, payloadParentPath(r)))
 	return ct, body, nil
 }
 
@@ -185,6 +305,10 @@ func resolveResponseContentType(data []byte, r *http.Request, rc *RequestContext
 // getPathForTranslib converts REST URIs into GNMI paths
 func getPathForTranslib(r *http.Request, rc *RequestContext) string {
 	match := getRouteMatchInfo(r)
+	if len(match.elems) != 0 {
+		return trimRestconfPrefix(toTranslibPath(match.elems, rc))
+	}
+
 	path := match.path
 	vars := match.vars
 
@@ -194,7 +318,9 @@ func getPathForTranslib(r *http.Request, rc *RequestContext) string {
 	}
 
 	// Path is a template.. Convert it into GNMI style path
-	// WARNING: does not handle duplicate key attribute names
+	// WARNING: does not handle duplicate key attribute names. Only
+	// applicable to mux routes; routeTree matches are converted by
+	// toTranslibPath.
 	//
 	// Template   = /openconfig-acl:acl/acl-sets/acl-set={name},{type}
 	// REST style = /openconfig-acl:acl/acl-sets/acl-set=TEST,ACL_IPV4
@@ -202,21 +328,207 @@ func getPathForTranslib(r *http.Request, rc *RequestContext) string {
 	path = trimRestconfPrefix(path)
 	path = strings.Replace(path, "={", "{", -1)
 	path = strings.Replace(path, "},{", "}{", -1)
-	var err error
 
 	for k, v := range vars {
-		v, err = url.PathUnescape(v)
-		if err != nil {
-			glog.Warningf("Failed to unescape path var \"%s\". err=%v", v, err)
-			v = vars[k]
-		}
-
 		restStyle := fmt.Sprintf("{%v}", k)
-		gnmiStyle := fmt.Sprintf("[%v=%v]", rc.PMap.Get(k), escapeKeyValue(v))
+		gnmiStyle := fmt.Sprintf("[%v=%v]", rc.PMap.Get(k), escapeKeyValue(unescapePathValue(v)))
 		path = strings.Replace(path, restStyle, gnmiStyle, 1)
 	}
 
-	return path
+	// Remove the params of omitted trailing keys; they are treated
+	// as wildcards.
+	return pathParamExpr.ReplaceAllString(path, "")
+}
+
+// toTranslibPath builds a GNMI style path from the path elements matched
+// by routeTree. Key values are bound to the list elements by position,
+// hence same key names can appear at multiple levels.
+//
+// Template   = /interfaces/interface={name}/subinterfaces/subinterface={index}
+// REST style = /interfaces/interface=Ethernet0/subinterfaces/subinterface=1
+// GNMI style = /interfaces/interface[name=Ethernet0]/subinterfaces/subinterface[index=1]
+func toTranslibPath(elems []routeMatchElem, rc *RequestContext) string {
+	var buf strings.Builder
+	for _, e := range elems {
+		buf.WriteString(e.node.name)
+		for i, v := range e.values {
+			fmt.Fprintf(&buf, "[%v=%v]", rc.PMap.Get(e.node.params[i]), escapeKeyValue(unescapePathValue(v)))
+		}
+	}
+
+	return buf.String()
+}
+
+// createdResourceURI returns the URI of the resource created by a POST
+// request. It is built from the request path and the node from request
+// payload; list key values are picked from the payload data and the key
+// order is resolved from the route tree. Returns empty string if the
+// payload does not contain exactly one node or the keys are not known.
+//
+// Request path = /restconf/data/openconfig-acl:acl/acl-sets
+// Payload      = {"openconfig-acl:acl-set":[{"name":"X","type":"Y",...}]}
+// Resource URI = /restconf/data/openconfig-acl:acl/acl-sets/acl-set=X,Y
+func createdResourceURI(r *http.Request, payload []byte, rc *RequestContext) string {
+	elems := getRouteMatchInfo(r).elems
+	var body map[string]interface{}
+	if len(elems) == 0 || json.Unmarshal(payload, &body) != nil || len(body) != 1 {
+		return ""
+	}
+
+	uri := strings.TrimSuffix(requestPath(r), "/")
+	parent := elems[len(elems)-1].node
+
+	for name, v := range body {
+		entries, isList := v.([]interface{})
+		if !isList {
+			if node := findChildNode(parent.subpaths, name, false); node != nil {
+				return uri + node.name
+			}
+			return uri + "/" + localName(name)
+		}
+
+		// POST on the list path itself; list instance node is
+		// a sibling of the target node.
+		if len(elems) > 1 && localName(parent.name[1:]) == localName(name) {
+			parent = elems[len(elems)-2].node
+			uri = uri[:strings.LastIndexByte(uri, '/')]
+		}
+
+		node := findChildNode(parent.subpaths, name, true)
+		if node == nil || len(entries) != 1 {
+			return ""
+		}
+
+		entry, _ := entries[0].(map[string]interface{})
+		values := make([]string, len(node.params))
+		for i, p := range node.params {
+			v, ok := entry[rc.PMap.Get(p)]
+			if !ok {
+				return ""
+			}
+			values[i] = strings.Replace(url.PathEscape(fmt.Sprint(v)), ",", "%2C", -1)
+		}
+
+		uri += node.name + "=" + strings.Join(values, ",")
+	}
+
+	return uri
+}
+
+// checkPayloadKeys verifies that the list key values in the payload of
+// a PUT or PATCH request on a list instance match the key values in the
+// URI, as required by RFC8040, section 4.5. Key names are resolved from
+// the translib path, which maps the URI params to the key leaf names.
+// Payload can contain the list node, with one entry, or the list entry
+// object itself. Keys not present in the payload are not checked.
+//
+// Request path = /restconf/data/openconfig-acl:acl/acl-sets/acl-set=X,ACL_IPV4
+// Payload      = {"openconfig-acl:acl-set":[{"name":"X","type":"ACL_IPV4",...}]}
+func checkPayloadKeys(method, path string, body []byte) error {
+	if method != "PUT" && method != "PATCH" {
+		return nil
+	}
+
+	elems := splitTranslibPath(path)
+	if len(elems) == 0 {
+		return nil
+	}
+	name, keys := splitElemKeys(elems[len(elems)-1])
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var data map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if d.Decode(&data) != nil {
+		return nil // not an object; left to the backend
+	}
+
+	entries := []interface{}{data}
+	for k, v := range data {
+		if localName(k) != localName(name) {
+			continue
+		}
+		if list, ok := v.([]interface{}); ok {
+			entries = list
+		} else {
+			entries = []interface{}{v}
+		}
+	}
+
+	for _, entry := range entries {
+		if err := checkURIKeys(path, keys, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkURIKeys verifies that the key values in a list entry json match
+// the "key=value" predicates of its URI. Keys not present in the entry
+// are ignored. Key members can be module qualified; see keyValueEquals
+// for the value comparison.
+func checkURIKeys(path string, keys []string, entry interface{}) error {
+	m, _ := entry.(map[string]interface{})
+	for _, kv := range keys {
+		k := strings.IndexByte(kv, '=')
+		name, value := kv[:k], unescapeKeyValue(kv[k+1:])
+		for member, v := range m {
+			if localName(member) == name && !keyValueEquals(v, value) {
+				return invalidNodeError(path,
+					"Key '%s' value '%v' in payload does not match the value '%s' in URI", name, v, value)
+			}
+		}
+	}
+	return nil
+}
+
+// keyValueEquals checks if a key value v from the payload matches the
+// key value from the URI. RFC7951 json encodes identityref values with
+// module prefix, which is optional in the URI; hence the module prefixes
+// are ignored. Eg, payload value "openconfig-acl:ACL_IPV4" matches the
+// URI value "ACL_IPV4".
+func keyValueEquals(v interface{}, value string) bool {
+	s := fmt.Sprint(v)
+	return s == value || trimIdentityPrefix(s) == trimIdentityPrefix(value)
+}
+
+// identityValueExpr matches an identityref value with module prefix.
+var identityValueExpr = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.-]*:([a-zA-Z_][a-zA-Z0-9_.-]*)$`)
+
+// trimIdentityPrefix removes the module prefix from an identityref value.
+// Returns other values, like IPv6 addresses, as is.
+func trimIdentityPrefix(value string) string {
+	if m := identityValueExpr.FindStringSubmatch(value); m != nil {
+		return m[1]
+	}
+	return value
+}
+
+// findChildNode returns the child node for a yang node name, with or
+// without module prefix, from the routeTree t. Returns list instance
+// node (with key params) if isList is true.
+func findChildNode(t routeTree, name string, isList bool) *routeNode {
+	for _, n := range []string{"/" + name, "/" + localName(name)} {
+		for _, node := range t[n] {
+			if (len(node.params) != 0) == isList {
+				return node
+			}
+		}
+	}
+	return nil
+}
+
+// unescapePathValue decodes a percent-encoded path key value. Returns
+// the value as is if it cannot be decoded.
+func unescapePathValue(v string) string {
+	u, err := url.PathUnescape(v)
+	if err != nil {
+		glog.Warningf("Failed to unescape path var \"%s\". err=%v", v, err)
+		return v
+	}
+	return u
 }
 
 // escapeKeyValue function escapes a path key's value as per gNMI path
@@ -245,12 +557,11 @@ func trimRestconfPrefix(path string) string {
 }
 
 // isOperationsRequest checks if a request is a RESTCONF operations
-// request (rpc or action)
+// request (rpc). YANG actions on data resources cannot be identified by
+// the URL pattern; see isActionRequest.
 func isOperationsRequest(r *http.Request) bool {
 	k := strings.Index(r.URL.Path, restconfOperPathPrefix)
 	return k >= 0
-	//TODO handle yang actions.. URL pattern cannot identify action requests.
-	// Works for now as current yang-to-openapi generator does not support them.
 }
 
 // translibArgs holds arguments for invoking translib APIs.
@@ -295,90 +606,76 @@ func (args *translibArgs) parseClientVersion(r *http.Request, rc *RequestContext
 	return nil
 }
 
-// invokeTranslib calls appropriate TransLib API for the given HTTP
-// method. Returns response status code and content.
-func invokeTranslib(args *translibArgs, rc *RequestContext) (int, []byte, error) {
+// invokeBackend calls appropriate Backend function for the given HTTP
+// method. Returns response status code and content. Backend is invoked
+// through callBackend; hence bound by the deadline and cancellation of
+// the context ctx.
+func invokeBackend(ctx context.Context, b Backend, args *translibArgs, rc *RequestContext) (int, []byte, error) {
 	var status = 400
-	var content []byte
+	var resp BackendResponse
 	var err error
+	var call func(BackendRequest) (BackendResponse, error)
+
+	req := BackendRequest{
+		Context:       ctx,
+		RequestID:     rc.ID,
+		Path:          args.path,
+		Payload:       args.data,
+		User:          rc.Username,
+		ClientVersion: args.version,
+	}
 
 	switch args.method {
 	case "GET", "HEAD":
-		req := translib.GetRequest{
-			Path:          args.path,
-			ClientVersion: args.version,
-			QueryParams: translib.QueryParameters{
-				Depth:   args.depth,
-				Content: args.content,
-				Fields:  args.fields,
-			},
-		}
-		resp, err1 := translib.Get(req)
-		if err1 == nil {
-			status = 200
-			content = []byte(resp.Payload)
-		} else {
-			err = err1
-		}
+		status = 200
+		req.Depth = args.depth
+		req.Content = args.content
+		req.Fields = args.fields
+		call = b.Get
 
 	case "POST":
-		//TODO return 200 for operations request
 		status = 201
-		req := translib.SetRequest{
-			Path:          args.path,
-			Payload:       args.data,
-			ClientVersion: args.version,
-		}
-		_, err = translib.Create(req)
+		call = b.Create
 
 	case "PUT":
-		//TODO send 201 if PUT resulted in creation
 		status = 204
-		req := translib.SetRequest{
-			Path:          args.path,
-			Payload:       args.data,
-			ClientVersion: args.version,
-		}
-		_, err = translib.Replace(req)
+		call = b.Replace
 
 	case "PATCH":
 		status = 204
-		req := translib.SetRequest{
-			Path:          args.path,
-			Payload:       args.data,
-			ClientVersion: args.version,
-		}
-		_, err = translib.Update(req)
+		call = b.Update
 
 	case "DELETE":
 		status = 204
-		req := translib.SetRequest{
-			Path:             args.path,
-			ClientVersion:    args.version,
-			DeleteEmptyEntry: args.deleteEmpty,
-		}
-		_, err = translib.Delete(req)
+		req.DeleteEmptyEntry = args.deleteEmpty
+		call = b.Delete
 
 	case "ACTION":
-		req := translib.ActionRequest{
-			Path:          args.path,
-			Payload:       args.data,
-			ClientVersion: args.version,
-		}
-		res, err1 := translib.Action(req)
-		if err1 == nil {
-			status = 200
-			content = res.Payload
-		} else {
-			err = err1
-		}
+		call = b.Action
 
 	default:
 		glog.Errorf("[%s] Unknown method '%v'", rc.ID, args.method)
-		err = httpError(http.StatusNotImplemented, "Internal error")
+		return 400, nil, httpError(http.StatusNotImplemented, "Internal error")
+	}
+
+	resp, err = callBackend(ctx, b, args.method, func() (BackendResponse, error) { return call(req) })
+	if err != nil {
+		return 400, nil, err
+	}
+
+	if args.method == "PUT" && resp.Created {
+		status = 201
+	}
+	if args.method == "ACTION" {
+		status, resp.Payload = formatRPCOutput(args.path, resp.Payload)
+	}
+
+	// Response data is expected only for GET and ACTION
+	if status != 200 {
+		resp.Payload = nil
 	}
 
-	return status, content, err
+	return status, resp.Payload, nil
 }
 
 // writeOptionsResponse writes response for OPTIONS request. Caller
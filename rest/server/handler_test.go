@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"sort"
 	"strings"
 	"testing"
@@ -297,6 +298,27 @@ func TestPathConv(t *testing.T) {
 		"/restconf/data/interface=Eth0%2f1%5b2%5c%5d,1::1",
 		"/interface[name=Eth0/1[2\\\\\\]][ip=1::1]"))
 
+	t.Run("rcdata_dup_names", testPathConv(
+		"/restconf/data/interfaces/interface={name}/subinterfaces/subinterface={index}/neighbors/neighbor={name}",
+		"/restconf/data/interfaces/interface=Eth0/subinterfaces/subinterface=1/neighbors/neighbor=10.1.1.1",
+		"/interfaces/interface[name=Eth0]/subinterfaces/subinterface[index=1]/neighbors/neighbor[name=10.1.1.1]"))
+
+	t.Run("rcdata_dup_names_same_node", testPathConv(
+		"/restconf/data/test/pair={id},{id}/peer={id}",
+		"/restconf/data/test/pair=A,B/peer=C",
+		"/test/pair[id=A][id=B]/peer[id=C]"))
+
+	t.Run("rcdata_dup_params", testPathConv2(
+		map[string]string{"name": "name", "name2": "ip", "index": "index"},
+		"/restconf/data/interfaces/interface={name}/subinterfaces/subinterface={index}/ipv4/addresses/address={name2}",
+		"/restconf/data/interfaces/interface=Eth0/subinterfaces/subinterface=0/ipv4/addresses/address=1.1.1.1",
+		"/interfaces/interface[name=Eth0]/subinterfaces/subinterface[index=0]/ipv4/addresses/address[ip=1.1.1.1]"))
+
+	t.Run("rcdata_partial_keys", testPathConv(
+		"/restconf/data/id={name},{type},{subtype}/data",
+		"/restconf/data/id=X,Y/data",
+		"/id[name=X][type=Y]/data"))
+
 	t.Run("rcdata_escaped+param", testPathConv2(
 		map[string]string{"name1": "name"},
 		"/restconf/data/interface={name1},{type}",
@@ -305,6 +327,69 @@ func TestPathConv(t *testing.T) {
 
 }
 
+// Test percent-encoded key values, as per RFC8040 section 3.5.3.
+// Each value should round-trip into the translib path, both as the
+// first and last key of a list.
+func TestPathConv_keyEncoding(t *testing.T) {
+	values := []struct {
+		name  string
+		value string
+	}{
+		{"simple", "Ethernet0"},
+		{"empty", ""},
+		{"comma", "a,b"},
+		{"commas", "ACL_1,2,3"},
+		{"slash", "Ethernet1/1"},
+		{"ipv4_prefix", "10.0.0.0/24"},
+		{"ipv6_addr", "2001:db8::1"},
+		{"ipv6_prefix", "2001:db8::/64"},
+		{"ipv6_zone", "fe80::1%eth0"},
+		{"space", "with space"},
+		{"leading_space", " lead"},
+		{"equals", "a=b"},
+		{"percent", "100%"},
+		{"percent_seq", "x%2Cy"},
+		{"brackets", "[x]"},
+		{"backslash", `back\slash`},
+		{"pipe", "PortChannel|1"},
+		{"semicolon", "a;b"},
+		{"question", "what?"},
+		{"hash", "tag#1"},
+		{"plus", "a+b"},
+		{"ampersand", "a&b"},
+		{"quotes", `"q" 'q'`},
+		{"colon", "mod:identity"},
+		{"at", "user@host"},
+		{"dollar", "$var"},
+		{"star", "*"},
+		{"unicode", "ünïcödé-日本"},
+		{"newline", "a\nb"},
+	}
+
+	template := "/restconf/data/test/list={first},{last}"
+	for _, v := range values {
+		enc := url.PathEscape(v.value)
+		esc := escapeKeyValue(v.value)
+		t.Run(v.name+"_first", testPathConv(template,
+			"/restconf/data/test/list="+enc+",x",
+			"/test/list[first="+esc+"][last=x]"))
+		t.Run(v.name+"_last", testPathConv(template,
+			"/restconf/data/test/list=x,"+enc,
+			"/test/list[first=x][last="+esc+"]"))
+	}
+
+	// Literal characters which are not encoded by the client
+	t.Run("raw_pipe_comma", testPathConv(template,
+		"/restconf/data/test/list=PortChannel|1%2C2,x",
+		"/test/list[first=PortChannel|1,2][last=x]"))
+	t.Run("lowercase_hex", testPathConv(template,
+		"/restconf/data/test/list=a%2cb%2fc,x",
+		"/test/list[first=a,b/c][last=x]"))
+	t.Run("encoded_name", testPathConv(template,
+		"/restconf/data/test/l%69st=a,b",
+		"/test/list[first=a][last=b]"))
+}
+
 // test handler to invoke getPathForTranslib and write the conveted
 // path into response. Conversion logic depends on context values
 // managed by mux router. Hence should be called from a handler.
@@ -435,6 +520,105 @@ func TestReqData_BadJsonWithValidation(t *testing.T) {
 	testReqError(t, r, rc, 400)
 }
 
+// Models for payload validation tests
+type vtServer struct {
+	Name string `json:"name"`
+	Port int    `json:"port" validate:"max=100"`
+}
+
+type vtTop struct {
+	Server []vtServer `json:"server" validate:"dive"`
+	Descr  string     `json:"descr" validate:"max=5"`
+	Mode   string     `json:"mode,omitempty" validate:"omitempty,oneof=warm cold"`
+}
+
+type vtBody struct {
+	Top *vtTop `json:"rpc-test:top" validate:"required"`
+}
+
+func TestReqData_ValidationErrors(t *testing.T) {
+	input := `{"rpc-test:top": {"descr": "too long", "server": [{"name": "a", "port": 1}, {"name": "b", "port": 500}]}}`
+	var errPaths []string
+
+	s := newEmptyRouter()
+	s.addRoute("top", "PUT", "/restconf/data/rpc-test:top", func(w http.ResponseWriter, r *http.Request) {
+		rc, r := GetContext(r)
+		rc.Model = &vtBody{}
+		rc.Consumes.Add("application/json")
+
+		_, _, err := getRequestBody(r, rc)
+		_, entries := toErrorEntries(err, r)
+		errPaths = nil
+		for _, e := range entries {
+			errPaths = append(errPaths, e.Path)
+		}
+		sort.Strings(errPaths)
+	})
+
+	verifyErrPaths := func(t *testing.T, exp ...string) {
+		r := httptest.NewRequest("PUT", "/restconf/data/rpc-test:top", strings.NewReader(input))
+		r.Header.Set("content-type", "application/json")
+		s.ServeHTTP(httptest.NewRecorder(), r)
+		if strings.Join(errPaths, ",") != strings.Join(exp, ",") {
+			t.Fatalf("Expected error paths %v; found %v", exp, errPaths)
+		}
+	}
+
+	t.Run("no_schema", func(t *testing.T) {
+		defer useYangSchema(nil)()
+		verifyErrPaths(t, "/rpc-test:top/descr", "/rpc-test:top/server[2]/port")
+	})
+
+	t.Run("schema", func(t *testing.T) {
+		defer useYangSchema(loadTestYangSchema(t, rpcTestYang))()
+		verifyErrPaths(t, "/rpc-test:top/descr", "/rpc-test:top/server[name='b']/port")
+	})
+}
+
+func TestReqData_ValidationMessages(t *testing.T) {
+	t.Run("range", testValidationMessage(`{"rpc-test:top": {"server": [{"name": "a", "port": 500}]}}`,
+		"/rpc-test:top/server[1]/port", "Invalid value '500' for 'port'; should be at most 100",
+		payloadErrorInfo{"/rpc-test:top/server[1]/port", "range", "100", 500}))
+	t.Run("length", testValidationMessage(`{"rpc-test:top": {"descr": "too long"}}`,
+		"/rpc-test:top/descr", "Invalid length for 'descr'; should be at most 5",
+		payloadErrorInfo{"/rpc-test:top/descr", "length", "5", "too long"}))
+	t.Run("enum", testValidationMessage(`{"rpc-test:top": {"mode": "hot"}}`,
+		"/rpc-test:top/mode", "Invalid value 'hot' for 'mode'; should be one of [warm cold]",
+		payloadErrorInfo{"/rpc-test:top/mode", "enum", "warm cold", "hot"}))
+	t.Run("mandatory", testValidationMessage(`{}`,
+		"/rpc-test:top", "Mandatory field 'top' is missing",
+		payloadErrorInfo{"/rpc-test:top", "mandatory", "", nil}))
+	t.Run("type", testValidationMessage(`{"rpc-test:top": {"server": [{"name": "a", "port": "x"}]}}`,
+		"/rpc-test:top/server[1]/port", "Invalid value for 'port'; expected number, found string",
+		payloadErrorInfo{"/rpc-test:top/server[1]/port", "type", "number", "string"}))
+
+	t.Run("syntax", func(t *testing.T) {
+		_, err := validateRequestJSON([]byte(`{"rpc-test:top": {]}`), &RequestContext{Model: &vtBody{}})
+		if e, ok := err.(httpErrorType); !ok || !strings.Contains(e.message, "offset 19") {
+			t.Fatalf("Expected httpError with offset; found %v", err)
+		}
+	})
+}
+
+func testValidationMessage(input, expPath, expMsg string, expInfo payloadErrorInfo) func(*testing.T) {
+	return func(t *testing.T) {
+		_, err := validateRequestJSON([]byte(input), &RequestContext{Model: &vtBody{}})
+		if m, ok := err.(MultiError); ok && len(m) == 1 {
+			err = m[0]
+		}
+		pe, ok := err.(payloadError)
+		if !ok {
+			t.Fatalf("Expected a payloadError; found %#v", err)
+		}
+		if pe.Path != expPath || pe.Message != expMsg {
+			t.Fatalf("Expected path '%s' and message \"%s\"; found '%s' and \"%s\"", expPath, expMsg, pe.Path, pe.Message)
+		}
+		if fmt.Sprintf("%#v", pe.Info) != fmt.Sprintf("%#v", expInfo) {
+			t.Fatalf("Expected error-info %#v; found %#v", expInfo, pe.Info)
+		}
+	}
+}
+
 func testReqSuccess(t *testing.T, r *http.Request, rc *RequestContext, expType, expData string) {
 	ct, data, err := getRequestBody(r, rc)
 
@@ -680,6 +864,103 @@ func TestProcessPOST_error(t *testing.T) {
 	verifyResponse(t, w, 400)
 }
 
+func TestProcessPOST_location(t *testing.T) {
+	b := NewMemoryBackend()
+	b.SetListKeys("/top/acl", "name", "type")
+	defer useBackend("/restconf/data/loc-test:", b)()
+
+	s := newEmptyRouter()
+	for _, p := range []string{
+		"/restconf/data/loc-test:top",
+		"/restconf/data/loc-test:top/acl",
+		"/restconf/data/loc-test:top/acl={aclname},{type}",
+		"/restconf/data/loc-test:top/acl={aclname},{type}/rule={seq}",
+	} {
+		s.addRoute("loc", "POST", p, Process)
+	}
+
+	t.Run("container", testLocation(s, "/restconf/data/loc-test:top",
+		`{"loc-test:settings":{"mtu":9100}}`,
+		"/restconf/data/loc-test:top/settings"))
+	t.Run("list", testLocation(s, "/restconf/data/loc-test:top",
+		`{"loc-test:acl":[{"name":"a,b/c","type":"IPV4"}]}`,
+		"/restconf/data/loc-test:top/acl=a%2Cb%2Fc,IPV4"))
+	t.Run("list_path", testLocation(s, "/restconf/data/loc-test:top/acl",
+		`{"loc-test:acl":[{"name":"X","type":"IPV6"}]}`,
+		"/restconf/data/loc-test:top/acl=X,IPV6"))
+	t.Run("nested", testLocation(s, "/restconf/data/loc-test:top/acl=X,IPV6",
+		`{"loc-test:rule":[{"seq":10}]}`,
+		"/restconf/data/loc-test:top/acl=X,IPV6/rule=10"))
+	t.Run("key_missing", testLocation(s, "/restconf/data/loc-test:top/acl=X,IPV6",
+		`{"loc-test:rule":[{"action":"drop"}]}`, ""))
+}
+
+func testLocation(s *Router, path, data, expLocation string) func(*testing.T) {
+	return func(t *testing.T) {
+		r := prepareRequest(t, "POST", path, data)
+		rc, r := GetContext(r)
+		rc.PMap = NameMap{"aclname": "name"}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		verifyResponse(t, w, 201)
+		if loc := w.Header().Get("Location"); loc != expLocation {
+			t.Fatalf("Expected Location '%s'; found '%s'", expLocation, loc)
+		}
+	}
+}
+
+func TestProcessPUT_keyMismatch(t *testing.T) {
+	defer useBackend("/restconf/data/key-test:", &recordingBackend{})()
+
+	s := newEmptyRouter()
+	for _, m := range []string{"PUT", "PATCH", "POST"} {
+		s.addRoute("acl", m, "/restconf/data/key-test:acls/acl={aclname},{type}", Process)
+	}
+
+	t.Run("match", testKeyCheck(s, "PUT", "/key-test:acls/acl=X,IPV4",
+		`{"key-test:acl":[{"name":"X","type":"IPV4","mtu":1}]}`, 204))
+	t.Run("name", testKeyCheck(s, "PUT", "/key-test:acls/acl=FOO,IPV4",
+		`{"key-test:acl":[{"name":"BAR","type":"IPV4"}]}`, 400))
+	t.Run("second_key", testKeyCheck(s, "PATCH", "/key-test:acls/acl=X,IPV4",
+		`{"key-test:acl":[{"name":"X","type":"IPV6"}]}`, 400))
+	t.Run("prefixed", testKeyCheck(s, "PATCH", "/key-test:acls/acl=X,IPV4",
+		`{"key-test:acl":[{"key-test:name":"Y"}]}`, 400))
+	t.Run("escaped", testKeyCheck(s, "PUT", "/key-test:acls/acl=a%2Cb%2Fc,IPV4",
+		`{"key-test:acl":[{"name":"a,b/c"}]}`, 204))
+	t.Run("number", testKeyCheck(s, "PUT", "/key-test:acls/acl=X,10",
+		`{"key-test:acl":[{"type":10}]}`, 204))
+	t.Run("identityref", testKeyCheck(s, "PUT", "/key-test:acls/acl=FOO,ACL_IPV4",
+		`{"key-test:acl":[{"name":"FOO","type":"openconfig-acl:ACL_IPV4"}]}`, 204))
+	t.Run("identityref_uri", testKeyCheck(s, "PUT", "/key-test:acls/acl=FOO,openconfig-acl:ACL_IPV4",
+		`{"key-test:acl":[{"name":"FOO","type":"ACL_IPV4"}]}`, 204))
+	t.Run("identityref_mismatch", testKeyCheck(s, "PUT", "/key-test:acls/acl=FOO,ACL_IPV4",
+		`{"key-test:acl":[{"name":"FOO","type":"openconfig-acl:ACL_IPV6"}]}`, 400))
+	t.Run("ipv6", testKeyCheck(s, "PUT", "/key-test:acls/acl=FOO,fe80::1",
+		`{"key-test:acl":[{"name":"FOO","type":"fe80::2"}]}`, 400))
+	t.Run("entry", testKeyCheck(s, "PUT", "/key-test:acls/acl=FOO,IPV4",
+		`{"name":"BAR"}`, 400))
+	t.Run("no_keys", testKeyCheck(s, "PATCH", "/key-test:acls/acl=X,IPV4",
+		`{"key-test:acl":[{"mtu":1}]}`, 204))
+	t.Run("post", testKeyCheck(s, "POST", "/key-test:acls/acl=X,IPV4",
+		`{"key-test:name":"Y"}`, 201))
+}
+
+func testKeyCheck(s *Router, method, path, data string, expStatus int) func(*testing.T) {
+	return func(t *testing.T) {
+		r := prepareRequest(t, method, path, data)
+		rc, r := GetContext(r)
+		rc.PMap = NameMap{"aclname": "name"}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		verifyResponse(t, w, expStatus)
+		if expStatus == 400 && !strings.Contains(w.Body.String(), "does not match") {
+			t.Fatalf("Unexpected error response; %s", w.Body.String())
+		}
+	}
+}
+
 func TestProcessRPC(t *testing.T) {
 	w := httptest.NewRecorder()
 	Process(w, prepareRequest(t, "POST", "/restconf/operations/api-tests:my-echo",
@@ -802,11 +1083,14 @@ func newEmptyRouter() *Router {
 	return &Router{routes: newRouteStore()}
 }
 
-func (r *Router) addRoute(name, method, path string, h http.HandlerFunc) {
+func (r *Router) addRoute(name, method, path string, h http.HandlerFunc, opts ...RouteOption) {
+	rr := routeRegInfo{name: name, method: method, path: path, handler: h}
+	for _, opt := range opts {
+		opt(&rr)
+	}
 	if path == "*" {
-		r.routes.muxRoutes.Methods(method).Handler(withMiddleware(h, name))
+		r.routes.muxRoutes.Methods(method).Handler(withMiddleware(h, &rr))
 	} else {
-		rr := routeRegInfo{name: name, method: method, path: path, handler: h}
 		r.routes.addRoute(&rr)
 	}
 }
@@ -0,0 +1,246 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/sonic-mgmt-common/translib"
+	"github.com/Azure/sonic-mgmt-common/translib/db"
+	"github.com/golang/glog"
+)
+
+// certExpiryMargin is the minimum remaining validity period of server
+// certificates for the readiness check to pass.
+var certExpiryMargin = 7 * 24 * time.Hour
+
+// serverStartTime is the time at which the server process started.
+var serverStartTime = time.Now()
+
+func init() {
+	flag.DurationVar(&certExpiryMargin, "cert_expiry_margin", certExpiryMargin,
+		"Report not-ready if server certificate expires within this duration")
+}
+
+// healthCheckResult holds the outcome of one readiness check.
+type healthCheckResult struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"` // "pass" or "fail"
+	Message string `json:"message,omitempty"`
+}
+
+// healthResponse is the JSON body of /healthz and /readyz responses.
+type healthResponse struct {
+	Status string              `json:"status"`
+	Uptime string              `json:"uptime,omitempty"`
+	Checks []healthCheckResult `json:"checks,omitempty"`
+}
+
+// readinessCheck is a function that verifies readiness of one server
+// component. Returns a status message on success and an error if the
+// component is not ready.
+type readinessCheck func(router *Router) (string, error)
+
+// readinessChecks lists all readiness checks performed by /readyz,
+// in the order they are reported.
+var readinessChecks = []struct {
+	name  string
+	check readinessCheck
+}{
+	{"routes", checkRoutesLoaded},
+	{"translib", checkTranslibReady},
+	{"certificates", checkServerCertificates},
+}
+
+// healthzHandler serves "GET /healthz" liveness probe requests.
+// Always reports success as long as the process is serving requests.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	resp := healthResponse{
+		Status: "ok",
+		Uptime: time.Since(serverStartTime).Truncate(time.Second).String(),
+	}
+
+	writeHealthResponse(w, r, http.StatusOK, &resp)
+}
+
+// readyzHandler serves "GET /readyz" readiness probe requests.
+// Runs all readiness checks and returns 200 status if all of them
+// passed; 503 otherwise. Response body contains result of each check.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	router, _ := getContextValue(r, routerObjContextKey).(*Router)
+	resp := healthResponse{Status: "ready"}
+	status := http.StatusOK
+
+	for _, rc := range readinessChecks {
+		res := healthCheckResult{Name: rc.name, Status: "pass"}
+		msg, err := rc.check(router)
+		if err != nil {
+			glog.Warningf("Readiness check '%s' failed; %v", rc.name, err)
+			res.Status = "fail"
+			res.Message = err.Error()
+			resp.Status = "not-ready"
+			status = http.StatusServiceUnavailable
+		} else {
+			res.Message = msg
+		}
+
+		resp.Checks = append(resp.Checks, res)
+	}
+
+	writeHealthResponse(w, r, status, &resp)
+}
+
+// writeHealthResponse writes a healthResponse as JSON. Response body
+// is omitted for HEAD requests.
+func writeHealthResponse(w http.ResponseWriter, r *http.Request, status int, resp *healthResponse) {
+	data, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if r.Method != "HEAD" {
+		w.Write(data)
+	}
+}
+
+// checkRoutesLoaded verifies that YANG data routes have been loaded
+// into the router (by openapi.Load). RESTCONF routes registered by the
+// server itself (ietf-restconf-monitoring and sonic-rest-server modules)
+// are not counted.
+func checkRoutesLoaded(router *Router) (string, error) {
+	if router == nil {
+		return "", fmt.Errorf("router not initialized")
+	}
+
+	routes := router.getRoutes()
+	modules := make(map[string]bool)
+	if data := routes.rcRoutes.find("/restconf/data"); data != nil {
+		for name := range data.subpaths {
+			if k := strings.Index(name, ":"); k > 0 {
+				modules[name[1:k]] = true
+			}
+		}
+	}
+
+	delete(modules, "ietf-restconf-monitoring")
+	delete(modules, "sonic-rest-server")
+	if len(modules) == 0 {
+		return "", fmt.Errorf("no YANG data routes loaded")
+	}
+
+	return fmt.Sprintf("%d routes, %d modules", routes.rcRouteCount, len(modules)), nil
+}
+
+// checkTranslibReady verifies that translib has loaded its YANG
+// models and the config DB is reachable.
+func checkTranslibReady(router *Router) (string, error) {
+	models, err := translib.GetModels()
+	if err != nil {
+		return "", fmt.Errorf("translib models not available; %v", err)
+	}
+	if len(models) == 0 {
+		return "", fmt.Errorf("translib has no models")
+	}
+
+	if err = checkDBConnection(); err != nil {
+		return "", fmt.Errorf("config DB not reachable; %v", err)
+	}
+
+	return fmt.Sprintf("%d models", len(models)), nil
+}
+
+// checkDBConnection opens a config DB connection and closes it.
+// It is a variable to allow tests to simulate DB failures.
+var checkDBConnection = func() error {
+	d, err := db.NewDB(db.Options{
+		DBNo:               db.ConfigDB,
+		InitIndicator:      "CONFIG_DB_INITIALIZED",
+		TableNameSeparator: "|",
+		KeySeparator:       "|",
+		IsWriteDisabled:    true,
+	})
+	if err != nil {
+		return err
+	}
+
+	return d.DeleteDB()
+}
+
+// checkServerCertificates verifies that all server certificates in
+// the RouterConfig are currently valid and do not expire within
+// certExpiryMargin duration. Passes if no certificates are configured.
+func checkServerCertificates(router *Router) (string, error) {
+	if router == nil || len(router.config.Certificates) == 0 {
+		return "no certificates configured", nil
+	}
+
+	now := time.Now()
+	var minExpiry time.Time
+
+	for _, c := range router.config.Certificates {
+		cert, err := leafCertificate(c)
+		if err != nil {
+			return "", err
+		}
+
+		subject := cert.Subject.CommonName
+		if now.Before(cert.NotBefore) {
+			return "", fmt.Errorf("certificate '%s' not valid before %s",
+				subject, cert.NotBefore.Format(time.RFC3339))
+		}
+		if now.After(cert.NotAfter) {
+			return "", fmt.Errorf("certificate '%s' expired at %s",
+				subject, cert.NotAfter.Format(time.RFC3339))
+		}
+		if now.Add(certExpiryMargin).After(cert.NotAfter) {
+			return "", fmt.Errorf("certificate '%s' expires soon, at %s",
+				subject, cert.NotAfter.Format(time.RFC3339))
+		}
+		if minExpiry.IsZero() || cert.NotAfter.Before(minExpiry) {
+			minExpiry = cert.NotAfter
+		}
+	}
+
+	return fmt.Sprintf("valid until %s", minExpiry.Format(time.RFC3339)), nil
+}
+
+// leafCertificate returns the parsed leaf x509 certificate from a
+// tls.Certificate object.
+func leafCertificate(c tls.Certificate) (*x509.Certificate, error) {
+	if c.Leaf != nil {
+		return c.Leaf, nil
+	}
+	if len(c.Certificate) == 0 {
+		return nil, fmt.Errorf("empty certificate chain")
+	}
+
+	cert, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate; %v", err)
+	}
+
+	return cert, nil
+}
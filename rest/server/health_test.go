@@ -0,0 +1,207 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthz(t *testing.T) {
+	s := newHealthTestRouter(true)
+	s.config.AuthEnable = true
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+
+	resp := verifyHealthResponse(t, w, 200)
+	if resp.Status != "ok" || resp.Uptime == "" {
+		t.Fatalf("Unexpected healthz response: %s", w.Body.String())
+	}
+}
+
+func TestHealthz_HEAD(t *testing.T) {
+	s := newHealthTestRouter(true)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("HEAD", "/healthz", nil))
+
+	verifyResponse(t, w, 200)
+	if w.Body.Len() != 0 {
+		t.Fatalf("Expecting empty body; found %s", w.Body.String())
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	defer stubDBConnectionCheck(nil)()
+	s := newHealthTestRouter(true)
+	s.config.AuthEnable = true // readyz should not need authentication
+	s.config.Certificates = []tls.Certificate{newTestCertificate(t, 30*24*time.Hour)}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	resp := verifyHealthResponse(t, w, 200)
+	verifyHealthCheck(t, resp, "routes", "pass")
+	verifyHealthCheck(t, resp, "translib", "pass")
+	verifyHealthCheck(t, resp, "certificates", "pass")
+}
+
+func TestReadyz_noRoutes(t *testing.T) {
+	defer stubDBConnectionCheck(nil)()
+	s := newHealthTestRouter(false)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	resp := verifyHealthResponse(t, w, 503)
+	verifyHealthCheck(t, resp, "routes", "fail")
+}
+
+func TestReadyz_serverRoutesOnly(t *testing.T) {
+	defer stubDBConnectionCheck(nil)()
+	s := newHealthTestRouter(false)
+	s.addRoute("listJobs", "GET", jobsPathPrefix, jobListHandler)
+	s.addRoute("getReadOnly", "GET", readOnlyPath, readOnlyStatusHandler)
+	s.addRoute("listLocks", "GET", locksPath, lockListHandler)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	resp := verifyHealthResponse(t, w, 503)
+	verifyHealthCheck(t, resp, "routes", "fail")
+}
+
+func TestReadyz_dbDown(t *testing.T) {
+	defer stubDBConnectionCheck(errors.New("connection refused"))()
+	s := newHealthTestRouter(true)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	resp := verifyHealthResponse(t, w, 503)
+	verifyHealthCheck(t, resp, "routes", "pass")
+	verifyHealthCheck(t, resp, "translib", "fail")
+}
+
+func TestReadyz_certExpiring(t *testing.T) {
+	defer stubDBConnectionCheck(nil)()
+	s := newHealthTestRouter(true)
+	s.config.Certificates = []tls.Certificate{newTestCertificate(t, time.Hour)}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	resp := verifyHealthResponse(t, w, 503)
+	verifyHealthCheck(t, resp, "certificates", "fail")
+}
+
+func TestReadyz_certExpired(t *testing.T) {
+	defer stubDBConnectionCheck(nil)()
+	s := newHealthTestRouter(true)
+	s.config.Certificates = []tls.Certificate{newTestCertificate(t, -time.Hour)}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	resp := verifyHealthResponse(t, w, 503)
+	verifyHealthCheck(t, resp, "certificates", "fail")
+}
+
+// newHealthTestRouter creates a router with only the service routes.
+// Adds a dummy YANG data route if withData is true.
+func newHealthTestRouter(withData bool) *Router {
+	s := newEmptyRouter()
+	if withData {
+		s.addRoute("healthTest", "GET", "/restconf/data/api-tests:sample", newHandler(200))
+	}
+
+	s.routes.addServiceRoutes(&s.config)
+	return s
+}
+
+// stubDBConnectionCheck replaces checkDBConnection with a function
+// that returns err. Returns a function to restore the original.
+func stubDBConnectionCheck(err error) func() {
+	orig := checkDBConnection
+	checkDBConnection = func() error { return err }
+	return func() { checkDBConnection = orig }
+}
+
+func verifyHealthResponse(t *testing.T, w *httptest.ResponseRecorder, expCode int) *healthResponse {
+	verifyResponse(t, w, expCode)
+	if ctype := w.Header().Get("Content-Type"); ctype != "application/json" {
+		t.Fatalf("Expected content-type application/json; found %s", ctype)
+	}
+
+	var resp healthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+
+	return &resp
+}
+
+func verifyHealthCheck(t *testing.T, resp *healthResponse, name, expStatus string) {
+	for _, c := range resp.Checks {
+		if c.Name == name {
+			if c.Status != expStatus {
+				t.Fatalf("Expected '%s' check status '%s'; found '%s' (%s)",
+					name, expStatus, c.Status, c.Message)
+			}
+			return
+		}
+	}
+
+	t.Fatalf("Check '%s' not found in response %v", name, resp.Checks)
+}
+
+// newTestCertificate creates a self signed certificate which expires
+// after given duration. Negative value creates an expired certificate.
+func newTestCertificate(t *testing.T, validity time.Duration) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key; %v", err)
+	}
+
+	notAfter := time.Now().Add(validity)
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate; %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
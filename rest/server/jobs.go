@@ -0,0 +1,336 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Asynchronous job configurations
+var (
+	// asyncJobRetention is the duration for which the finished jobs are
+	// retained, for clients to collect the result.
+	asyncJobRetention = time.Hour
+
+	// asyncJobLimit is the maximum number of jobs, running or finished,
+	// tracked by the server. Oldest finished job is removed to make room
+	// for a new one; new jobs are rejected if all of them are running.
+	asyncJobLimit = 64
+)
+
+// jobsPathPrefix is the URI of the jobs collection.
+const jobsPathPrefix = "/restconf/data/sonic-rest-server:jobs"
+
+func init() {
+	flag.DurationVar(&asyncJobRetention, "async_job_retention", asyncJobRetention,
+		"Duration for which the results of asynchronous RPC jobs are retained")
+	flag.IntVar(&asyncJobLimit, "async_job_limit", asyncJobLimit,
+		"Maximum number of asynchronous RPC jobs tracked by the server")
+
+	AddRoute("listJobs", "GET", jobsPathPrefix, jobListHandler)
+	AddRoute("getJob", "GET", jobsPathPrefix+"/job={id}", jobHandler)
+	AddRoute("deleteJob", "DELETE", jobsPathPrefix+"/job={id}", jobHandler, ReadOnlyAllowed())
+}
+
+// Job status values
+const (
+	jobRunning         = "running"
+	jobCancelRequested = "cancel-requested"
+	jobCompleted       = "completed"
+	jobFailed          = "failed"
+	jobCancelled       = "cancelled"
+)
+
+// asyncJob is an RPC or action request executed in background. Clients
+// request it through "Prefer: respond-async" header (RFC7240) and
+// poll the job resource for the result.
+type asyncJob struct {
+	id        string
+	user      string
+	operation string // request URI path
+	start     time.Time
+	cancel    context.CancelFunc
+
+	// Following fields are protected by asyncJobs.mu
+	status     string
+	end        time.Time
+	httpStatus int
+	result     []byte
+}
+
+// jobInfo is the json representation of an asyncJob.
+type jobInfo struct {
+	ID         string          `json:"id"`
+	Operation  string          `json:"operation"`
+	Status     string          `json:"status"`
+	StartTime  string          `json:"start-time"`
+	EndTime    string          `json:"end-time,omitempty"`
+	HTTPStatus int             `json:"http-status,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+}
+
+// asyncJobs is the registry of all asynchronous jobs.
+var asyncJobs = struct {
+	mu   sync.Mutex
+	jobs map[string]*asyncJob
+}{jobs: make(map[string]*asyncJob)}
+
+// prefersAsync checks if the request has "Prefer: respond-async" header.
+func prefersAsync(r *http.Request) bool {
+	for _, v := range r.Header["Prefer"] {
+		for _, p := range strings.FieldsFunc(v, func(c rune) bool { return c == ',' || c == ';' }) {
+			if strings.EqualFold(strings.TrimSpace(p), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// startAsyncJob starts a background job to invoke the RPC or action
+// through Backend b. Function endWrite, returned by startConfigWrite, is
+// called when the job finishes; or before returning an error. Returns
+// httpError with 503 status if there are too many jobs.
+func startAsyncJob(r *http.Request, rc *RequestContext, b Backend, args translibArgs, endWrite func()) (*asyncJob, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &asyncJob{
+		id:        newTraceID(),
+		user:      rc.Username,
+		operation: requestPath(r),
+		start:     time.Now(),
+		cancel:    cancel,
+		status:    jobRunning,
+	}
+
+	asyncJobs.mu.Lock()
+	purgeAsyncJobs(job.start)
+	if len(asyncJobs.jobs) >= asyncJobLimit && !evictAsyncJob() {
+		asyncJobs.mu.Unlock()
+		cancel()
+		endWrite()
+		return nil, httpError(http.StatusServiceUnavailable, "Too many jobs; try again later")
+	}
+	asyncJobs.jobs[job.id] = job
+	asyncJobs.mu.Unlock()
+
+	// The request and its context should not be used after the handler
+	// returns. Job keeps a copy of the RequestContext, and the headers
+	// for preparing the error response.
+	jrc := *rc
+	jr := &http.Request{Method: r.Method, Header: r.Header.Clone()}
+
+	requestLog(rc).with("job_id", job.id).Infof("Started job")
+	go job.run(ctx, jr, &jrc, b, args, endWrite)
+	return job, nil
+}
+
+// run invokes the Backend and records the result. Request r is only
+// used for preparing the error response.
+func (job *asyncJob) run(ctx context.Context, r *http.Request, rc *RequestContext, b Backend, args translibArgs, endWrite func()) {
+	log := requestLog(rc).with("job_id", job.id)
+	status, data := http.StatusInternalServerError, []byte(nil)
+	defer endWrite()
+	defer func() {
+		if x := recover(); x != nil {
+			log.Errorf("Job panicked; %v", x)
+			status, data, _ = prepareErrorResponse(httpServerError("Internal error"), r)
+		}
+		job.finish(status, data)
+		log.with("status", status).Infof("Job finished")
+	}()
+
+	var err error
+	status, data, err = invokeBackend(ctx, b, &args, rc)
+	if err != nil {
+		log.Warningf("Job failed; %v", redactError(err))
+		status, data, _ = prepareErrorResponse(err, r)
+	}
+}
+
+// finish records the job result. A job whose cancellation was requested
+// is marked as cancelled only if it failed; backends like translib ignore
+// the cancellation and may complete the operation.
+func (job *asyncJob) finish(status int, data []byte) {
+	asyncJobs.mu.Lock()
+	defer asyncJobs.mu.Unlock()
+
+	job.end = time.Now()
+	job.httpStatus = status
+	job.result = data
+	switch {
+	case status < http.StatusBadRequest:
+		job.status = jobCompleted
+	case job.status == jobCancelRequested:
+		job.status = jobCancelled
+	default:
+		job.status = jobFailed
+	}
+	job.cancel()
+}
+
+// running checks if the job has not finished yet. Caller should
+// hold the lock.
+func (job *asyncJob) running() bool {
+	return job.status == jobRunning || job.status == jobCancelRequested
+}
+
+// purgeAsyncJobs removes the finished jobs which have exceeded the
+// retention period. Caller should hold the lock.
+func purgeAsyncJobs(now time.Time) {
+	for id, job := range asyncJobs.jobs {
+		if !job.running() && now.Sub(job.end) >= asyncJobRetention {
+			delete(asyncJobs.jobs, id)
+		}
+	}
+}
+
+// evictAsyncJob removes the oldest finished job to make room for a new
+// one. Returns false if all jobs are running. Caller should hold the lock.
+func evictAsyncJob() bool {
+	var oldest *asyncJob
+	for _, job := range asyncJobs.jobs {
+		if !job.running() && (oldest == nil || job.end.Before(oldest.end)) {
+			oldest = job
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	delete(asyncJobs.jobs, oldest.id)
+	return true
+}
+
+// uri returns the URI of the job resource.
+func (job *asyncJob) uri() string {
+	return jobsPathPrefix + "/job=" + job.id
+}
+
+// info returns the jobInfo for the job. Caller should hold the lock.
+func (job *asyncJob) info() jobInfo {
+	info := jobInfo{
+		ID:        job.id,
+		Operation: job.operation,
+		Status:    job.status,
+		StartTime: job.start.UTC().Format(time.RFC3339),
+	}
+	if !job.running() {
+		info.EndTime = job.end.UTC().Format(time.RFC3339)
+		info.HTTPStatus = job.httpStatus
+		if json.Valid(job.result) {
+			info.Result = job.result
+		}
+	}
+	return info
+}
+
+// marshal returns the json representation of the job, as a
+// "sonic-rest-server:job" list instance.
+func (job *asyncJob) marshal() []byte {
+	asyncJobs.mu.Lock()
+	info := job.info()
+	asyncJobs.mu.Unlock()
+
+	data, _ := json.Marshal(map[string][]jobInfo{"sonic-rest-server:job": {info}})
+	return data
+}
+
+// findAsyncJob returns the job with given id, if it is owned by the user.
+func findAsyncJob(id, user string) *asyncJob {
+	asyncJobs.mu.Lock()
+	defer asyncJobs.mu.Unlock()
+
+	purgeAsyncJobs(time.Now())
+	if job := asyncJobs.jobs[id]; job != nil && job.user == user {
+		return job
+	}
+	return nil
+}
+
+// jobListHandler serves "GET /restconf/data/sonic-rest-server:jobs"
+// requests. Lists the jobs of current user.
+func jobListHandler(w http.ResponseWriter, r *http.Request) {
+	rc, r := GetContext(r)
+	var jobs struct {
+		Job []jobInfo `json:"job"`
+	}
+
+	asyncJobs.mu.Lock()
+	purgeAsyncJobs(time.Now())
+	for _, job := range asyncJobs.jobs {
+		if job.user == rc.Username {
+			jobs.Job = append(jobs.Job, job.info())
+		}
+	}
+	asyncJobs.mu.Unlock()
+
+	sort.Slice(jobs.Job, func(i, j int) bool { return jobs.Job[i].StartTime < jobs.Job[j].StartTime })
+	data, _ := json.Marshal(map[string]interface{}{"sonic-rest-server:jobs": &jobs})
+	w.Header().Set("Content-Type", mimeYangDataJSON)
+	w.Write(data)
+}
+
+// jobHandler serves GET and DELETE requests for a job resource -
+// "/restconf/data/sonic-rest-server:jobs/job={id}". DELETE removes a
+// finished job. For a running job, it only requests the cancellation by
+// cancelling the job context and returns 202 status; the job is retained
+// with "cancel-requested" status till the backend returns. Backends which
+// ignore the context (like translib) run the operation to completion.
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	rc, r := GetContext(r)
+	id := unescapePathValue(getRouteMatchInfo(r).vars["id"])
+	job := findAsyncJob(id, rc.Username)
+	if job == nil {
+		writeErrorResponse(w, r, httpError(http.StatusNotFound, "Job not found"))
+		return
+	}
+
+	if r.Method == "GET" {
+		w.Header().Set("Content-Type", mimeYangDataJSON)
+		w.Write(job.marshal())
+		return
+	}
+
+	asyncJobs.mu.Lock()
+	running := job.running()
+	if running {
+		job.status = jobCancelRequested
+		requestLog(rc).with("job_id", job.id).Infof("Requested cancellation of job")
+	} else {
+		delete(asyncJobs.jobs, job.id)
+	}
+	asyncJobs.mu.Unlock()
+
+	if !running {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	job.cancel()
+	w.Header().Set("Content-Type", mimeYangDataJSON)
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(job.marshal())
+}
@@ -0,0 +1,341 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingBackend is a Backend whose Action blocks till the release
+// channel is closed or the request context is cancelled.
+type blockingBackend struct {
+	recordingBackend
+	release   chan struct{}
+	cancelled chan struct{}
+}
+
+func newBlockingBackend() *blockingBackend {
+	return &blockingBackend{
+		release:   make(chan struct{}),
+		cancelled: make(chan struct{}),
+	}
+}
+
+func (b *blockingBackend) Action(req BackendRequest) (BackendResponse, error) {
+	select {
+	case <-b.release:
+		return BackendResponse{Payload: []byte(`{"status":"done"}`)}, nil
+	case <-req.Context.Done():
+		close(b.cancelled)
+		return BackendResponse{}, req.Context.Err()
+	}
+}
+
+// ignoreCancelBackend is a Backend whose Action blocks till the release
+// channel is closed, ignoring the request context (like translib).
+type ignoreCancelBackend struct {
+	*recordingBackend
+	release chan struct{}
+}
+
+func (b *ignoreCancelBackend) Action(req BackendRequest) (BackendResponse, error) {
+	<-b.release
+	return b.recordingBackend.Action(req)
+}
+
+// useAsyncJobLimits overrides the job limits and clears all jobs.
+// Returns a function to restore them.
+func useAsyncJobLimits(limit int, retention time.Duration) func() {
+	origLimit, origRetention := asyncJobLimit, asyncJobRetention
+	asyncJobLimit, asyncJobRetention = limit, retention
+	clearAsyncJobs()
+	return func() {
+		asyncJobLimit, asyncJobRetention = origLimit, origRetention
+		clearAsyncJobs()
+	}
+}
+
+func clearAsyncJobs() {
+	asyncJobs.mu.Lock()
+	defer asyncJobs.mu.Unlock()
+	for id, job := range asyncJobs.jobs {
+		job.cancel()
+		delete(asyncJobs.jobs, id)
+	}
+}
+
+func newJobsTestRouter() *Router {
+	s := newEmptyRouter()
+	s.addRoute("ping", "POST", "/restconf/operations/jobs-test:ping", Process)
+	s.addRoute("listJobs", "GET", jobsPathPrefix, jobListHandler)
+	s.addRoute("getJob", "GET", jobsPathPrefix+"/job={id}", jobHandler)
+	s.addRoute("deleteJob", "DELETE", jobsPathPrefix+"/job={id}", jobHandler)
+	return s
+}
+
+// startTestJob invokes the ping RPC with "Prefer: respond-async" header
+// and returns the job URI.
+func startTestJob(t *testing.T, s *Router) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := prepareRequest(t, "POST", "/restconf/operations/jobs-test:ping", "")
+	r.Header.Set("Prefer", "return=minimal, respond-async")
+	s.ServeHTTP(w, r)
+	verifyResponse(t, w, 202)
+
+	loc := w.Header().Get("Location")
+	if len(loc) <= len(jobsPathPrefix) || loc[:len(jobsPathPrefix)] != jobsPathPrefix {
+		t.Fatalf("Unexpected Location '%s'", loc)
+	}
+	if pa := w.Header().Get("Preference-Applied"); pa != "respond-async" {
+		t.Fatalf("Unexpected Preference-Applied '%s'", pa)
+	}
+	return loc
+}
+
+// getTestJob fetches the job resource and returns its info.
+func getTestJob(t *testing.T, s *Router, uri string, expStatus int) jobInfo {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "GET", uri, ""))
+	verifyResponse(t, w, expStatus)
+
+	var resp map[string][]jobInfo
+	if expStatus == 200 {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp["sonic-rest-server:job"]) != 1 {
+			t.Fatalf("Unexpected job response '%s'", w.Body.String())
+		}
+		return resp["sonic-rest-server:job"][0]
+	}
+	return jobInfo{}
+}
+
+// waitTestJob polls the job resource till it is not running.
+func waitTestJob(t *testing.T, s *Router, uri string) jobInfo {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if info := getTestJob(t, s, uri, 200); info.Status != jobRunning && info.Status != jobCancelRequested {
+			return info
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Job %s did not finish", uri)
+	return jobInfo{}
+}
+
+func TestAsyncJob(t *testing.T) {
+	defer useAsyncJobLimits(4, time.Hour)()
+	b := newBlockingBackend()
+	defer useBackend("/restconf/operations/jobs-test:", b)()
+	s := newJobsTestRouter()
+
+	uri := startTestJob(t, s)
+	if info := getTestJob(t, s, uri, 200); info.Status != jobRunning || info.Operation != "/restconf/operations/jobs-test:ping" {
+		t.Fatalf("Unexpected job info %+v", info)
+	}
+
+	close(b.release)
+	info := waitTestJob(t, s, uri)
+	if info.Status != jobCompleted || info.HTTPStatus != 200 ||
+		string(info.Result) != `{"jobs-test:output":{"status":"done"}}` {
+		t.Fatalf("Unexpected job info %+v; result=%s", info, info.Result)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "GET", jobsPathPrefix, ""))
+	verifyResponse(t, w, 200)
+	var list map[string]map[string][]jobInfo
+	if json.Unmarshal(w.Body.Bytes(), &list) != nil || len(list["sonic-rest-server:jobs"]["job"]) != 1 {
+		t.Fatalf("Unexpected job list '%s'", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "DELETE", uri, ""))
+	verifyResponse(t, w, 204)
+	getTestJob(t, s, uri, 404)
+}
+
+func TestAsyncJob_configWrite(t *testing.T) {
+	defer useAsyncJobLimits(4, time.Hour)()
+	clearConfigLocks()
+	defer clearConfigLocks()
+	b := newBlockingBackend()
+	defer useBackend("/restconf/operations/jobs-test:", b)()
+	s := newJobsTestRouter()
+	s.addRoute("lock", "POST", "/restconf/operations/sonic-rest-server:lock", lockHandler)
+
+	// Job holds the config write till it finishes
+	uri := startTestJob(t, s)
+	if n := numConfigWrites(); n != 1 {
+		t.Fatalf("Expected 1 write in progress; found %d", n)
+	}
+	close(b.release)
+	waitTestJob(t, s, uri)
+	for i := 0; numConfigWrites() != 0; i++ {
+		if i == 100 {
+			t.Fatalf("Config write not ended after the job finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Locks are checked before starting the job
+	acquireTestLock(t, s, "alice", `{}`)
+	r := prepareRequest(t, "POST", "/restconf/operations/jobs-test:ping", "")
+	r.Header.Set("Prefer", "respond-async")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	verifyLockDenied(t, w, "alice")
+	if n := len(listTestJobs()); n != 1 {
+		t.Fatalf("Expected only the first job; found %d", n)
+	}
+}
+
+// listTestJobs returns the ids of all jobs.
+func listTestJobs() []string {
+	asyncJobs.mu.Lock()
+	defer asyncJobs.mu.Unlock()
+	var ids []string
+	for id := range asyncJobs.jobs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestAsyncJob_cancel(t *testing.T) {
+	defer useAsyncJobLimits(4, time.Hour)()
+	b := newBlockingBackend()
+	defer useBackend("/restconf/operations/jobs-test:", b)()
+	s := newJobsTestRouter()
+
+	uri := startTestJob(t, s)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "DELETE", uri, ""))
+	verifyResponse(t, w, 202)
+
+	select {
+	case <-b.cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("Job context was not cancelled")
+	}
+	if info := waitTestJob(t, s, uri); info.Status != jobCancelled {
+		t.Fatalf("Expected job status '%s'; found '%s'", jobCancelled, info.Status)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "DELETE", uri, ""))
+	verifyResponse(t, w, 204)
+	getTestJob(t, s, uri, 404)
+}
+
+func TestAsyncJob_cancelIgnored(t *testing.T) {
+	defer useAsyncJobLimits(4, time.Hour)()
+	b := &recordingBackend{resp: []byte(`{"status":"done"}`)}
+	release := make(chan struct{})
+	defer useBackend("/restconf/operations/jobs-test:", &ignoreCancelBackend{b, release})()
+	s := newJobsTestRouter()
+
+	uri := startTestJob(t, s)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "DELETE", uri, ""))
+	verifyResponse(t, w, 202)
+	if info := getTestJob(t, s, uri, 200); info.Status != jobCancelRequested {
+		t.Fatalf("Expected job status '%s'; found '%s'", jobCancelRequested, info.Status)
+	}
+
+	// Backend ignores the cancellation and completes the action
+	close(release)
+	if info := waitTestJob(t, s, uri); info.Status != jobCompleted {
+		t.Fatalf("Expected job status '%s'; found '%s'", jobCompleted, info.Status)
+	}
+}
+
+func TestAsyncJob_limit(t *testing.T) {
+	defer useAsyncJobLimits(2, time.Hour)()
+	b := newBlockingBackend()
+	defer useBackend("/restconf/operations/jobs-test:", b)()
+	s := newJobsTestRouter()
+
+	uri1 := startTestJob(t, s)
+	uri2 := startTestJob(t, s)
+
+	w := httptest.NewRecorder()
+	r := prepareRequest(t, "POST", "/restconf/operations/jobs-test:ping", "")
+	r.Header.Set("Prefer", "respond-async")
+	s.ServeHTTP(w, r)
+	verifyResponse(t, w, 503)
+
+	// Finished jobs make room for new ones
+	close(b.release)
+	waitTestJob(t, s, uri1)
+	waitTestJob(t, s, uri2)
+	waitTestJob(t, s, startTestJob(t, s))
+
+	asyncJobs.mu.Lock()
+	n := len(asyncJobs.jobs)
+	asyncJobs.mu.Unlock()
+	if n != 2 {
+		t.Fatalf("Expected 2 jobs; found %d", n)
+	}
+}
+
+func TestAsyncJob_retention(t *testing.T) {
+	defer useAsyncJobLimits(4, 0)()
+	b := newBlockingBackend()
+	close(b.release)
+	defer useBackend("/restconf/operations/jobs-test:", b)()
+	s := newJobsTestRouter()
+
+	uri := startTestJob(t, s)
+	for i := 0; i < 100; i++ {
+		asyncJobs.mu.Lock()
+		n := len(asyncJobs.jobs)
+		if n != 0 {
+			purgeAsyncJobs(time.Now())
+		}
+		asyncJobs.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	getTestJob(t, s, uri, 404)
+}
+
+func TestPrefersAsync(t *testing.T) {
+	for v, exp := range map[string]bool{
+		"":                               false,
+		"respond-async":                  true,
+		"Respond-Async":                  true,
+		"return=minimal; wait=10":        false,
+		"wait=10, respond-async":         true,
+		"handling=lenient;respond-async": true,
+	} {
+		r := httptest.NewRequest("POST", "/", nil)
+		if v != "" {
+			r.Header.Set("Prefer", v)
+		}
+		if prefersAsync(r) != exp {
+			t.Errorf("prefersAsync returned %v for '%s'", !exp, v)
+		}
+	}
+}
@@ -0,0 +1,482 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Configuration lock settings
+var (
+	// configLockTimeout is the default idle timeout of a lock. Lock expires
+	// if it is not used for a write request within this duration.
+	configLockTimeout = 10 * time.Minute
+
+	// configLockMaxTimeout is the maximum idle timeout a client can request.
+	configLockMaxTimeout = time.Hour
+)
+
+// lockIDHeader is the request header through which a client presents
+// the ids of the locks it holds.
+const lockIDHeader = "Lock-Id"
+
+// locksPath is the URI of the locks collection.
+const locksPath = "/restconf/data/sonic-rest-server:locks"
+
+func init() {
+	flag.DurationVar(&configLockTimeout, "config_lock_timeout", configLockTimeout,
+		"Default idle timeout of configuration locks")
+	flag.DurationVar(&configLockMaxTimeout, "config_lock_max_timeout", configLockMaxTimeout,
+		"Maximum idle timeout of configuration locks")
+
+	AddRoute("lock", "POST", "/restconf/operations/sonic-rest-server:lock", lockHandler, ReadOnlyAllowed())
+	AddRoute("unlock", "POST", "/restconf/operations/sonic-rest-server:unlock", unlockHandler, ReadOnlyAllowed())
+	AddRoute("listLocks", "GET", locksPath, lockListHandler)
+}
+
+// configLock is a lock on the configuration subtree at a translib path;
+// or on the whole configuration if the path is "/". Write requests for
+// overlapping paths are allowed only for the lock holder, which is the
+// client presenting the lock id in Lock-Id header as the same user.
+type configLock struct {
+	id      string
+	user    string
+	path    string // translib path
+	uri     string // RESTCONF path, as requested by the client
+	start   time.Time
+	timeout time.Duration
+	expiry  time.Time
+}
+
+// lockInfo is the json representation of a configLock.
+type lockInfo struct {
+	ID        string `json:"lock-id,omitempty"`
+	User      string `json:"user"`
+	Path      string `json:"path"`
+	StartTime string `json:"start-time"`
+	Expiry    string `json:"expiry-time"`
+}
+
+// configLocks is the registry of all locks, indexed by lock id. It also
+// tracks the write requests in progress.
+var configLocks = struct {
+	mu     sync.Mutex
+	locks  map[string]*configLock
+	writes map[*configWrite]bool
+}{
+	locks:  make(map[string]*configLock),
+	writes: make(map[*configWrite]bool),
+}
+
+// configWrite is a write request in progress, on one or more translib
+// paths. Registered by startConfigWrite.
+type configWrite struct {
+	paths []string
+}
+
+// lockDeniedError indicates that a write request or a lock request
+// conflicts with the lock held by another client.
+type lockDeniedError struct {
+	holder lockInfo
+}
+
+func (e lockDeniedError) Error() string {
+	return fmt.Sprintf("Configuration is locked by user '%s'", e.holder.User)
+}
+
+// writeInProgressError indicates that a lock request conflicts with
+// a write request in progress.
+type writeInProgressError struct{}
+
+func (e writeInProgressError) Error() string {
+	return "Configuration is being modified; try again"
+}
+
+// info returns the lockInfo for the lock. Lock id is included only if
+// withID is true. Caller should hold the lock.
+func (l *configLock) info(withID bool) lockInfo {
+	info := lockInfo{
+		User:      l.user,
+		Path:      l.uri,
+		StartTime: l.start.UTC().Format(time.RFC3339),
+		Expiry:    l.expiry.UTC().Format(time.RFC3339),
+	}
+	if withID {
+		info.ID = l.id
+	}
+	return info
+}
+
+// purgeConfigLocks removes the expired locks. Caller should hold the lock.
+func purgeConfigLocks(now time.Time) {
+	for id, l := range configLocks.locks {
+		if !now.Before(l.expiry) {
+			(&logEntry{}).with("lock_id", id).with("user", l.user).
+				Infof("Lock expired; path=%s", redactURLPath(l.uri))
+			delete(configLocks.locks, id)
+		}
+	}
+}
+
+// requestLockIDs returns the lock ids from the Lock-Id headers.
+// Header value can contain comma separated ids.
+func requestLockIDs(r *http.Request) map[string]bool {
+	ids := make(map[string]bool)
+	for _, v := range r.Header[lockIDHeader] {
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); len(id) != 0 {
+				ids[id] = true
+			}
+		}
+	}
+	return ids
+}
+
+// isLockHolder checks if the request is from the holder of lock l.
+func isLockHolder(l *configLock, ids map[string]bool, user string) bool {
+	return ids[l.id] && l.user == user
+}
+
+// checkConfigLock checks if a write request for the translib path can
+// proceed. Returns a lockDeniedError if any other client holds a lock on
+// an overlapping path. Locks held by the requester are refreshed, to
+// extend their expiry. Write requests should use startConfigWrite, so
+// that the locks cannot be acquired while the write is in progress.
+func checkConfigLock(r *http.Request, rc *RequestContext, path string) error {
+	configLocks.mu.Lock()
+	defer configLocks.mu.Unlock()
+	purgeConfigLocks(time.Now())
+	return checkConfigLockUnsafe(r, rc, path)
+}
+
+// startConfigWrite checks the locks for a write request on the translib
+// paths, like checkConfigLock. Registers the write as in progress if it
+// can proceed; lock requests for overlapping paths are rejected till the
+// returned function is called after the backend call. Hence a lock
+// acquired after the check cannot be bypassed by the write. Returned
+// function is a no-op if the write cannot proceed.
+func startConfigWrite(r *http.Request, rc *RequestContext, paths ...string) (func(), error) {
+	configLocks.mu.Lock()
+	defer configLocks.mu.Unlock()
+	purgeConfigLocks(time.Now())
+
+	for _, path := range paths {
+		if err := checkConfigLockUnsafe(r, rc, path); err != nil {
+			return func() {}, err
+		}
+	}
+
+	w := &configWrite{paths: paths}
+	configLocks.writes[w] = true
+	return func() {
+		configLocks.mu.Lock()
+		delete(configLocks.writes, w)
+		configLocks.mu.Unlock()
+	}, nil
+}
+
+// checkConfigLockUnsafe is the checkConfigLock without locking.
+// Caller should hold the lock.
+func checkConfigLockUnsafe(r *http.Request, rc *RequestContext, path string) error {
+	now := time.Now()
+	ids := requestLockIDs(r)
+
+	for _, l := range configLocks.locks {
+		if !pathsOverlap(l.path, path) {
+			continue
+		}
+		if !isLockHolder(l, ids, rc.Username) {
+			requestLog(rc).Infof("Path %s is locked by user '%s'", redactTranslibPath(path), l.user)
+			return lockDeniedError{holder: l.info(false)}
+		}
+		l.expiry = now.Add(l.timeout)
+	}
+
+	return nil
+}
+
+// pathsOverlap checks if the translib paths a and b overlap; i.e, one of
+// them is an ancestor of (or same as) the other. List elements without
+// keys match all instances. Module prefixes are ignored.
+func pathsOverlap(a, b string) bool {
+	ea, eb := splitTranslibPath(a), splitTranslibPath(b)
+	for i := 0; i < len(ea) && i < len(eb); i++ {
+		na, ka := splitElemKeys(ea[i])
+		nb, kb := splitElemKeys(eb[i])
+		if localName(na) != localName(nb) {
+			return false
+		}
+		if len(ka) != 0 && len(kb) != 0 && !sameKeys(ka, kb) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameKeys checks if the "key=value" predicates a and b are the same,
+// irrespective of the order.
+func sameKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	x := append([]string(nil), a...)
+	y := append([]string(nil), b...)
+	sort.Strings(x)
+	sort.Strings(y)
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveTranslibPath returns the translib path for a RESTCONF data path.
+// Returns "/" for the datastore root; and an error if the path does
+// not match a data route.
+func resolveTranslibPath(r *http.Request, rc *RequestContext, uri string) (string, error) {
+	path := cleanPath(uri)
+	if path+"/" == restconfDataPathPrefix {
+		return "/", nil
+	}
+	if !strings.HasPrefix(path, restconfDataPathPrefix) || strings.ContainsAny(uri, "?#") {
+		return "", httpBadRequest("Invalid data path '%s'", uri)
+	}
+
+	match, err := matchDataRoute(r, path)
+	if err != nil {
+		return "", err
+	}
+
+	pathReq := setContextValue(r, routeMatchContextKey, match)
+	return getPathForTranslib(pathReq, rc), nil
+}
+
+// matchDataRoute resolves the route of a clean RESTCONF data path, using
+// the Router that is serving the request r.
+func matchDataRoute(r *http.Request, path string) (*routeMatchInfo, error) {
+	router, _ := getContextValue(r, routerObjContextKey).(*Router)
+	if router == nil {
+		return nil, httpServerError("Router not available")
+	}
+
+	match := new(routeMatchInfo)
+	if _, err := router.getRoutes().rcRoutes.match(path, match); err != nil {
+		return nil, err
+	}
+	return match, nil
+}
+
+// lockInput is the input of the lock RPC. Path is a RESTCONF data path;
+// whole configuration is locked if it is empty. Timeout is the idle
+// timeout in seconds. Eg:
+//
+//	{"sonic-rest-server:input": {
+//	  "path": "/restconf/data/openconfig-acl:acl",
+//	  "timeout": 600
+//	}}
+type lockInput struct {
+	Path    string `json:"path"`
+	Timeout int64  `json:"timeout"`
+}
+
+// unlockInput is the input of the unlock RPC.
+type unlockInput struct {
+	LockID string `json:"lock-id"`
+}
+
+// lockHandler serves the lock RPC, which locks the configuration subtree
+// for the current user session. Returns the lock id in the output; which
+// should be sent in the Lock-Id header of subsequent write requests.
+// Fails with 409 lock-denied if another client holds a lock on an
+// overlapping path.
+func lockHandler(w http.ResponseWriter, r *http.Request) {
+	rc, r := GetContext(r)
+	var input lockInput
+	err := parseServerRPCInput(r, &input)
+
+	timeout := configLockTimeout
+	if err == nil && input.Timeout != 0 {
+		// Validate before converting to Duration, which can overflow
+		maxTimeout := int64(configLockMaxTimeout.Seconds())
+		if input.Timeout < 0 || input.Timeout > maxTimeout {
+			err = httpBadRequest("Invalid timeout %d; should be 1 to %d seconds", input.Timeout, maxTimeout)
+		}
+		timeout = time.Duration(input.Timeout) * time.Second
+	}
+
+	uri := input.Path
+	if len(uri) == 0 {
+		uri = strings.TrimSuffix(restconfDataPathPrefix, "/")
+	}
+
+	var path string
+	if err == nil {
+		path, err = resolveTranslibPath(r, rc, uri)
+	}
+
+	var l *configLock
+	if err == nil {
+		l, err = acquireConfigLock(r, rc, path, uri, timeout)
+	}
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	configLocks.mu.Lock()
+	info := l.info(true)
+	configLocks.mu.Unlock()
+
+	data, _ := json.Marshal(map[string]interface{}{"sonic-rest-server:output": &info})
+	w.Header().Set("Content-Type", mimeYangDataJSON)
+	w.Write(data)
+}
+
+// acquireConfigLock creates a lock on the translib path for the current
+// user. Returns lockDeniedError if any other client holds a lock on an
+// overlapping path; and writeInProgressError if a write request on an
+// overlapping path is in progress.
+func acquireConfigLock(r *http.Request, rc *RequestContext, path, uri string, timeout time.Duration) (*configLock, error) {
+	now := time.Now()
+	ids := requestLockIDs(r)
+
+	configLocks.mu.Lock()
+	defer configLocks.mu.Unlock()
+	purgeConfigLocks(now)
+
+	for _, l := range configLocks.locks {
+		if pathsOverlap(l.path, path) && !isLockHolder(l, ids, rc.Username) {
+			return nil, lockDeniedError{holder: l.info(false)}
+		}
+	}
+	for w := range configLocks.writes {
+		for _, p := range w.paths {
+			if pathsOverlap(p, path) {
+				return nil, writeInProgressError{}
+			}
+		}
+	}
+
+	l := &configLock{
+		id:      newTraceID(),
+		user:    rc.Username,
+		path:    path,
+		uri:     uri,
+		start:   now,
+		timeout: timeout,
+		expiry:  now.Add(timeout),
+	}
+
+	configLocks.locks[l.id] = l
+	requestLog(rc).with("lock_id", l.id).Infof("Lock acquired; path=%s", redactURLPath(l.uri))
+	return l, nil
+}
+
+// unlockHandler serves the unlock RPC, which releases a lock held by
+// the current user.
+func unlockHandler(w http.ResponseWriter, r *http.Request) {
+	rc, r := GetContext(r)
+	var input unlockInput
+	if err := parseServerRPCInput(r, &input); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	configLocks.mu.Lock()
+	purgeConfigLocks(time.Now())
+	l := configLocks.locks[input.LockID]
+	if l != nil && l.user == rc.Username {
+		delete(configLocks.locks, l.id)
+	}
+	configLocks.mu.Unlock()
+
+	if l == nil || l.user != rc.Username {
+		writeErrorResponse(w, r, httpError(http.StatusNotFound, "Lock not found"))
+		return
+	}
+
+	requestLog(rc).with("lock_id", l.id).Infof("Lock released")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lockListHandler serves "GET /restconf/data/sonic-rest-server:locks"
+// requests. Lists all locks; lock ids are shown only for the locks of
+// the current user.
+func lockListHandler(w http.ResponseWriter, r *http.Request) {
+	rc, r := GetContext(r)
+	var locks struct {
+		Lock []lockInfo `json:"lock"`
+	}
+
+	configLocks.mu.Lock()
+	purgeConfigLocks(time.Now())
+	for _, l := range configLocks.locks {
+		locks.Lock = append(locks.Lock, l.info(l.user == rc.Username))
+	}
+	configLocks.mu.Unlock()
+
+	sort.Slice(locks.Lock, func(i, j int) bool { return locks.Lock[i].StartTime < locks.Lock[j].StartTime })
+	data, _ := json.Marshal(map[string]interface{}{"sonic-rest-server:locks": &locks})
+	w.Header().Set("Content-Type", mimeYangDataJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+// parseServerRPCInput reads the input of a sonic-rest-server RPC from
+// request body into the struct pointed by input. Body should contain
+// only the "sonic-rest-server:input" node; unknown members are rejected.
+// Input is left as is if the body is empty.
+func parseServerRPCInput(r *http.Request, input interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return httpServerError("Failed to read request body")
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	var in map[string]json.RawMessage
+	if err = json.Unmarshal(body, &in); err != nil {
+		return httpBadRequest("Invalid input; %v", err)
+	}
+
+	data, ok := in["sonic-rest-server:input"]
+	if len(in) != 1 || !ok {
+		return httpBadRequest("Input should contain only 'sonic-rest-server:input' node")
+	}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err = d.Decode(input); err != nil {
+		return httpBadRequest("Invalid input; %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,309 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearConfigLocks removes all configuration locks.
+func clearConfigLocks() {
+	configLocks.mu.Lock()
+	configLocks.locks = make(map[string]*configLock)
+	configLocks.mu.Unlock()
+}
+
+func newLockTestRouter() *Router {
+	s := newEmptyRouter()
+	s.addRoute("lock", "POST", "/restconf/operations/sonic-rest-server:lock", lockHandler)
+	s.addRoute("unlock", "POST", "/restconf/operations/sonic-rest-server:unlock", unlockHandler)
+	s.addRoute("listLocks", "GET", locksPath, lockListHandler)
+	s.addRoute("batch", "POST", "/restconf/operations/sonic-rest-server:batch", batchHandler)
+	for _, m := range []string{"PATCH", "DELETE"} {
+		s.addRoute("top", m, "/restconf/data/lock-test:top", Process)
+		s.addRoute("server", m, "/restconf/data/lock-test:top/server={name}", Process)
+		s.addRoute("other", m, "/restconf/data/lock-test:other", Process)
+	}
+	return s
+}
+
+// lockTestRequest sends a request as the user, with the lock ids.
+func lockTestRequest(t *testing.T, s *Router, user, method, path, data string, lockIDs ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := prepareRequest(t, method, path, data)
+	rc, _ := GetContext(r)
+	rc.Username = user
+	if len(lockIDs) != 0 {
+		r.Header.Set(lockIDHeader, strings.Join(lockIDs, ","))
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+// acquireTestLock invokes the lock RPC as the user and returns the lock id.
+func acquireTestLock(t *testing.T, s *Router, user, input string) string {
+	t.Helper()
+	w := lockTestRequest(t, s, user, "POST", "/restconf/operations/sonic-rest-server:lock",
+		`{"sonic-rest-server:input": `+input+`}`)
+	verifyResponse(t, w, 200)
+
+	var out map[string]lockInfo
+	json.Unmarshal(w.Body.Bytes(), &out)
+	info := out["sonic-rest-server:output"]
+	if len(info.ID) == 0 || info.User != user {
+		t.Fatalf("Unexpected lock output: %s", w.Body.String())
+	}
+	return info.ID
+}
+
+// verifyLockDenied checks the response is a 409 lock-denied error
+// identifying the lock holder.
+func verifyLockDenied(t *testing.T, w *httptest.ResponseRecorder, holder string) {
+	t.Helper()
+	verifyResponse(t, w, 409)
+	body := w.Body.String()
+	if !strings.Contains(body, `"error-tag":"lock-denied"`) || !strings.Contains(body, `"user":"`+holder+`"`) {
+		t.Fatalf("Unexpected error response: %s", body)
+	}
+	// A panic after writing the response would append another error
+	if n := strings.Count(body, "ietf-restconf:errors"); n != 1 {
+		t.Fatalf("Expected one error response; found %d: %s", n, body)
+	}
+}
+
+func TestConfigLock(t *testing.T) {
+	clearConfigLocks()
+	defer clearConfigLocks()
+	defer useBackend("/restconf/data/lock-test:", &recordingBackend{})()
+	s := newLockTestRouter()
+	patch := `{"lock-test:server": [{"name": "s1"}]}`
+
+	id := acquireTestLock(t, s, "alice", `{"path": "/restconf/data/lock-test:top/server=s1"}`)
+
+	t.Run("other_user", func(t *testing.T) {
+		verifyLockDenied(t, lockTestRequest(t, s, "bob", "PATCH", "/lock-test:top/server=s1", patch), "alice")
+	})
+	t.Run("other_user_with_id", func(t *testing.T) {
+		verifyLockDenied(t, lockTestRequest(t, s, "bob", "PATCH", "/lock-test:top/server=s1", patch, id), "alice")
+	})
+	t.Run("ancestor", func(t *testing.T) {
+		verifyLockDenied(t, lockTestRequest(t, s, "bob", "DELETE", "/lock-test:top", ""), "alice")
+	})
+	t.Run("other_instance", func(t *testing.T) {
+		w := lockTestRequest(t, s, "bob", "PATCH", "/lock-test:top/server=s2", `{"lock-test:server": [{"name": "s2"}]}`)
+		verifyResponse(t, w, 204)
+	})
+	t.Run("holder_without_id", func(t *testing.T) {
+		verifyLockDenied(t, lockTestRequest(t, s, "alice", "PATCH", "/lock-test:top/server=s1", patch), "alice")
+	})
+	t.Run("holder", func(t *testing.T) {
+		verifyResponse(t, lockTestRequest(t, s, "alice", "PATCH", "/lock-test:top/server=s1", patch, "x", id), 204)
+	})
+	t.Run("overlapping_lock", func(t *testing.T) {
+		w := lockTestRequest(t, s, "bob", "POST", "/restconf/operations/sonic-rest-server:lock",
+			`{"sonic-rest-server:input": {"path": "/restconf/data/lock-test:top"}}`)
+		verifyLockDenied(t, w, "alice")
+	})
+	t.Run("batch", func(t *testing.T) {
+		body := `{"sonic-rest-server:input": {"operation": [
+			{"method": "DELETE", "path": "/restconf/data/lock-test:other"},
+			{"method": "DELETE", "path": "/restconf/data/lock-test:top/server=s1"}]}}`
+		w := lockTestRequest(t, s, "bob", "POST", "/restconf/operations/sonic-rest-server:batch", body)
+		verifyLockDenied(t, w, "alice")
+		if !strings.Contains(w.Body.String(), `"operation-index":1`) {
+			t.Fatalf("Operation index not reported: %s", w.Body.String())
+		}
+	})
+	t.Run("list", func(t *testing.T) {
+		if locks := listTestLocks(t, s, "bob"); len(locks) != 1 || locks[0].ID != "" || locks[0].User != "alice" {
+			t.Fatalf("Unexpected locks for other user: %+v", locks)
+		}
+		if locks := listTestLocks(t, s, "alice"); len(locks) != 1 || locks[0].ID != id {
+			t.Fatalf("Unexpected locks for holder: %+v", locks)
+		}
+	})
+	t.Run("unlock_other_user", func(t *testing.T) {
+		w := lockTestRequest(t, s, "bob", "POST", "/restconf/operations/sonic-rest-server:unlock",
+			`{"sonic-rest-server:input": {"lock-id": "`+id+`"}}`)
+		verifyResponse(t, w, 404)
+	})
+	t.Run("unlock", func(t *testing.T) {
+		w := lockTestRequest(t, s, "alice", "POST", "/restconf/operations/sonic-rest-server:unlock",
+			`{"sonic-rest-server:input": {"lock-id": "`+id+`"}}`)
+		verifyResponse(t, w, 204)
+		verifyResponse(t, lockTestRequest(t, s, "bob", "PATCH", "/lock-test:top/server=s1", patch), 204)
+	})
+}
+
+func TestConfigLock_global(t *testing.T) {
+	clearConfigLocks()
+	defer clearConfigLocks()
+	defer useBackend("/restconf/data/lock-test:", &recordingBackend{})()
+	s := newLockTestRouter()
+
+	id := acquireTestLock(t, s, "alice", `{}`)
+	verifyLockDenied(t, lockTestRequest(t, s, "bob", "DELETE", "/lock-test:other", ""), "alice")
+	verifyResponse(t, lockTestRequest(t, s, "alice", "DELETE", "/lock-test:other", "", id), 204)
+
+	// Holder can acquire more locks
+	in := `{"sonic-rest-server:input": {"path": "/restconf/data/lock-test:top"}}`
+	w := lockTestRequest(t, s, "alice", "POST", "/restconf/operations/sonic-rest-server:lock", in)
+	verifyLockDenied(t, w, "alice")
+	w = lockTestRequest(t, s, "alice", "POST", "/restconf/operations/sonic-rest-server:lock", in, id)
+	verifyResponse(t, w, 200)
+}
+
+func TestConfigLock_expiry(t *testing.T) {
+	clearConfigLocks()
+	defer clearConfigLocks()
+	defer useBackend("/restconf/data/lock-test:", &recordingBackend{})()
+	s := newLockTestRouter()
+
+	id := acquireTestLock(t, s, "alice", `{"path": "/restconf/data/lock-test:top", "timeout": 60}`)
+	configLocks.mu.Lock()
+	l := configLocks.locks[id]
+	if d := time.Until(l.expiry); d <= 0 || d > time.Minute {
+		t.Errorf("Unexpected lock expiry %v", l.expiry)
+	}
+
+	// Use by holder should extend the expiry
+	l.expiry = time.Now().Add(time.Second)
+	configLocks.mu.Unlock()
+	verifyResponse(t, lockTestRequest(t, s, "alice", "DELETE", "/lock-test:top", "", id), 204)
+
+	configLocks.mu.Lock()
+	if d := time.Until(l.expiry); d <= time.Second {
+		t.Errorf("Lock expiry not extended; %v", l.expiry)
+	}
+	l.expiry = time.Now().Add(-time.Second)
+	configLocks.mu.Unlock()
+
+	verifyResponse(t, lockTestRequest(t, s, "bob", "DELETE", "/lock-test:top", ""), 204)
+	if locks := listTestLocks(t, s, "alice"); len(locks) != 0 {
+		t.Fatalf("Expired lock not removed: %+v", locks)
+	}
+}
+
+func TestConfigLock_badInput(t *testing.T) {
+	clearConfigLocks()
+	defer clearConfigLocks()
+	s := newLockTestRouter()
+
+	for name, input := range map[string]string{
+		"timeout":     `{"timeout": 100000}`,
+		"neg_timeout": `{"timeout": -1}`,
+		"overflow":    `{"timeout": 9300000000000}`,
+		"path":        `{"path": "/restconf/operations/x:y"}`,
+		"unknown":     `{"xyz": 1}`,
+	} {
+		w := lockTestRequest(t, s, "alice", "POST", "/restconf/operations/sonic-rest-server:lock",
+			`{"sonic-rest-server:input": `+input+`}`)
+		if w.Code != 400 {
+			t.Errorf("%s: expected status 400; got %d", name, w.Code)
+		}
+	}
+
+	w := lockTestRequest(t, s, "alice", "POST", "/restconf/operations/sonic-rest-server:lock",
+		`{"sonic-rest-server:input": {"path": "/restconf/data/lock-test:xyz"}}`)
+	verifyResponse(t, w, 404)
+}
+
+func TestConfigLock_writeInProgress(t *testing.T) {
+	clearConfigLocks()
+	defer clearConfigLocks()
+	b := &slowBackend{release: make(chan struct{})}
+	defer useBackend("/restconf/data/lock-test:", b)()
+	s := newLockTestRouter()
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- lockTestRequest(t, s, "bob", "PATCH", "/lock-test:top", `{"lock-test:top": {}}`)
+	}()
+
+	for i := 0; numConfigWrites() == 0; i++ {
+		if i == 100 {
+			t.Fatalf("Write request not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Overlapping locks cannot be acquired while the write is in progress
+	w := lockTestRequest(t, s, "alice", "POST", "/restconf/operations/sonic-rest-server:lock",
+		`{"sonic-rest-server:input": {"path": "/restconf/data/lock-test:top/server=s1"}}`)
+	verifyResponse(t, w, 409)
+	if body := w.Body.String(); !strings.Contains(body, `"error-tag":"in-use"`) {
+		t.Fatalf("Unexpected error response: %s", body)
+	}
+	acquireTestLock(t, s, "alice", `{"path": "/restconf/data/lock-test:other"}`)
+
+	close(b.release)
+	verifyResponse(t, <-done, 204)
+	if n := numConfigWrites(); n != 0 {
+		t.Fatalf("Found %d writes in progress after completion", n)
+	}
+	acquireTestLock(t, s, "alice", `{"path": "/restconf/data/lock-test:top/server=s1"}`)
+}
+
+// numConfigWrites returns the number of write requests in progress.
+func numConfigWrites() int {
+	configLocks.mu.Lock()
+	defer configLocks.mu.Unlock()
+	return len(configLocks.writes)
+}
+
+// listTestLocks fetches the locks collection as the user.
+func listTestLocks(t *testing.T, s *Router, user string) []lockInfo {
+	t.Helper()
+	w := lockTestRequest(t, s, user, "GET", locksPath, "")
+	verifyResponse(t, w, 200)
+
+	var resp map[string]struct {
+		Lock []lockInfo `json:"lock"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return resp["sonic-rest-server:locks"].Lock
+}
+
+func TestPathsOverlap(t *testing.T) {
+	t.Run("same", testPathsOverlap("/a:x/y", "/a:x/y", true))
+	t.Run("ancestor", testPathsOverlap("/a:x", "/a:x/y/z", true))
+	t.Run("descendant", testPathsOverlap("/a:x/y/z", "/a:x", true))
+	t.Run("root", testPathsOverlap("/", "/a:x/y", true))
+	t.Run("sibling", testPathsOverlap("/a:x/y", "/a:x/z", false))
+	t.Run("prefix", testPathsOverlap("/a:x/y", "/a:x/yy", false))
+	t.Run("module_prefix", testPathsOverlap("/a:x/y", "/a:x/a:y", true))
+	t.Run("same_key", testPathsOverlap("/a:x/y[p=1][q=2]", "/a:x/y[q=2][p=1]/z", true))
+	t.Run("diff_key", testPathsOverlap("/a:x/y[p=1]", "/a:x/y[p=2]", false))
+	t.Run("whole_list", testPathsOverlap("/a:x/y", "/a:x/y[p=2]/z", true))
+}
+
+func testPathsOverlap(a, b string, exp bool) func(*testing.T) {
+	return func(t *testing.T) {
+		if v := pathsOverlap(a, b); v != exp {
+			t.Fatalf("pathsOverlap(%s, %s) returned %v", a, b, v)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// logFormat is the request log format -- "glog" or "json"
+var logFormat = "glog"
+
+func init() {
+	flag.StringVar(&logFormat, "log_format", logFormat, "Request log format - glog|json")
+}
+
+// logSeverity indicates the log message severity
+type logSeverity int
+
+const (
+	logInfo logSeverity = iota
+	logWarning
+	logError
+)
+
+var severityNames = []string{"info", "warning", "error"}
+
+// logField is a structured log field
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// logBackend is the interface for writing log messages with structured
+// fields. Depth is the number of stack frames to skip for identifying
+// the caller.
+type logBackend interface {
+	write(sev logSeverity, depth int, fields []logField, msg string)
+}
+
+var (
+	theLogBackend logBackend
+	logBackendMu  sync.Mutex
+)
+
+// getLogBackend returns the logBackend for current log_format value.
+func getLogBackend() logBackend {
+	logBackendMu.Lock()
+	defer logBackendMu.Unlock()
+
+	if theLogBackend == nil {
+		switch logFormat {
+		case "json":
+			theLogBackend = &jsonLogBackend{out: os.Stderr}
+		default:
+			theLogBackend = glogBackend{}
+		}
+	}
+
+	return theLogBackend
+}
+
+// logEntry holds structured log fields and provides functions to log
+// messages with those fields. Fields are listed in the same order as
+// they were added. logEntry objects should not be modified once created;
+// use with() to derive new entries.
+type logEntry struct {
+	fields []logField
+}
+
+// requestLog returns a logEntry with request specific fields of rc --
+// request id, user name and route name.
+func requestLog(rc *RequestContext) *logEntry {
+	e := &logEntry{}
+	if rc == nil {
+		return e
+	}
+
+	e.fields = append(e.fields, logField{"request_id", rc.ID})
+	if rc.Username != "" {
+		e.fields = append(e.fields, logField{"user", rc.Username})
+	}
+	if rc.Name != "" {
+		e.fields = append(e.fields, logField{"route", rc.Name})
+	}
+
+	return e
+}
+
+// with returns a new logEntry with an extra field.
+func (e *logEntry) with(key string, value interface{}) *logEntry {
+	fields := make([]logField, len(e.fields), len(e.fields)+1)
+	copy(fields, e.fields)
+	return &logEntry{fields: append(fields, logField{key, value})}
+}
+
+// Infof logs an info message
+func (e *logEntry) Infof(format string, args ...interface{}) {
+	getLogBackend().write(logInfo, 1, e.fields, fmt.Sprintf(format, args...))
+}
+
+// Warningf logs a warning message
+func (e *logEntry) Warningf(format string, args ...interface{}) {
+	getLogBackend().write(logWarning, 1, e.fields, fmt.Sprintf(format, args...))
+}
+
+// Errorf logs an error message
+func (e *logEntry) Errorf(format string, args ...interface{}) {
+	getLogBackend().write(logError, 1, e.fields, fmt.Sprintf(format, args...))
+}
+
+// glogBackend writes log messages through glog, in the traditional
+// "[request_id] message key=value..." format.
+type glogBackend struct{}
+
+func (glogBackend) write(sev logSeverity, depth int, fields []logField, msg string) {
+	var buf bytes.Buffer
+	for _, f := range fields {
+		if f.key == "request_id" {
+			fmt.Fprintf(&buf, "[%v] ", f.value)
+		}
+	}
+
+	buf.WriteString(msg)
+	for _, f := range fields {
+		if f.key != "request_id" {
+			fmt.Fprintf(&buf, " %s=%v", f.key, f.value)
+		}
+	}
+
+	switch sev {
+	case logWarning:
+		glog.WarningDepth(depth+1, buf.String())
+	case logError:
+		glog.ErrorDepth(depth+1, buf.String())
+	default:
+		glog.InfoDepth(depth+1, buf.String())
+	}
+}
+
+// jsonLogBackend writes log messages as JSON lines, one object per
+// message. Includes "time", "level" and "msg" fields in addition to
+// the structured fields.
+type jsonLogBackend struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (b *jsonLogBackend) write(sev logSeverity, depth int, fields []logField, msg string) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeJSONValue(&buf, time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSONValue(&buf, severityNames[sev])
+	for _, f := range fields {
+		buf.WriteByte(',')
+		writeJSONValue(&buf, f.key)
+		buf.WriteByte(':')
+		writeJSONValue(&buf, f.value)
+	}
+	buf.WriteString(`,"msg":`)
+	writeJSONValue(&buf, msg)
+	buf.WriteString("}\n")
+
+	b.mu.Lock()
+	b.out.Write(buf.Bytes())
+	b.mu.Unlock()
+}
+
+// writeJSONValue writes json encoded value v into the buffer. Durations
+// are written as milliseconds and byte slices as strings.
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	switch x := v.(type) {
+	case time.Duration:
+		v = float64(x) / float64(time.Millisecond)
+	case []byte:
+		v = string(x)
+	case error:
+		v = x.Error()
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+
+	buf.Write(data)
+}
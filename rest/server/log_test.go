@@ -0,0 +1,132 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useJSONLogs switches the log backend to json format, writing into
+// a buffer. Returns a function to restore the original backend.
+func useJSONLogs(buf *bytes.Buffer) func() {
+	logBackendMu.Lock()
+	orig := theLogBackend
+	theLogBackend = &jsonLogBackend{out: buf}
+	logBackendMu.Unlock()
+
+	return func() {
+		logBackendMu.Lock()
+		theLogBackend = orig
+		logBackendMu.Unlock()
+	}
+}
+
+// parseJSONLogs parses json log lines from a buffer.
+func parseJSONLogs(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		rec := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("Bad log line \"%s\"; err=%v", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestJSONLog(t *testing.T) {
+	var buf bytes.Buffer
+	defer useJSONLogs(&buf)()
+
+	rc := &RequestContext{ID: "req-1", Name: "testRoute", Username: "admin"}
+	requestLog(rc).with("status", 200).with("latency", 1500*time.Microsecond).
+		Warningf("hello %s", "world")
+
+	recs := parseJSONLogs(t, &buf)
+	if len(recs) != 1 {
+		t.Fatalf("Expected 1 log record; found %d", len(recs))
+	}
+
+	exp := map[string]interface{}{
+		"level": "warning", "msg": "hello world", "request_id": "req-1",
+		"user": "admin", "route": "testRoute", "status": 200.0, "latency": 1.5,
+	}
+	for k, v := range exp {
+		if recs[0][k] != v {
+			t.Errorf("Expected %s=%v; found %v", k, v, recs[0][k])
+		}
+	}
+	if _, ok := recs[0]["time"]; !ok {
+		t.Errorf("No time field in log record %v", recs[0])
+	}
+}
+
+func TestLogEntryWith(t *testing.T) {
+	e1 := requestLog(&RequestContext{ID: "x"})
+	e2 := e1.with("a", 1)
+	e3 := e1.with("b", 2)
+
+	if len(e1.fields) != 1 || len(e2.fields) != 2 || len(e3.fields) != 2 {
+		t.Fatalf("with() modified the parent entry")
+	}
+	if e2.fields[1].key != "a" || e3.fields[1].key != "b" {
+		t.Fatalf("Unexpected fields %v, %v", e2.fields, e3.fields)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	defer useJSONLogs(&buf)()
+
+	s := newEmptyRouter()
+	s.addRoute("accessLogTest", "GET", "/restconf/data/api-tests:log", newHandler(202))
+
+	r := httptest.NewRequest("GET", "/restconf/data/api-tests:log", nil)
+	r.Header.Set("X-Request-ID", "access-1")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+
+	var access map[string]interface{}
+	for _, rec := range parseJSONLogs(t, &buf) {
+		if rec["msg"] == "Request completed" {
+			access = rec
+		}
+	}
+	if access == nil {
+		t.Fatalf("Access log not found: %s", buf.String())
+	}
+
+	exp := map[string]interface{}{
+		"request_id": "access-1", "route": "accessLogTest", "method": "GET",
+		"path": "/restconf/data/api-tests:log", "status": 202.0, "bytes": 0.0,
+	}
+	for k, v := range exp {
+		if access[k] != v {
+			t.Errorf("Expected %s=%v; found %v", k, v, access[k])
+		}
+	}
+	if _, ok := access["latency"]; !ok {
+		t.Errorf("No latency in access log %v", access)
+	}
+}
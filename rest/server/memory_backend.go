@@ -0,0 +1,813 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Azure/sonic-mgmt-common/translib/tlerr"
+)
+
+// MemoryBackend is a Backend which keeps the data as an RFC7951 json
+// tree in memory. It can be used to run the REST server without translib
+// and redis; mainly for testing.
+//
+// MemoryBackend does not use YANG schema. Top level nodes are stored
+// with module prefix and all other nodes without module prefix. List
+// keys are learnt from the request paths (like "list[name=x]") or can be
+// registered through SetListKeys. List entries cannot be matched until
+// the keys are known; merge replaces the whole list and create appends
+// the new entries in such cases.
+type MemoryBackend struct {
+	mu   sync.Mutex
+	data map[string]interface{}
+	keys map[string][]string // list key names, indexed by schema path
+}
+
+// NewMemoryBackend creates an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		data: make(map[string]interface{}),
+		keys: make(map[string][]string),
+	}
+}
+
+// SetListKeys registers key names of a list. List is identified by
+// its schema path without module prefixes; like "/acl/acl-sets/acl-set".
+func (b *MemoryBackend) SetListKeys(listPath string, keys ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.keys[listPath] = keys
+}
+
+// memPathElem is a parsed path element
+type memPathElem struct {
+	name   string   // node name, as given in the path
+	schema string   // schema path, without module prefixes
+	keys   []string // key names
+	values []string // key values, unescaped
+}
+
+// memNode identifies a data node in the tree. It is identified by the
+// parent container and node name. For list entries, it also holds the
+// index of the entry in the list array; -1 if the entry does not exist.
+type memNode struct {
+	parent map[string]interface{}
+	elem   *memPathElem
+	name   string // member name in the parent container
+	index  int
+}
+
+func (n *memNode) isListEntry() bool {
+	return len(n.elem.keys) != 0
+}
+
+// value returns the current value of the node. Returns nil if the node
+// does not exist. List entry value is the entry object.
+func (n *memNode) value() interface{} {
+	v := n.parent[n.name]
+	if !n.isListEntry() {
+		return v
+	}
+	if n.index < 0 {
+		return nil
+	}
+	return v.([]interface{})[n.index]
+}
+
+// parseMemPath parses a translib path into a list of memPathElem.
+func parseMemPath(path string) ([]memPathElem, error) {
+	var elems []memPathElem
+	var schema string
+	for _, s := range splitTranslibPath(path) {
+		name, kvs := splitElemKeys(s)
+		schema += "/" + localName(name)
+		e := memPathElem{name: name, schema: schema}
+		for _, kv := range kvs {
+			k := strings.IndexByte(kv, '=')
+			e.keys = append(e.keys, kv[:k])
+			e.values = append(e.values, unescapeKeyValue(kv[k+1:]))
+		}
+		if len(elems) == 0 && strings.IndexByte(name, ':') <= 0 {
+			return nil, tlerr.InvalidArgs("Module prefix missing in '%s'", name)
+		}
+		elems = append(elems, e)
+	}
+	return elems, nil
+}
+
+// unescapeKeyValue reverses escapeKeyValue.
+func unescapeKeyValue(val string) string {
+	val = strings.Replace(val, "\\]", "]", -1)
+	val = strings.Replace(val, "\\\\", "\\", -1)
+	return val
+}
+
+// qualifiedName returns the module qualified name of last path element.
+func qualifiedName(elems []memPathElem) string {
+	name := elems[len(elems)-1].name
+	if strings.IndexByte(name, ':') > 0 {
+		return name
+	}
+	return elems[0].name[:strings.IndexByte(elems[0].name, ':')] + ":" + name
+}
+
+// locate finds the node for the path elements in data tree. Missing
+// intermediate containers and list entries are created if create is true;
+// returns NotFoundError otherwise. Last element is not created.
+func (b *MemoryBackend) locate(data map[string]interface{}, elems []memPathElem, create bool) (*memNode, error) {
+	cur := data
+	for i := range elems {
+		e := &elems[i]
+		n := &memNode{parent: cur, elem: e, name: e.name, index: -1}
+		if i != 0 {
+			n.name = localName(e.name)
+		}
+
+		if n.isListEntry() {
+			b.learnListKeys(e.schema, e.keys)
+			list, ok := cur[n.name].([]interface{})
+			if !ok && cur[n.name] != nil {
+				return nil, tlerr.InvalidArgs("'%s' is not a list", e.name)
+			}
+			n.index = findListEntry(list, e.keys, e.values)
+		}
+
+		if i == len(elems)-1 {
+			return n, nil
+		}
+
+		v := n.value()
+		if v == nil && create {
+			v = newListEntryOrContainer(n)
+		}
+		if v == nil {
+			return nil, tlerr.NotFound("Resource not found")
+		}
+
+		var ok bool
+		if cur, ok = v.(map[string]interface{}); !ok {
+			return nil, tlerr.InvalidArgs("'%s' is not a container or list entry", e.name)
+		}
+	}
+
+	return nil, nil
+}
+
+// newListEntryOrContainer creates an empty container or list entry
+// for the node n.
+func newListEntryOrContainer(n *memNode) map[string]interface{} {
+	m := make(map[string]interface{})
+	if !n.isListEntry() {
+		n.parent[n.name] = m
+		return m
+	}
+
+	for i, k := range n.elem.keys {
+		m[k] = n.elem.values[i]
+	}
+	list, _ := n.parent[n.name].([]interface{})
+	n.parent[n.name] = append(list, m)
+	n.index = len(list)
+	return m
+}
+
+// learnListKeys records the key names of a list if not known already.
+// Caller should hold the lock.
+func (b *MemoryBackend) learnListKeys(listPath string, keys []string) {
+	if _, ok := b.keys[listPath]; !ok {
+		b.keys[listPath] = keys
+	}
+}
+
+// findListEntry returns the index of the list entry having the given
+// key values. Returns -1 if not found.
+func findListEntry(list []interface{}, keys, values []string) int {
+	for i, entry := range list {
+		m, ok := entry.(map[string]interface{})
+		if ok && hasKeyValues(m, keys, values) {
+			return i
+		}
+	}
+	return -1
+}
+
+func hasKeyValues(m map[string]interface{}, keys, values []string) bool {
+	for i, k := range keys {
+		if v, ok := m[k]; !ok || fmt.Sprint(v) != values[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// keyValues returns the values of given keys from a list entry.
+// Returns nil if the entry does not have all the keys.
+func keyValues(m map[string]interface{}, keys []string) []string {
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		v, ok := m[k]
+		if !ok {
+			return nil
+		}
+		values[i] = fmt.Sprint(v)
+	}
+	return values
+}
+
+// Get returns the data at the request path. Depth and Fields parameters
+// are honored. All data is treated as configuration; hence Content can
+// only be "all" or "config".
+func (b *MemoryBackend) Get(req BackendRequest) (BackendResponse, error) {
+	if len(req.Content) != 0 && req.Content != "all" && req.Content != "config" {
+		return BackendResponse{}, tlerr.NotSupported("content query parameter is not supported")
+	}
+
+	elems, err := parseMemPath(req.Path)
+	if err != nil {
+		return BackendResponse{}, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var result interface{} = b.data
+	if len(elems) != 0 {
+		n, err := b.locate(b.data, elems, false)
+		if err != nil {
+			return BackendResponse{}, err
+		}
+
+		v := n.value()
+		if v == nil {
+			return BackendResponse{}, tlerr.NotFound("Resource not found")
+		}
+		if n.isListEntry() {
+			v = []interface{}{v}
+		}
+		if len(req.Fields) != 0 {
+			v = b.selectFields(n.elem.schema, v, req.Fields)
+		}
+		if req.Depth != 0 {
+			v = pruneDepth(v, req.Depth)
+		}
+
+		result = map[string]interface{}{qualifiedName(elems): v}
+
+	} else if req.Depth != 0 {
+		result = pruneDepth(b.data, req.Depth)
+	}
+
+	data, err := json.Marshal(result)
+	return BackendResponse{Payload: data}, err
+}
+
+// Create creates the child nodes of the request path from the payload.
+// Returns AlreadyExistsError if any of them exists already. Request path
+// can also be a list path, with payload containing new list entries.
+func (b *MemoryBackend) Create(req BackendRequest) (BackendResponse, error) {
+	return b.write(req, func(b *MemoryBackend, n *memNode, data map[string]interface{}) error {
+		body, err := decodePayload(req.Payload, n == nil)
+		if err != nil {
+			return err
+		}
+
+		var target map[string]interface{}
+		var schema string
+
+		switch {
+		case n == nil:
+			target = data
+		case !n.isListEntry() && isListPayload(n, body):
+			// POST on a list path; payload contains the list itself
+			return b.createNode(n.parent, n.elem.schema, n.name, body[localName(n.elem.name)])
+		case n.isListEntry() && n.value() == nil:
+			return tlerr.NotFound("Resource not found")
+		default:
+			v := n.value()
+			if v == nil {
+				v = newListEntryOrContainer(n)
+			}
+			var ok bool
+			if target, ok = v.(map[string]interface{}); !ok {
+				return tlerr.InvalidArgs("Cannot create child nodes of '%s'", n.elem.name)
+			}
+			schema = n.elem.schema
+		}
+
+		for name, v := range body {
+			if err := b.createNode(target, schema+"/"+localName(name), name, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// createNode creates a new member in the container. Returns
+// AlreadyExistsError if the member or any of the list entries
+// exist already.
+func (b *MemoryBackend) createNode(container map[string]interface{}, schema, name string, v interface{}) error {
+	cur, exists := container[name]
+	if !exists {
+		container[name] = v
+		return nil
+	}
+
+	list, ok1 := cur.([]interface{})
+	entries, ok2 := v.([]interface{})
+	if !ok1 || !ok2 {
+		return tlerr.AlreadyExists("Resource '%s' already exists", name)
+	}
+
+	// Entries cannot be checked for duplicates without list keys
+	keys := b.keys[schema]
+	if len(keys) == 0 {
+		container[name] = append(list, entries...)
+		return nil
+	}
+
+	for _, entry := range entries {
+		m, ok := entry.(map[string]interface{})
+		values := keyValues(m, keys)
+		if !ok || values == nil {
+			return tlerr.InvalidArgs("Key values missing in '%s' entry", name)
+		}
+		if findListEntry(list, keys, values) >= 0 {
+			return tlerr.AlreadyExists("Resource '%s' with keys %v already exists", name, values)
+		}
+		list = append(list, m)
+	}
+
+	container[name] = list
+	return nil
+}
+
+// Replace replaces the data at request path with the payload.
+func (b *MemoryBackend) Replace(req BackendRequest) (BackendResponse, error) {
+	var created bool
+	resp, err := b.write(req, func(b *MemoryBackend, n *memNode, data map[string]interface{}) error {
+		if n == nil {
+			body, err := decodePayload(req.Payload, true)
+			if err != nil {
+				return err
+			}
+			for k := range data {
+				delete(data, k)
+			}
+			for k, v := range body {
+				data[k] = v
+			}
+			return nil
+		}
+
+		v, err := nodePayload(n, req.Payload)
+		if err != nil {
+			return err
+		}
+		created = n.value() == nil
+		n.setValue(v)
+		return nil
+	})
+
+	resp.Created = created && err == nil
+	return resp, err
+}
+
+// Update merges the payload with the data at request path.
+func (b *MemoryBackend) Update(req BackendRequest) (BackendResponse, error) {
+	return b.write(req, func(b *MemoryBackend, n *memNode, data map[string]interface{}) error {
+		if n == nil {
+			body, err := decodePayload(req.Payload, true)
+			if err != nil {
+				return err
+			}
+			b.mergeValue("", data, body)
+			return nil
+		}
+
+		v, err := nodePayload(n, req.Payload)
+		if err != nil {
+			return err
+		}
+		if cur := n.value(); cur != nil {
+			v = b.mergeValue(n.elem.schema, cur, v)
+		}
+		n.setValue(v)
+		return nil
+	})
+}
+
+// Delete deletes the data at request path.
+func (b *MemoryBackend) Delete(req BackendRequest) (BackendResponse, error) {
+	return b.write(req, func(b *MemoryBackend, n *memNode, data map[string]interface{}) error {
+		if n == nil {
+			for k := range data {
+				delete(data, k)
+			}
+			return nil
+		}
+
+		if n.value() == nil {
+			return tlerr.NotFound("Resource not found")
+		}
+		if !n.isListEntry() {
+			delete(n.parent, n.name)
+			return nil
+		}
+
+		list := n.parent[n.name].([]interface{})
+		list = append(list[:n.index], list[n.index+1:]...)
+		if len(list) == 0 {
+			delete(n.parent, n.name)
+		} else {
+			n.parent[n.name] = list
+		}
+		return nil
+	})
+}
+
+// Action is not supported by MemoryBackend.
+func (b *MemoryBackend) Action(req BackendRequest) (BackendResponse, error) {
+	return BackendResponse{}, tlerr.NotSupported("Operation not supported")
+}
+
+// Bulk applies the operations in the given order. Data is not modified
+// if any of them fails.
+func (b *MemoryBackend) Bulk(ops []BulkOperation) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Operations are applied on a copy, which becomes the current
+	// data only if all of them succeed.
+	tmp := &MemoryBackend{
+		data: b.data,
+		keys: make(map[string][]string, len(b.keys)),
+	}
+	for k, v := range b.keys {
+		tmp.keys[k] = v
+	}
+
+	writers := map[string]func(BackendRequest) (BackendResponse, error){
+		"DELETE": tmp.Delete,
+		"PUT":    tmp.Replace,
+		"PATCH":  tmp.Update,
+		"POST":   tmp.Create,
+	}
+
+	resps := make([]BackendResponse, len(ops))
+	for i, op := range ops {
+		f, ok := writers[op.Method]
+		if !ok {
+			return i, tlerr.NotSupported("Operation '%s' not supported in a bulk request", op.Method)
+		}
+		resp, err := f(op.Request)
+		if err != nil {
+			return i, err
+		}
+		resps[i] = resp
+	}
+
+	b.data, b.keys = tmp.data, tmp.keys
+	for i := range ops {
+		ops[i].Response = resps[i]
+	}
+	return -1, nil
+}
+
+// write performs a data modification through the function f. It is
+// called with a copy of the data tree and the request path's node;
+// nil node for the root path. The copy becomes the current data only
+// if f succeeds.
+func (b *MemoryBackend) write(req BackendRequest,
+	f func(b *MemoryBackend, n *memNode, data map[string]interface{}) error) (BackendResponse, error) {
+
+	elems, err := parseMemPath(req.Path)
+	if err != nil {
+		return BackendResponse{}, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data := deepCopy(b.data).(map[string]interface{})
+
+	var n *memNode
+	if len(elems) != 0 {
+		if n, err = b.locate(data, elems, true); err != nil {
+			return BackendResponse{}, err
+		}
+	}
+
+	if err = f(b, n, data); err == nil {
+		b.data = data
+	}
+
+	return BackendResponse{}, err
+}
+
+// setValue sets the value of the node; appends a new list entry if the
+// node is a list entry that does not exist.
+func (n *memNode) setValue(v interface{}) {
+	if !n.isListEntry() {
+		n.parent[n.name] = v
+		return
+	}
+
+	list, _ := n.parent[n.name].([]interface{})
+	if n.index < 0 {
+		n.index = len(list)
+		list = append(list, v)
+	} else {
+		list[n.index] = v
+	}
+	n.parent[n.name] = list
+}
+
+// decodePayload parses a json object payload. Module prefixes are
+// removed from the member names, except for top level members if
+// topLevel is true.
+func decodePayload(payload []byte, topLevel bool) (map[string]interface{}, error) {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, tlerr.InvalidArgs("Invalid json payload; %v", err)
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, tlerr.InvalidArgs("Payload is not a json object")
+	}
+
+	body := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if !topLevel {
+			k = localName(k)
+		}
+		body[k] = stripPrefixes(v)
+	}
+	return body, nil
+}
+
+// nodePayload returns the new value for node n from a PUT or PATCH
+// payload, which should contain only the node n. List entry
+// is expected as an array with one element; its keys should match
+// the key values from the path.
+func nodePayload(n *memNode, payload []byte) (interface{}, error) {
+	body, err := decodePayload(payload, false)
+	if err != nil {
+		return nil, err
+	}
+
+	name := localName(n.elem.name)
+	v, ok := body[name]
+	if !ok || len(body) != 1 {
+		return nil, tlerr.InvalidArgs("Payload should contain only '%s'", name)
+	}
+	if !n.isListEntry() {
+		return v, nil
+	}
+
+	if list, ok := v.([]interface{}); ok && len(list) == 1 {
+		v = list[0]
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, tlerr.InvalidArgs("Payload should contain one '%s' entry", name)
+	}
+
+	for i, k := range n.elem.keys {
+		if kv, ok := m[k]; !ok {
+			m[k] = n.elem.values[i]
+		} else if fmt.Sprint(kv) != n.elem.values[i] {
+			return nil, tlerr.InvalidArgs("Key '%s' value mismatch; '%s' in path, '%v' in payload",
+				k, n.elem.values[i], kv)
+		}
+	}
+	return m, nil
+}
+
+// isListPayload checks if the payload body contains only an array
+// member with the same name as node n.
+func isListPayload(n *memNode, body map[string]interface{}) bool {
+	_, ok := body[localName(n.elem.name)].([]interface{})
+	return ok && len(body) == 1
+}
+
+// mergeValue merges json value nv into v and returns the result.
+// Objects are merged recursively; list entries are merged by keys.
+// Other values are replaced.
+func (b *MemoryBackend) mergeValue(schema string, v, nv interface{}) interface{} {
+	switch x := nv.(type) {
+	case map[string]interface{}:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nv
+		}
+		for k, cv := range x {
+			if old, ok := m[k]; ok {
+				m[k] = b.mergeValue(schema+"/"+localName(k), old, cv)
+			} else {
+				m[k] = cv
+			}
+		}
+		return m
+
+	case []interface{}:
+		list, ok := v.([]interface{})
+		if !ok {
+			return nv
+		}
+		return b.mergeList(schema, list, x)
+	}
+
+	return nv
+}
+
+// mergeList merges the entries of a list or leaf-list. Whole list is
+// replaced if the list keys are not known.
+func (b *MemoryBackend) mergeList(schema string, list, entries []interface{}) []interface{} {
+	keys := b.keys[schema]
+	for _, e := range entries {
+		m, ok := e.(map[string]interface{})
+		if !ok { // leaf-list
+			if !containsValue(list, e) {
+				list = append(list, e)
+			}
+			continue
+		}
+
+		values := keyValues(m, keys)
+		if len(keys) == 0 || values == nil {
+			return entries
+		}
+		if i := findListEntry(list, keys, values); i >= 0 {
+			list[i] = b.mergeValue(schema, list[i], m)
+		} else {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+func containsValue(list []interface{}, v interface{}) bool {
+	for _, x := range list {
+		if fmt.Sprint(x) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectFields returns the subset of json value v containing only
+// the given fields. Fields are "/" separated paths relative to v.
+// List keys are always selected.
+func (b *MemoryBackend) selectFields(schema string, v interface{}, fields []string) interface{} {
+	switch x := v.(type) {
+	case []interface{}:
+		var out []interface{}
+		for _, e := range x {
+			out = append(out, b.selectFields(schema, e, fields))
+		}
+		return out
+
+	case map[string]interface{}:
+		out := make(map[string]interface{})
+		for _, k := range b.keys[schema] {
+			if kv, ok := x[k]; ok {
+				out[k] = kv
+			}
+		}
+
+		// Group the fields by first element
+		var names []string
+		children := make(map[string][]string)
+		whole := make(map[string]bool)
+		for _, f := range fields {
+			name, rest := strings.Trim(f, "/"), ""
+			if k := strings.IndexByte(name, '/'); k >= 0 {
+				name, rest = name[:k], name[k+1:]
+			}
+			name = localName(name)
+			if _, ok := children[name]; !ok && !whole[name] {
+				names = append(names, name)
+			}
+			if len(rest) == 0 {
+				whole[name] = true
+			} else {
+				children[name] = append(children[name], rest)
+			}
+		}
+
+		for _, name := range names {
+			cv, ok := x[name]
+			if !ok {
+				continue
+			}
+			if whole[name] {
+				out[name] = cv
+			} else if s := b.selectFields(schema+"/"+name, cv, children[name]); !isEmptyValue(s) {
+				out[name] = s
+			}
+		}
+		return out
+	}
+
+	return v
+}
+
+func isEmptyValue(v interface{}) bool {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		return len(x) == 0
+	case []interface{}:
+		return len(x) == 0
+	}
+	return v == nil
+}
+
+// pruneDepth returns a copy of json value v without the nodes beyond
+// the given depth. Value v is at depth 1. List entries are at the same
+// depth as the list.
+func pruneDepth(v interface{}, depth uint) interface{} {
+	switch x := v.(type) {
+	case []interface{}:
+		out := make([]interface{}, 0, len(x))
+		for _, e := range x {
+			out = append(out, pruneDepth(e, depth))
+		}
+		return out
+
+	case map[string]interface{}:
+		out := make(map[string]interface{})
+		for k, cv := range x {
+			if !isContainerValue(cv) {
+				out[k] = cv
+			} else if depth > 1 {
+				out[k] = pruneDepth(cv, depth-1)
+			}
+		}
+		return out
+	}
+
+	return v
+}
+
+// stripPrefixes removes module prefixes from all object member names
+// in json value v.
+func stripPrefixes(v interface{}) interface{} {
+	switch x := v.(type) {
+	case []interface{}:
+		for i, e := range x {
+			x[i] = stripPrefixes(e)
+		}
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, cv := range x {
+			m[localName(k)] = stripPrefixes(cv)
+		}
+		return m
+	}
+	return v
+}
+
+// deepCopy returns a copy of the json value v.
+func deepCopy(v interface{}) interface{} {
+	switch x := v.(type) {
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, e := range x {
+			out[i] = deepCopy(e)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			out[k] = deepCopy(e)
+		}
+		return out
+	}
+	return v
+}
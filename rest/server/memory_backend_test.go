@@ -0,0 +1,230 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/sonic-mgmt-common/translib/tlerr"
+)
+
+// newTestMemoryBackend creates a MemoryBackend with a "mem-test:top"
+// container having a "user" list and a "settings" container.
+func newTestMemoryBackend(t *testing.T) *MemoryBackend {
+	b := NewMemoryBackend()
+	b.SetListKeys("/top/user", "name")
+	memWrite(t, b.Replace, "/mem-test:top", `{"mem-test:top": {
+		"user": [
+			{"name": "u1", "role": "admin", "config": {"shell": "bash"}},
+			{"name": "u2", "role": "guest", "tags": ["x"]}
+		],
+		"settings": {"mtu": 9100, "timeout": 5}}}`)
+	return b
+}
+
+type memWriteFunc func(BackendRequest) (BackendResponse, error)
+
+func memWrite(t *testing.T, f memWriteFunc, path, payload string) {
+	t.Helper()
+	if _, err := f(BackendRequest{Path: path, Payload: []byte(payload)}); err != nil {
+		t.Fatalf("Write failed for %s; %v", path, err)
+	}
+}
+
+func verifyMemGet(t *testing.T, b *MemoryBackend, req BackendRequest, exp string) {
+	t.Helper()
+	resp, err := b.Get(req)
+	if err != nil {
+		t.Fatalf("Get failed for %s; %v", req.Path, err)
+	}
+
+	var v1, v2 interface{}
+	json.Unmarshal(resp.Payload, &v1)
+	if err = json.Unmarshal([]byte(exp), &v2); err != nil {
+		t.Fatalf("Bad expected data %s; %v", exp, err)
+	}
+	if !reflect.DeepEqual(v1, v2) {
+		t.Fatalf("Get %s returned unexpected data\nexpected: %s\nfound:    %s", req.Path, exp, resp.Payload)
+	}
+}
+
+func TestMemoryBackend_get(t *testing.T) {
+	b := newTestMemoryBackend(t)
+
+	t.Run("container", func(t *testing.T) {
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top/settings"},
+			`{"mem-test:settings": {"mtu": 9100, "timeout": 5}}`)
+	})
+	t.Run("leaf", func(t *testing.T) {
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top/settings/mtu"},
+			`{"mem-test:mtu": 9100}`)
+	})
+	t.Run("list_entry", func(t *testing.T) {
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top/user[name=u2]"},
+			`{"mem-test:user": [{"name": "u2", "role": "guest", "tags": ["x"]}]}`)
+	})
+	t.Run("depth", func(t *testing.T) {
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top/user", Depth: 1},
+			`{"mem-test:user": [{"name": "u1", "role": "admin"}, {"name": "u2", "role": "guest", "tags": ["x"]}]}`)
+	})
+	t.Run("root_depth", func(t *testing.T) {
+		verifyMemGet(t, b, BackendRequest{Path: "/", Depth: 1}, `{}`)
+	})
+	t.Run("fields", func(t *testing.T) {
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top", Fields: []string{"user/config/shell", "settings/mtu"}},
+			`{"mem-test:top": {"user": [{"name": "u1", "config": {"shell": "bash"}}, {"name": "u2"}], "settings": {"mtu": 9100}}}`)
+	})
+	t.Run("not_found", func(t *testing.T) {
+		_, err := b.Get(BackendRequest{Path: "/mem-test:top/user[name=u3]/role"})
+		if _, ok := err.(tlerr.NotFoundError); !ok {
+			t.Fatalf("Expected NotFoundError; found %v", err)
+		}
+	})
+}
+
+func TestMemoryBackend_write(t *testing.T) {
+	t.Run("create", func(t *testing.T) {
+		b := newTestMemoryBackend(t)
+		memWrite(t, b.Create, "/mem-test:top", `{"mem-test:user": [{"name": "u3"}], "mem-test:motd": "hi"}`)
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top", Depth: 1}, `{"mem-test:top": {"motd": "hi"}}`)
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top/user[name=u3]"}, `{"mem-test:user": [{"name": "u3"}]}`)
+	})
+	t.Run("create_list", func(t *testing.T) {
+		b := newTestMemoryBackend(t)
+		memWrite(t, b.Create, "/mem-test:top/user", `{"mem-test:user": [{"name": "u3", "role": "guest"}]}`)
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top/user[name=u3]/role"}, `{"mem-test:role": "guest"}`)
+	})
+	t.Run("create_exists", func(t *testing.T) {
+		b := newTestMemoryBackend(t)
+		_, err := b.Create(BackendRequest{Path: "/mem-test:top", Payload: []byte(`{"user": [{"name": "u3"}, {"name": "u1"}]}`)})
+		if _, ok := err.(tlerr.AlreadyExistsError); !ok {
+			t.Fatalf("Expected AlreadyExistsError; found %v", err)
+		}
+		// Failed request should not leave partial changes
+		if _, err = b.Get(BackendRequest{Path: "/mem-test:top/user[name=u3]"}); err == nil {
+			t.Fatalf("Failed create request updated the data")
+		}
+	})
+	t.Run("replace", func(t *testing.T) {
+		b := newTestMemoryBackend(t)
+		memWrite(t, b.Replace, "/mem-test:top/user[name=u1]", `{"mem-test:user": [{"name": "u1", "role": "guest"}]}`)
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top/user[name=u1]"}, `{"mem-test:user": [{"name": "u1", "role": "guest"}]}`)
+	})
+	t.Run("replace_new_entry", func(t *testing.T) {
+		b := newTestMemoryBackend(t)
+		memWrite(t, b.Replace, "/mem-test:top/user[name=u5]/config", `{"mem-test:config": {"shell": "sh"}}`)
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top/user[name=u5]"}, `{"mem-test:user": [{"name": "u5", "config": {"shell": "sh"}}]}`)
+	})
+	t.Run("replace_key_mismatch", func(t *testing.T) {
+		b := newTestMemoryBackend(t)
+		_, err := b.Replace(BackendRequest{Path: "/mem-test:top/user[name=u1]", Payload: []byte(`{"user": [{"name": "u2"}]}`)})
+		if _, ok := err.(tlerr.InvalidArgsError); !ok {
+			t.Fatalf("Expected InvalidArgsError; found %v", err)
+		}
+	})
+	t.Run("merge", func(t *testing.T) {
+		b := newTestMemoryBackend(t)
+		memWrite(t, b.Update, "/mem-test:top", `{"mem-test:top": {"settings": {"mtu": 1500},
+			"user": [{"name": "u2", "tags": ["y"]}, {"name": "u4"}]}}`)
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top/settings"}, `{"mem-test:settings": {"mtu": 1500, "timeout": 5}}`)
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top/user[name=u2]"}, `{"mem-test:user": [{"name": "u2", "role": "guest", "tags": ["x", "y"]}]}`)
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top/user[name=u4]"}, `{"mem-test:user": [{"name": "u4"}]}`)
+	})
+	t.Run("delete", func(t *testing.T) {
+		b := newTestMemoryBackend(t)
+		memWrite(t, b.Delete, "/mem-test:top/user[name=u1]", "")
+		memWrite(t, b.Delete, "/mem-test:top/settings/timeout", "")
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top", Depth: 2},
+			`{"mem-test:top": {"user": [{"name": "u2", "role": "guest", "tags": ["x"]}], "settings": {"mtu": 9100}}}`)
+		if _, err := b.Delete(BackendRequest{Path: "/mem-test:top/user[name=u1]"}); err == nil {
+			t.Fatalf("Delete of unknown entry did not fail")
+		}
+	})
+}
+
+func TestMemoryBackend_bulk(t *testing.T) {
+	b := newTestMemoryBackend(t)
+	ops := []BulkOperation{
+		{Method: "DELETE", Request: BackendRequest{Path: "/mem-test:top/user[name=u1]"}},
+		{Method: "PUT", Request: BackendRequest{Path: "/mem-test:top/user[name=u3]",
+			Payload: []byte(`{"mem-test:user": [{"name": "u3"}]}`)}},
+		{Method: "PATCH", Request: BackendRequest{Path: "/mem-test:top/settings",
+			Payload: []byte(`{"mem-test:settings": {"mtu": 1500}}`)}},
+	}
+	if index, err := b.Bulk(ops); err != nil {
+		t.Fatalf("Bulk failed at %d; %v", index, err)
+	}
+	if !ops[1].Response.Created {
+		t.Fatalf("Created not set for PUT of a new entry")
+	}
+	verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top", Depth: 2},
+		`{"mem-test:top": {"user": [{"name": "u2", "role": "guest", "tags": ["x"]}, {"name": "u3"}],
+		"settings": {"mtu": 1500, "timeout": 5}}}`)
+
+	t.Run("failed", func(t *testing.T) {
+		ops := []BulkOperation{
+			{Method: "DELETE", Request: BackendRequest{Path: "/mem-test:top/user[name=u2]"}},
+			{Method: "DELETE", Request: BackendRequest{Path: "/mem-test:top/user[name=u1]"}},
+		}
+		index, err := b.Bulk(ops)
+		if _, ok := err.(tlerr.NotFoundError); !ok || index != 1 {
+			t.Fatalf("Expected NotFoundError at 1; found %v at %d", err, index)
+		}
+		// Failed bulk request should not leave partial changes
+		verifyMemGet(t, b, BackendRequest{Path: "/mem-test:top/user[name=u2]/role"}, `{"mem-test:role": "guest"}`)
+	})
+}
+
+// TestMemoryBackend_restconf runs RESTCONF requests through the router,
+// Process function and a MemoryBackend.
+func TestMemoryBackend_restconf(t *testing.T) {
+	b := NewMemoryBackend()
+	b.SetListKeys("/top/user", "name")
+	defer useBackend("/restconf/data/mem-test:", b)()
+
+	s := NewRouterWithRoutes(RouterConfig{}, nil)
+	for _, m := range []string{"GET", "PUT", "POST", "PATCH", "DELETE"} {
+		s.AddRoutes([]RouteInfo{
+			{"top", m, "/restconf/data/mem-test:top", Process},
+			{"user", m, "/restconf/data/mem-test:top/user={name}", Process},
+			{"role", m, "/restconf/data/mem-test:top/user={name}/role", Process},
+		})
+	}
+
+	serve := func(method, path, data string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, prepareRequest(t, method, path, data))
+		return w
+	}
+
+	verifyResponse(t, serve("GET", "/mem-test:top", ""), 404)
+	verifyResponse(t, serve("PUT", "/mem-test:top", `{"mem-test:top":{"user":[{"name":"a/b","role":"admin"}]}}`), 201)
+	verifyResponse(t, serve("PUT", "/mem-test:top", `{"mem-test:top":{"user":[{"name":"a/b","role":"admin"}]}}`), 204)
+	verifyResponse(t, serve("POST", "/mem-test:top", `{"mem-test:user":[{"name":"u2"}]}`), 201)
+	verifyResponse(t, serve("POST", "/mem-test:top", `{"mem-test:user":[{"name":"u2"}]}`), 409)
+	verifyResponse(t, serve("PATCH", "/mem-test:top/user=u2/role", `{"mem-test:role":"guest"}`), 204)
+	verifyResponseData(t, serve("GET", "/mem-test:top/user=a%2Fb/role", ""), 200, jsonObj{"mem-test:role": "admin"})
+	verifyResponseData(t, serve("GET", "/mem-test:top/user=u2/role", ""), 200, jsonObj{"mem-test:role": "guest"})
+	verifyResponse(t, serve("DELETE", "/mem-test:top/user=u2", ""), 204)
+	verifyResponse(t, serve("GET", "/mem-test:top/user=u2", ""), 404)
+}
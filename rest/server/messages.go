@@ -0,0 +1,207 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Azure/sonic-mgmt-common/cvl"
+	"github.com/golang/glog"
+)
+
+// errorMessagesFile is a json file with custom error messages, which
+// override or extend the built-in message catalog.
+var errorMessagesFile string
+
+func init() {
+	flag.StringVar(&errorMessagesFile, "error_messages", errorMessagesFile,
+		"JSON file with localized or custom error messages, indexed by language and message key")
+}
+
+// defaultLanguage is the language of built-in messages. It is used
+// when none of the languages in Accept-Language header have a message.
+const defaultLanguage = "en"
+
+// Message ids of the error messages generated by the server.
+const (
+	msgEntryNotFound     = "entry-not-found"
+	msgEntryExists       = "entry-exists"
+	msgTransactionFailed = "transaction-failed"
+)
+
+// messageCatalog holds the error messages indexed by language and message
+// key. Language names are lowercase. Message keys are:
+//
+//	"app-tag:<error-app-tag>"  for errors with given error-app-tag
+//	"cvl:<CVL error code>"     for CVL errors, like "cvl:CVL_SEMANTIC_KEY_ALREADY_EXIST"
+//	"<message id>"             for messages generated by the server, like "entry-exists"
+//	"tag:<error-tag>"          for errors with given error-tag
+//
+// Keys are looked up in the same order; the first one found is used.
+// Error-tag keys are used only for the errors without a message of their
+// own, so that a generic message does not replace a detailed one.
+type messageCatalog map[string]map[string]string
+
+// builtinMessages is the default message catalog.
+var builtinMessages = messageCatalog{
+	defaultLanguage: {
+		msgEntryNotFound:     "Entry not found",
+		msgEntryExists:       "Entry already exists",
+		msgTransactionFailed: "Transaction failed. Please try again.",
+	},
+}
+
+// cvlMessageKey returns the message key for a CVL error code.
+func cvlMessageKey(code int) string {
+	if m, ok := cvlErrorMap[cvl.CVLRetCode(code)]; ok {
+		return "cvl:" + m.name
+	}
+	return "cvl:" + strconv.Itoa(code)
+}
+
+var (
+	theMessageCatalog  atomic.Value // holds messageCatalog
+	messageCatalogOnce sync.Once
+)
+
+// getMessageCatalog returns the message catalog. Custom messages from
+// error_messages file are loaded during the first call. Only the built-in
+// messages are used if the file cannot be loaded.
+func getMessageCatalog() messageCatalog {
+	messageCatalogOnce.Do(func() {
+		theMessageCatalog.Store(builtinMessages)
+		if errorMessagesFile == "" {
+			return
+		}
+
+		c, err := loadMessageCatalog(errorMessagesFile)
+		if err != nil {
+			glog.Errorf("Failed to load error messages; %v", err)
+			return
+		}
+
+		theMessageCatalog.Store(c)
+		glog.Infof("Loaded error messages from %s", errorMessagesFile)
+	})
+
+	return theMessageCatalog.Load().(messageCatalog)
+}
+
+// loadMessageCatalog reads the custom messages from a json file and
+// merges them with the built-in messages. File should contain messages
+// indexed by language and message key. Eg:
+//
+//	{
+//	  "en": {"cvl:CVL_SEMANTIC_KEY_ALREADY_EXIST": "Duplicate entry"},
+//	  "fr": {"entry-exists": "L'entrée existe déjà"}
+//	}
+func loadMessageCatalog(file string) (messageCatalog, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var custom messageCatalog
+	if err = json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("invalid messages file '%s'; %v", file, err)
+	}
+
+	c := make(messageCatalog)
+	for _, src := range []messageCatalog{builtinMessages, custom} {
+		for lang, msgs := range src {
+			lang = strings.ToLower(lang)
+			if c[lang] == nil {
+				c[lang] = make(map[string]string)
+			}
+			for k, m := range msgs {
+				c[lang][k] = m
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// localizeMessage returns the catalog message for the first matching
+// message key, in the language preferred by the client. Languages are
+// tried in the Accept-Language preference order, followed by the default
+// language. Returns msg as is if none of the keys have a message.
+func localizeMessage(r *http.Request, msg string, keys ...string) string {
+	c := getMessageCatalog()
+	for _, lang := range acceptLanguages(r) {
+		msgs := c[lang]
+		for _, k := range keys {
+			if m, ok := msgs[k]; ok {
+				return m
+			}
+		}
+	}
+	return msg
+}
+
+// acceptLanguages returns the language tags from the Accept-Language
+// header (RFC7231, section 5.3.5) in the order of preference; followed by
+// the default language. Tags are in lowercase. A tag with subtags is
+// followed by its primary language; like "fr-ca" followed by "fr".
+func acceptLanguages(r *http.Request) []string {
+	type langQ struct {
+		tag string
+		q   float64
+	}
+
+	var langs []langQ
+	if r != nil {
+		for _, v := range r.Header["Accept-Language"] {
+			for _, item := range strings.Split(v, ",") {
+				parts := strings.Split(item, ";")
+				tag := strings.ToLower(strings.TrimSpace(parts[0]))
+				q := 1.0
+				for _, p := range parts[1:] {
+					if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+						q, _ = strconv.ParseFloat(p[2:], 64)
+					}
+				}
+				if len(tag) != 0 && tag != "*" && q > 0 {
+					langs = append(langs, langQ{tag, q})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	var tags []string
+	for _, l := range langs {
+		tags = append(tags, l.tag)
+		if k := strings.IndexByte(l.tag, '-'); k > 0 {
+			tags = append(tags, l.tag[:k])
+		}
+	}
+	return append(tags, defaultLanguage)
+}
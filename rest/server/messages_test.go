@@ -0,0 +1,149 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/sonic-mgmt-common/cvl"
+	"github.com/Azure/sonic-mgmt-common/translib/tlerr"
+)
+
+// useMessageCatalog replaces current message catalog with c. Returns
+// a function to restore the original catalog.
+func useMessageCatalog(c messageCatalog) func() {
+	orig := getMessageCatalog()
+	theMessageCatalog.Store(c)
+	return func() { theMessageCatalog.Store(orig) }
+}
+
+func TestAcceptLanguages(t *testing.T) {
+	t.Run("none", testAcceptLanguages("", "en"))
+	t.Run("one", testAcceptLanguages("fr", "fr,en"))
+	t.Run("subtag", testAcceptLanguages("fr-CA", "fr-ca,fr,en"))
+	t.Run("qvalue", testAcceptLanguages("de;q=0.5, fr-CA, ja;q=0.8", "fr-ca,fr,ja,de,en"))
+	t.Run("wildcard", testAcceptLanguages("fr, *;q=0.1", "fr,en"))
+	t.Run("q0", testAcceptLanguages("fr;q=0, de", "de,en"))
+}
+
+func testAcceptLanguages(header, exp string) func(*testing.T) {
+	return func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			r.Header.Set("Accept-Language", header)
+		}
+		if langs := strings.Join(acceptLanguages(r), ","); langs != exp {
+			t.Fatalf("Expected languages '%s'; found '%s'", exp, langs)
+		}
+	}
+}
+
+func TestLocalizedErrorEntry(t *testing.T) {
+	defer useMessageCatalog(messageCatalog{
+		"en": {
+			msgEntryExists:         "Entry already exists",
+			msgEntryNotFound:       "Entry not found",
+			"app-tag:too-many-acl": "ACL limit reached",
+			"tag:in-use":           "Resource busy",
+			"tag:invalid-value":    "Invalid value",
+		},
+		"fr": {
+			msgEntryExists:                            "L'entrée existe déjà",
+			"cvl:CVL_SEMANTIC_KEY_DUPLICATE":          "Entrée en double",
+			"cvl:CVL_SEMANTIC_MANDATORY_DATA_MISSING": "Données obligatoires manquantes",
+		},
+	})()
+
+	t.Run("default", testLocalizedMessage("",
+		cvlError(cvl.CVL_SEMANTIC_KEY_ALREADY_EXIST, "hii"), "Entry already exists"))
+	t.Run("fr", testLocalizedMessage("fr-FR, en;q=0.5",
+		cvlError(cvl.CVL_SEMANTIC_KEY_ALREADY_EXIST, "hii"), "L'entrée existe déjà"))
+	t.Run("cvl_code", testLocalizedMessage("fr",
+		cvlError(cvl.CVL_SEMANTIC_KEY_DUPLICATE, "hii"), "Entrée en double"))
+	t.Run("cvl_message", testLocalizedMessage("fr",
+		cvlError(cvl.CVL_SEMANTIC_MANDATORY_DATA_MISSING, "hii"), "Données obligatoires manquantes"))
+	t.Run("fallback", testLocalizedMessage("fr",
+		tlerr.TranslibRedisClientEntryNotExist{}, "Entry not found"))
+	t.Run("unknown_lang", testLocalizedMessage("ja",
+		tlerr.InvalidArgsError{Format: "hii"}, "hii"))
+	t.Run("app_tag", testLocalizedMessage("",
+		tlerr.TranslibCVLFailure{Code: int(cvl.CVL_SEMANTIC_ERROR),
+			CVLErrorInfo: cvl.CVLErrorInfo{ConstraintErrMsg: "hii", ErrAppTag: "too-many-acl"}},
+		"ACL limit reached"))
+	t.Run("tag", testLocalizedMessage("",
+		tlerr.TranslibTransactionFail{}, "Resource busy"))
+	t.Run("tag_not_detailed", testLocalizedMessage("",
+		tlerr.InvalidArgsError{Format: "Value 10 out of range"}, "Value 10 out of range"))
+	t.Run("batch", testLocalizedMessage("fr",
+		batchError{index: 1, err: cvlError(cvl.CVL_SEMANTIC_KEY_DUPLICATE, "hii")}, "Entrée en double"))
+}
+
+func testLocalizedMessage(lang string, err error, expMsg string) func(*testing.T) {
+	return func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/", nil)
+		if lang != "" {
+			r.Header.Set("Accept-Language", lang)
+		}
+		if _, entry := toErrorEntry(err, r); entry.Message != expMsg {
+			t.Fatalf("Expected message '%s'; found '%s'", expMsg, entry.Message)
+		}
+	}
+}
+
+func TestLoadMessageCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restmsg")
+	if err != nil {
+		t.Fatalf("TempDir failed; %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "messages.json")
+	ioutil.WriteFile(file, []byte(`{
+		"en": {"entry-exists": "Duplicate entry"},
+		"FR": {"entry-not-found": "Entrée introuvable"}
+	}`), 0600)
+
+	c, err := loadMessageCatalog(file)
+	if err != nil {
+		t.Fatalf("loadMessageCatalog failed; %v", err)
+	}
+	if m := c["en"][msgEntryExists]; m != "Duplicate entry" {
+		t.Errorf("Custom message not loaded; found '%s'", m)
+	}
+	if m := c["en"][msgTransactionFailed]; m != builtinMessages["en"][msgTransactionFailed] {
+		t.Errorf("Builtin message not retained; found '%s'", m)
+	}
+	if m := c["fr"][msgEntryNotFound]; m != "Entrée introuvable" {
+		t.Errorf("Message for new language not loaded; found '%s'", m)
+	}
+	if m := builtinMessages["en"][msgEntryExists]; m != "Entry already exists" {
+		t.Errorf("Builtin messages modified; found '%s'", m)
+	}
+
+	ioutil.WriteFile(file, []byte(`{"en": "xyz"}`), 0600)
+	if _, err = loadMessageCatalog(file); err == nil {
+		t.Errorf("loadMessageCatalog did not fail for invalid file")
+	}
+}
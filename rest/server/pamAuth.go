@@ -128,6 +128,7 @@ func PAMAuthenAndAuthor(r *http.Request, rc *RequestContext) error {
 	}
 
 	glog.Infof("[%s] Authentication passed. user=%s ", rc.ID, username)
+	rc.Username = username
 
 	//Allow SET request only if user belong to admin group
 	if isWriteOperation(r) && IsAdminGroup(username) == false {
@@ -158,7 +159,13 @@ func authMiddleware(inner http.Handler) http.Handler {
 		}
 
 		rc, r := GetContext(r)
+		sp, r := startSpan(r, "PAMAuthenAndAuthor")
 		err := PAMAuthenAndAuthor(r, rc)
+		if err != nil {
+			sp.setError(err)
+		}
+		sp.finish()
+
 		if err != nil {
 			writeErrorResponse(w, r, err)
 		} else {
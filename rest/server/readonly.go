@@ -0,0 +1,298 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Read-only mode configurations
+var (
+	// readOnlyStateFile is the file in which the read-only mode state
+	// is saved, so that it is retained across server restarts.
+	readOnlyStateFile = "/var/lib/rest-server/read-only.json"
+
+	// readOnlyAllowedRPCs are the RPCs which can be invoked in read-only
+	// mode, like diagnostic RPCs which do not change the configuration.
+	readOnlyAllowedRPCs string
+)
+
+// defaultReadOnlyRetryAfter is the Retry-After value, in seconds, for the
+// write requests rejected in read-only mode; when not specified by the
+// admin while enabling the read-only mode.
+const defaultReadOnlyRetryAfter = 300
+
+// readOnlyPath is the URI of the read-only mode status resource.
+const readOnlyPath = "/restconf/data/sonic-rest-server:read-only"
+
+func init() {
+	flag.StringVar(&readOnlyStateFile, "readonly_state_file", readOnlyStateFile,
+		"File for saving the read-only mode state across restarts")
+	flag.StringVar(&readOnlyAllowedRPCs, "readonly_allowed_rpcs", readOnlyAllowedRPCs,
+		"Comma separated names of RPCs allowed in read-only mode, like 'sonic-ping:ping'")
+
+	AddRoute("getReadOnly", "GET", readOnlyPath, readOnlyStatusHandler)
+	AddRoute("setReadOnly", "POST", "/restconf/operations/sonic-rest-server:set-read-only", setReadOnlyHandler, ReadOnlyAllowed())
+}
+
+// readOnlyState is the read-only mode state. It is also the json
+// representation of the status resource and the state file.
+type readOnlyState struct {
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message,omitempty"`
+	RetryAfter int    `json:"retry-after,omitempty"` // seconds
+	User       string `json:"user,omitempty"`
+	Since      string `json:"since,omitempty"` // RFC3339 time
+}
+
+// readOnlyMode holds current read-only mode state. State is loaded
+// from the readOnlyStateFile when accessed for the first time.
+var readOnlyMode struct {
+	mu     sync.Mutex
+	loaded bool
+	state  readOnlyState
+}
+
+// getReadOnlyState returns current read-only mode state.
+func getReadOnlyState() readOnlyState {
+	readOnlyMode.mu.Lock()
+	defer readOnlyMode.mu.Unlock()
+	loadReadOnlyState()
+	return readOnlyMode.state
+}
+
+// loadReadOnlyState loads the read-only mode state from readOnlyStateFile,
+// if not loaded already. Read-only mode remains disabled if the file does
+// not exist or cannot be loaded. Should be called with readOnlyMode.mu held.
+func loadReadOnlyState() {
+	if readOnlyMode.loaded {
+		return
+	}
+
+	readOnlyMode.loaded = true
+	if readOnlyStateFile == "" {
+		return
+	}
+
+	data, err := ioutil.ReadFile(readOnlyStateFile)
+	if os.IsNotExist(err) {
+		return
+	}
+
+	var s readOnlyState
+	if err == nil {
+		err = json.Unmarshal(data, &s)
+	}
+	if err != nil {
+		glog.Errorf("Failed to load read-only mode state from %s; %v", readOnlyStateFile, err)
+		return
+	}
+
+	readOnlyMode.state = s
+	if s.Enabled {
+		glog.Infof("Read-only mode enabled by '%s' since %s", s.User, s.Since)
+	}
+}
+
+// setReadOnlyState saves the read-only mode state s in readOnlyStateFile
+// and makes it current. State is not changed if it cannot be saved.
+func setReadOnlyState(s readOnlyState) error {
+	readOnlyMode.mu.Lock()
+	defer readOnlyMode.mu.Unlock()
+	loadReadOnlyState()
+
+	if readOnlyStateFile != "" {
+		data, _ := json.MarshalIndent(&s, "", "  ")
+		if err := writeFileAtomic(readOnlyStateFile, data); err != nil {
+			glog.Errorf("Failed to save read-only mode state; %v", err)
+			return httpServerError("Failed to save read-only mode state")
+		}
+	}
+
+	readOnlyMode.state = s
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to the
+// given file name; creating the parent directory if needed.
+func writeFileAtomic(name string, data []byte) error {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// readOnlyMiddleware returns a handler which rejects the write requests
+// with 503 status while the read-only mode is enabled. Response includes
+// the admin's message and a Retry-After header. Read requests, the routes
+// registered with ReadOnlyAllowed option and the RPCs listed in
+// readonly_allowed_rpcs are always allowed.
+func readOnlyMiddleware(inner http.Handler, rr *routeRegInfo) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWriteOperation(r) || rr.readOnlyAllowed || isAllowedRPC(r) {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		s := getReadOnlyState()
+		if !s.Enabled {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		rc, r := GetContext(r)
+		requestLog(rc).Warningf("Rejecting %s request in read-only mode", r.Method)
+
+		msg := "Server is in read-only mode"
+		if s.Message != "" {
+			msg += "; " + s.Message
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(s.RetryAfter))
+		writeErrorResponse(w, r, httpError(http.StatusServiceUnavailable, "%s", msg))
+	})
+}
+
+// isAllowedRPC checks if the request is for one of the RPCs listed
+// in readonly_allowed_rpcs.
+func isAllowedRPC(r *http.Request) bool {
+	rpc := strings.TrimPrefix(requestPath(r), restconfOperPathPrefix)
+	if len(rpc) == len(requestPath(r)) || len(rpc) == 0 {
+		return false
+	}
+	for _, allowed := range strings.Split(readOnlyAllowedRPCs, ",") {
+		if strings.TrimSpace(allowed) == rpc {
+			return true
+		}
+	}
+	return false
+}
+
+// readOnlyStatusHandler serves "GET /restconf/data/sonic-rest-server:read-only"
+// requests. Returns current read-only mode state.
+func readOnlyStatusHandler(w http.ResponseWriter, r *http.Request) {
+	s := getReadOnlyState()
+	data, _ := json.Marshal(map[string]interface{}{"sonic-rest-server:read-only": &s})
+	w.Header().Set("Content-Type", mimeYangDataJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+// setReadOnlyInput is the input of the set-read-only RPC. Eg:
+//
+//	{"sonic-rest-server:input": {
+//	  "enabled": true,
+//	  "message": "Upgrade in progress",
+//	  "retry-after": 600
+//	}}
+type setReadOnlyInput struct {
+	Enabled    *bool  `json:"enabled"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry-after"`
+}
+
+// setReadOnlyHandler serves the set-read-only RPC, which enables or
+// disables the read-only mode. New state is saved in readonly_state_file
+// and returned in the RPC output. Only admin users can invoke it when
+// user authentication is enabled. Clients are not identified otherwise
+// (client_auth is not "user"), hence any client can invoke it - just
+// like any other write request.
+func setReadOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	rc, r := GetContext(r)
+	log := requestLog(rc)
+
+	if config := getRouterConfig(r); config != nil && config.AuthEnable && !IsAdminGroup(rc.Username) {
+		log.Warningf("Not an admin; cannot change read-only mode")
+		writeErrorResponse(w, r, httpError(http.StatusForbidden, "Not an admin user"))
+		return
+	}
+
+	input, err := parseSetReadOnlyInput(r)
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	s := readOnlyState{Enabled: *input.Enabled}
+	if s.Enabled {
+		s.Message = input.Message
+		s.RetryAfter = input.RetryAfter
+		s.User = rc.Username
+		s.Since = time.Now().UTC().Format(time.RFC3339)
+		if s.RetryAfter == 0 {
+			s.RetryAfter = defaultReadOnlyRetryAfter
+		}
+	}
+
+	if err = setReadOnlyState(s); err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	log.Infof("Read-only mode set to %v by user '%s'", s.Enabled, rc.Username)
+	data, _ := json.Marshal(map[string]interface{}{"sonic-rest-server:output": &s})
+	w.Header().Set("Content-Type", mimeYangDataJSON)
+	w.Write(data)
+}
+
+// parseSetReadOnlyInput reads the set-read-only RPC input from request body.
+func parseSetReadOnlyInput(r *http.Request) (*setReadOnlyInput, error) {
+	var input setReadOnlyInput
+	if err := parseServerRPCInput(r, &input); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case input.Enabled == nil:
+		return nil, httpBadRequest("Missing 'enabled' value")
+	case input.RetryAfter < 0:
+		return nil, httpBadRequest("Invalid 'retry-after' value %d", input.RetryAfter)
+	}
+
+	return &input, nil
+}
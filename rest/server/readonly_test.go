@@ -0,0 +1,185 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useReadOnlyStateFile uses the given file for saving the read-only mode
+// state and clears the current state. Returns a function to restore them.
+func useReadOnlyStateFile(file string) func() {
+	readOnlyMode.mu.Lock()
+	origFile, origLoaded, origState := readOnlyStateFile, readOnlyMode.loaded, readOnlyMode.state
+	readOnlyStateFile, readOnlyMode.loaded, readOnlyMode.state = file, false, readOnlyState{}
+	readOnlyMode.mu.Unlock()
+
+	return func() {
+		readOnlyMode.mu.Lock()
+		readOnlyStateFile, readOnlyMode.loaded, readOnlyMode.state = origFile, origLoaded, origState
+		readOnlyMode.mu.Unlock()
+	}
+}
+
+func newReadOnlyTestRouter() *Router {
+	s := newEmptyRouter()
+	s.addRoute("getReadOnly", "GET", readOnlyPath, readOnlyStatusHandler)
+	s.addRoute("setReadOnly", "POST", "/restconf/operations/sonic-rest-server:set-read-only", setReadOnlyHandler, ReadOnlyAllowed())
+	s.addRoute("top", "GET", "/restconf/data/readonly-test:top", Process)
+	s.addRoute("top", "PATCH", "/restconf/data/readonly-test:top", Process)
+	s.addRoute("top", "DELETE", "/restconf/data/readonly-test:top", Process)
+	s.addRoute("ping", "POST", "/restconf/operations/readonly-test:ping", Process)
+	s.addRoute("reboot", "POST", "/restconf/operations/readonly-test:reboot", Process)
+	return s
+}
+
+// setTestReadOnly invokes the set-read-only RPC with given input.
+func setTestReadOnly(t *testing.T, s *Router, input string, expStatus int) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "POST", "/restconf/operations/sonic-rest-server:set-read-only",
+		`{"sonic-rest-server:input": `+input+`}`))
+	verifyResponse(t, w, expStatus)
+}
+
+// getTestReadOnly fetches the read-only mode status resource.
+func getTestReadOnly(t *testing.T, s *Router) readOnlyState {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "GET", readOnlyPath, ""))
+	verifyResponse(t, w, 200)
+
+	var resp map[string]readOnlyState
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid status response; %v\n%s", err, w.Body.String())
+	}
+	return resp["sonic-rest-server:read-only"]
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restro")
+	if err != nil {
+		t.Fatalf("TempDir failed; %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer useReadOnlyStateFile(filepath.Join(dir, "state", "read-only.json"))()
+	defer useBackend("/restconf/data/readonly-test:", &recordingBackend{})()
+	defer useBackend("/restconf/operations/readonly-test:", &recordingBackend{})()
+	defer func(v string) { readOnlyAllowedRPCs = v }(readOnlyAllowedRPCs)
+	readOnlyAllowedRPCs = "x:y, readonly-test:ping"
+	s := newReadOnlyTestRouter()
+
+	if st := getTestReadOnly(t, s); st.Enabled {
+		t.Fatalf("Read-only mode enabled by default")
+	}
+
+	t.Run("write_allowed", testReadOnlyRequest(s, "PATCH", "/readonly-test:top", 204, ""))
+	setTestReadOnly(t, s, `{"enabled": true, "message": "Upgrade in progress", "retry-after": 60}`, 200)
+
+	st := getTestReadOnly(t, s)
+	if !st.Enabled || st.Message != "Upgrade in progress" || st.RetryAfter != 60 || st.Since == "" {
+		t.Fatalf("Unexpected status %+v", st)
+	}
+
+	t.Run("get", testReadOnlyRequest(s, "GET", "/readonly-test:top", 200, ""))
+	t.Run("patch", testReadOnlyRequest(s, "PATCH", "/readonly-test:top", 503, "60"))
+	t.Run("delete", testReadOnlyRequest(s, "DELETE", "/readonly-test:top", 503, "60"))
+	t.Run("rpc", testReadOnlyRequest(s, "POST", "/restconf/operations/readonly-test:reboot", 503, "60"))
+	t.Run("allowed_rpc", testReadOnlyRequest(s, "POST", "/restconf/operations/readonly-test:ping", 204, ""))
+
+	// State should be loaded from the file after restart
+	readOnlyMode.mu.Lock()
+	readOnlyMode.loaded, readOnlyMode.state = false, readOnlyState{}
+	readOnlyMode.mu.Unlock()
+	if st = getReadOnlyState(); !st.Enabled || st.Message != "Upgrade in progress" {
+		t.Fatalf("State not restored from file; found %+v", st)
+	}
+
+	setTestReadOnly(t, s, `{"enabled": false}`, 200)
+	if st = getTestReadOnly(t, s); st.Enabled || st.Message != "" {
+		t.Fatalf("Unexpected status %+v", st)
+	}
+	t.Run("write_after_disable", testReadOnlyRequest(s, "PATCH", "/readonly-test:top", 204, ""))
+}
+
+func TestReadOnlyMode_defaultRetryAfter(t *testing.T) {
+	defer useReadOnlyStateFile("")()
+	defer useBackend("/restconf/data/readonly-test:", &recordingBackend{})()
+	s := newReadOnlyTestRouter()
+
+	setTestReadOnly(t, s, `{"enabled": true}`, 200)
+	testReadOnlyRequest(s, "PATCH", "/readonly-test:top", 503, "300")(t)
+}
+
+func TestReadOnlyMode_badInput(t *testing.T) {
+	defer useReadOnlyStateFile("")()
+	s := newReadOnlyTestRouter()
+
+	t.Run("no_enabled", func(t *testing.T) { setTestReadOnly(t, s, `{"message": "x"}`, 400) })
+	t.Run("unknown", func(t *testing.T) { setTestReadOnly(t, s, `{"enabled": true, "x": 1}`, 400) })
+	t.Run("retry_after", func(t *testing.T) { setTestReadOnly(t, s, `{"enabled": true, "retry-after": -1}`, 400) })
+	if getReadOnlyState().Enabled {
+		t.Fatalf("Read-only mode enabled by invalid input")
+	}
+}
+
+func TestReadOnlyMode_saveError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restro")
+	if err != nil {
+		t.Fatalf("TempDir failed; %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Parent path is a file; hence the state cannot be saved
+	parent := filepath.Join(dir, "x")
+	ioutil.WriteFile(parent, nil, 0600)
+	defer useReadOnlyStateFile(filepath.Join(parent, "read-only.json"))()
+	s := newReadOnlyTestRouter()
+
+	setTestReadOnly(t, s, `{"enabled": true}`, 500)
+	if getReadOnlyState().Enabled {
+		t.Fatalf("Read-only mode enabled without saving the state")
+	}
+}
+
+func testReadOnlyRequest(s *Router, method, path string, expStatus int, expRetryAfter string) func(*testing.T) {
+	return func(t *testing.T) {
+		data := ""
+		if method == "PATCH" {
+			data = `{"readonly-test:top": {}}`
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, prepareRequest(t, method, path, data))
+		verifyResponse(t, w, expStatus)
+		if ra := w.Header().Get("Retry-After"); ra != expRetryAfter {
+			t.Fatalf("Expected Retry-After '%s'; found '%s'", expRetryAfter, ra)
+		}
+		if expStatus == 503 && !strings.Contains(w.Body.String(), "read-only mode") {
+			t.Fatalf("Unexpected error response: %s", w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,546 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Azure/sonic-mgmt-common/translib/tlerr"
+	"github.com/golang/glog"
+	"github.com/openconfig/goyang/pkg/yang"
+)
+
+// redactPathsFile is a text file with schema paths of sensitive data
+// nodes, one path per line. YANG files from yang_dir are also scanned
+// for sensitive data nodes; nodes marked with nacm:default-deny-all
+// extension are considered as sensitive.
+var redactPathsFile string
+
+func init() {
+	flag.StringVar(&redactPathsFile, "redact_paths", "",
+		"File with schema paths of sensitive data nodes, to be masked in logs")
+}
+
+// sensitiveNameExpr matches data node names that hold sensitive
+// values like passwords and keys. Names like "community" are not
+// included, since they are mostly used for non-sensitive data (like
+// BGP communities); such nodes should be listed in redact_paths file
+// or annotated in YANG.
+var sensitiveNameExpr = regexp.MustCompile(
+	`(?i)(password|passwd|passphrase|secret|(^|[-_])(key|psk)$)`)
+
+// redactedValue is the replacement for sensitive values in logs.
+const redactedValue = "****"
+
+// redactRules identifies sensitive data nodes, whose values should not
+// appear in logs or traces. Data nodes are identified by schema paths
+// made of node names without module prefixes and list keys -- like
+// "/system/aaa/server-groups/server-group/servers/server/tacacs/config/secret-key".
+//
+// A node is sensitive if its schema path is listed in the rules, or if
+// it is a leaf/leaf-list whose name matches sensitiveNameExpr. Entire
+// subtree is masked if the schema path of a container or list is listed.
+type redactRules struct {
+	// paths are the schema paths of sensitive nodes, loaded from the
+	// redact_paths file and YANG annotations.
+	paths map[string]bool
+
+	// listKeys are key leaf names of all YANG lists, indexed by list's
+	// schema path. Used to identify the key values in REST URIs.
+	listKeys map[string][]string
+}
+
+var (
+	theRedactRules atomic.Value // holds *redactRules
+	redactInitOnce sync.Once
+)
+
+func newRedactRules() *redactRules {
+	return &redactRules{
+		paths:    make(map[string]bool),
+		listKeys: make(map[string][]string),
+	}
+}
+
+// getRedactRules returns current redactRules. Rules from the redact_paths
+// file are loaded during the first call. YANG annotations are loaded in
+// background; only the name and file based rules are effective till then.
+func getRedactRules() *redactRules {
+	redactInitOnce.Do(initRedactRules)
+	return theRedactRules.Load().(*redactRules)
+}
+
+func initRedactRules() {
+	rules := newRedactRules()
+	if redactPathsFile != "" {
+		if err := rules.loadPathsFile(redactPathsFile); err != nil {
+			glog.Errorf("Failed to load redact paths; %v", err)
+		}
+	}
+
+	theRedactRules.Store(rules)
+
+	if yangDir != "" {
+		go func() {
+			yangRules, err := loadYangRedactRules(yangDir)
+			if err != nil {
+				glog.Errorf("Failed to load redaction rules from YANG; %v", err)
+				return
+			}
+
+			for p := range rules.paths {
+				yangRules.paths[p] = true
+			}
+
+			theRedactRules.Store(yangRules)
+			glog.Infof("Loaded %d sensitive data paths", len(yangRules.paths))
+		}()
+	}
+}
+
+// loadPathsFile reads schema paths from a text file into the rules.
+// Empty lines and lines starting with '#' are ignored. Module prefixes
+// and list keys are allowed in the paths; they will be ignored.
+func (rr *redactRules) loadPathsFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		var p string
+		for _, elem := range splitTranslibPath(line) {
+			name, _ := splitElemKeys(elem)
+			p += "/" + localName(name)
+		}
+
+		rr.paths[p] = true
+	}
+
+	return scanner.Err()
+}
+
+// loadYangRedactRules scans all YANG files in a directory and creates
+// redactRules for data nodes annotated with nacm:default-deny-all
+// extension (RFC 8341). Also records the key names of all lists.
+func loadYangRedactRules(dir string) (rules *redactRules, err error) {
+	ms, err := getYangModules(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while processing YANG; %v", r)
+		}
+	}()
+
+	rules = newRedactRules()
+	for _, m := range ms.Modules {
+		for _, e := range yang.ToEntry(m).Dir {
+			rules.addYangEntry(e, "")
+		}
+	}
+
+	return rules, nil
+}
+
+// addYangEntry records the redaction rules for a YANG entry and its
+// descendents. Parent is the schema path of the parent data node.
+func (rr *redactRules) addYangEntry(e *yang.Entry, parent string) {
+	p := parent
+	if !e.IsChoice() && !e.IsCase() {
+		p += "/" + e.Name
+	}
+
+	for _, ext := range e.Exts {
+		if strings.HasSuffix(ext.Keyword, ":default-deny-all") {
+			rr.paths[p] = true
+		}
+	}
+
+	if e.IsList() && len(e.Key) != 0 {
+		rr.listKeys[p] = strings.Fields(e.Key)
+	}
+
+	if e.RPC != nil {
+		if e.RPC.Input != nil {
+			rr.addYangEntry(e.RPC.Input, p)
+		}
+		if e.RPC.Output != nil {
+			rr.addYangEntry(e.RPC.Output, p)
+		}
+	}
+
+	for _, child := range e.Dir {
+		rr.addYangEntry(child, p)
+	}
+}
+
+// isSensitivePath checks if the data node at translib path or
+// instance identifier path is sensitive, or is a descendent of
+// a sensitive node.
+func (rr *redactRules) isSensitivePath(path string) bool {
+	var p string
+	for _, elem := range splitTranslibPath(path) {
+		name, _ := splitElemKeys(elem)
+		p += "/" + localName(name)
+		if rr.paths[p] {
+			return true
+		}
+	}
+	return len(p) != 0 && rr.isSensitive(p, true)
+}
+
+// isSensitive checks if the data node at schema path p is sensitive.
+// Name based matching is done only for leaf nodes (isLeaf=true).
+func (rr *redactRules) isSensitive(p string, isLeaf bool) bool {
+	if rr.paths[p] {
+		return true
+	}
+	return isLeaf && sensitiveNameExpr.MatchString(p[strings.LastIndexByte(p, '/')+1:])
+}
+
+// isSensitiveKey checks if the value of key leaf k of the list at
+// schema path listPath is sensitive.
+func (rr *redactRules) isSensitiveKey(listPath, k string) bool {
+	return rr.paths[listPath] || rr.isSensitive(listPath+"/"+k, true)
+}
+
+// redactPayload returns a copy of the json payload with values of
+// sensitive nodes masked, for logging. Parent is the schema path of
+// the node that contains the top level payload nodes; see
+// payloadParentPath(). Non-json data is returned as is.
+func redactPayload(data []byte, parent string) []byte {
+	var v interface{}
+	if len(data) == 0 || json.Unmarshal(data, &v) != nil {
+		return data
+	}
+
+	if !getRedactRules().redactValue(v, parent) {
+		return data
+	}
+
+	newData, err := json.Marshal(v)
+	if err != nil {
+		return []byte(redactedValue)
+	}
+	return newData
+}
+
+// redactErrorPayload returns a copy of a RESTCONF error response payload,
+// for logging. Sensitive key values in the error-path are masked. The
+// error-message and error-info are masked if the error-path refers to a
+// sensitive data node or has sensitive key values, since they can include
+// the data values.
+func redactErrorPayload(data []byte) []byte {
+	var resp errorResponse
+	if json.Unmarshal(data, &resp) != nil || len(resp.Err.Arr) == 0 {
+		return data
+	}
+
+	rules := getRedactRules()
+	masked := false
+	for i := range resp.Err.Arr {
+		e := &resp.Err.Arr[i]
+		if len(e.Path) == 0 {
+			continue
+		}
+		if p := redactTranslibPath(e.Path); p != e.Path || rules.isSensitivePath(e.Path) {
+			e.Path = p
+			e.Message = redactedValue
+			e.ErrInfo = redactedValue
+			masked = true
+		}
+	}
+
+	if !masked {
+		return data
+	}
+	newData, err := json.Marshal(&resp)
+	if err != nil {
+		return []byte(redactedValue)
+	}
+	return newData
+}
+
+// redactError returns an error for logs and traces, which describes the
+// data validation error err only by its error path and constraint.
+// Messages of these errors can include the data values, which may be
+// sensitive. Other errors are returned as is.
+func redactError(err error) error {
+	switch e := err.(type) {
+	case MultiError:
+		var errs MultiError
+		for _, x := range e {
+			errs = append(errs, redactError(x))
+		}
+		return errs
+	case payloadError:
+		if len(e.Info.Constraint) != 0 {
+			return fmt.Errorf("%s constraint failed at %s", e.Info.Constraint, redactTranslibPath(e.Path))
+		}
+		return fmt.Errorf("invalid data at %s", redactTranslibPath(e.Path))
+	case tlerr.InvalidArgsError:
+		return fmt.Errorf("invalid data at %s", redactTranslibPath(e.Path))
+	case batchError:
+		return batchError{index: e.index, err: redactError(e.err)}
+	}
+	return err
+}
+
+// redactValue masks sensitive values in a json value tree. Parent is
+// the schema path of v. Returns true if anything was masked.
+func (rr *redactRules) redactValue(v interface{}, parent string) bool {
+	masked := false
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, child := range x {
+			p := parent + "/" + localName(k)
+			if rr.isSensitive(p, !isContainerValue(child)) {
+				x[k] = redactedValue
+				masked = true
+			} else if rr.redactValue(child, p) {
+				masked = true
+			}
+		}
+	case []interface{}:
+		for _, child := range x {
+			if rr.redactValue(child, parent) {
+				masked = true
+			}
+		}
+	}
+
+	return masked
+}
+
+// isContainerValue checks if a json value is an object or an array
+// of objects.
+func isContainerValue(v interface{}) bool {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		return true
+	case []interface{}:
+		return len(x) != 0 && isContainerValue(x[0])
+	}
+	return false
+}
+
+// localName removes the module prefix from a yang node name.
+func localName(name string) string {
+	for i := len(name) - 1; i >= 0; i-- {
+		if name[i] == ':' {
+			return name[i+1:]
+		}
+	}
+	return name
+}
+
+// payloadParentPath returns the schema path of the node which contains
+// the top level nodes of request and response payloads of request r.
+// It is the parent of the target resource for most of the requests;
+// but the target itself for POST and operations requests.
+func payloadParentPath(r *http.Request) string {
+	// Extra "/" suffix allows matching the "/restconf/data/" prefix
+	// for datastore root path also.
+	var p, last string
+	for _, elem := range strings.Split(trimRestconfPrefix(requestPath(r)+"/"), "/") {
+		if k := strings.IndexByte(elem, '='); k >= 0 {
+			elem = elem[:k]
+		}
+		if len(elem) != 0 {
+			p, last = p+last, "/"+localName(elem)
+		}
+	}
+
+	if r.Method == "POST" || isOperationsRequest(r) {
+		return p + last
+	}
+	return p
+}
+
+// redactURLPath masks the sensitive list key values in a RESTCONF URI
+// path. Key names are identified from YANG; for lists which are not
+// known, all key values are masked if list name matches sensitiveNameExpr.
+func redactURLPath(path string) string {
+	rules := getRedactRules()
+	rel := trimRestconfPrefix(path)
+	elems := strings.Split(rel, "/")
+	var p string
+	masked := false
+
+	for i, elem := range elems {
+		k := strings.IndexByte(elem, '=')
+		if len(elem) == 0 {
+			continue
+		} else if k < 0 {
+			p += "/" + localName(elem)
+			continue
+		}
+
+		p += "/" + localName(elem[:k])
+		values := strings.Split(elem[k+1:], ",")
+		keys := rules.listKeys[p]
+		for j := range values {
+			var sensitive bool
+			if j < len(keys) {
+				sensitive = rules.isSensitiveKey(p, keys[j])
+			} else {
+				sensitive = rules.isSensitive(p, true)
+			}
+			if sensitive {
+				values[j] = redactedValue
+				masked = true
+			}
+		}
+
+		elems[i] = elem[:k+1] + strings.Join(values, ",")
+	}
+
+	if !masked {
+		return path
+	}
+	return path[:len(path)-len(rel)] + strings.Join(elems, "/")
+}
+
+// redactRequestURI returns the request URI of r with sensitive list key
+// values masked.
+func redactRequestURI(r *http.Request) string {
+	uri := redactURLPath(requestPath(r))
+	if len(r.URL.RawQuery) != 0 {
+		uri += "?" + r.URL.RawQuery
+	}
+	return uri
+}
+
+// redactTranslibPath masks the sensitive list key values in a gNMI
+// style translib path -- like "/openconfig-acl:acl/acl-sets/acl-set[name=X][type=Y]".
+func redactTranslibPath(path string) string {
+	rules := getRedactRules()
+	elems := splitTranslibPath(path)
+	var p string
+	masked := false
+
+	for i, elem := range elems {
+		name, keys := splitElemKeys(elem)
+		p += "/" + localName(name)
+		if len(keys) == 0 {
+			continue
+		}
+
+		var buf strings.Builder
+		buf.WriteString(name)
+		for _, kv := range keys {
+			k := kv[:strings.IndexByte(kv, '=')]
+			if rules.isSensitiveKey(p, k) {
+				kv = k + "=" + redactedValue
+				masked = true
+			}
+			buf.WriteString("[" + kv + "]")
+		}
+
+		elems[i] = buf.String()
+	}
+
+	if !masked {
+		return path
+	}
+	return "/" + strings.Join(elems, "/")
+}
+
+// splitTranslibPath splits a gNMI style path into elements. Slashes
+// within the key predicates are not treated as separators.
+func splitTranslibPath(path string) []string {
+	var elems []string
+	var inKey, escaped bool
+	start := 0
+
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '[':
+			inKey = true
+		case c == ']':
+			inKey = false
+		case c == '/' && !inKey:
+			if i > start {
+				elems = append(elems, path[start:i])
+			}
+			start = i + 1
+		}
+	}
+
+	if start < len(path) {
+		elems = append(elems, path[start:])
+	}
+
+	return elems
+}
+
+// splitElemKeys splits a gNMI style path element into node name and
+// "key=value" predicates. Key values remain escaped.
+func splitElemKeys(elem string) (string, []string) {
+	k := strings.IndexByte(elem, '[')
+	if k < 0 {
+		return elem, nil
+	}
+
+	name := elem[:k]
+	var keys []string
+	escaped := false
+	start := k + 1
+
+	for i := start; i < len(elem); i++ {
+		switch c := elem[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '[':
+			start = i + 1
+		case c == ']':
+			if kv := elem[start:i]; strings.IndexByte(kv, '=') > 0 {
+				keys = append(keys, kv)
+			}
+		}
+	}
+
+	return name, keys
+}
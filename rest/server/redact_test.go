@@ -0,0 +1,316 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useRedactRules replaces current redactRules with rr. Returns a
+// function to restore the original rules.
+func useRedactRules(rr *redactRules) func() {
+	orig := getRedactRules()
+	theRedactRules.Store(rr)
+	return func() { theRedactRules.Store(orig) }
+}
+
+// newTestRedactRules creates redactRules with given paths and the list
+// keys of "/test/users/user" and "/test/servers/server" lists.
+func newTestRedactRules(paths ...string) *redactRules {
+	rr := newRedactRules()
+	for _, p := range paths {
+		rr.paths[p] = true
+	}
+	rr.listKeys["/test/users/user"] = []string{"name"}
+	rr.listKeys["/test/servers/server"] = []string{"address", "shared-secret"}
+	return rr
+}
+
+func TestRedactPayload(t *testing.T) {
+	defer useRedactRules(newRedactRules())()
+	t.Run("password", testRedact("",
+		`{"user":{"name":"admin","password":"secret123"}}`,
+		`{"user":{"name":"admin","password":"****"}}`))
+	t.Run("prefixed", testRedact("",
+		`{"openconfig-system:config":{"secret-key":"abc","timeout":5}}`,
+		`{"openconfig-system:config":{"secret-key":"****","timeout":5}}`))
+	t.Run("list", testRedact("",
+		`{"server":[{"address":"1.1.1.1","key":"k1"},{"address":"2.2.2.2","auth-key":"k2"}]}`,
+		`{"server":[{"address":"1.1.1.1","key":"****"},{"address":"2.2.2.2","auth-key":"****"}]}`))
+	t.Run("community", testRedact("",
+		`{"community-set":[{"community-set-name":"cs1","community-member":["100:1"]}]}`,
+		`{"community-set":[{"community-set-name":"cs1","community-member":["100:1"]}]}`))
+	t.Run("nothing", testRedact("",
+		`{"name":"Ethernet0","mtu":9100,"keys":["a"]}`,
+		`{"name":"Ethernet0","mtu":9100,"keys":["a"]}`))
+	t.Run("not_json", testRedact("", "password=abc", "password=abc"))
+	t.Run("empty", testRedact("", "", ""))
+}
+
+func TestRedactPayload_paths(t *testing.T) {
+	defer useRedactRules(newTestRedactRules("/test/users/user/token", "/test/radius",
+		"/test/snmp/community/community-name"))()
+	t.Run("leaf", testRedact("/test/users",
+		`{"test:user":[{"name":"u1","token":"t1","role":"admin"}]}`,
+		`{"test:user":[{"name":"u1","role":"admin","token":"****"}]}`))
+	t.Run("leaf_parent", testRedact("",
+		`{"test:test":{"users":{"user":[{"name":"u1","token":"t1"}]}}}`,
+		`{"test:test":{"users":{"user":[{"name":"u1","token":"****"}]}}}`))
+	t.Run("other_path", testRedact("/test/groups",
+		`{"test:user":[{"name":"u1","token":"t1"}]}`,
+		`{"test:user":[{"name":"u1","token":"t1"}]}`))
+	t.Run("container", testRedact("/test",
+		`{"test:radius":{"server":"1.1.1.1","timeout":5},"test:mtu":9100}`,
+		`{"test:mtu":9100,"test:radius":"****"}`))
+	t.Run("community", testRedact("/test/snmp",
+		`{"test:community":[{"index":"c1","community-name":"public"}]}`,
+		`{"test:community":[{"community-name":"****","index":"c1"}]}`))
+	t.Run("name_rule", testRedact("/test/users",
+		`{"test:user":[{"name":"u1","password":"p1"}]}`,
+		`{"test:user":[{"name":"u1","password":"****"}]}`))
+}
+
+func testRedact(parent, input, exp string) func(*testing.T) {
+	return func(t *testing.T) {
+		out := string(redactPayload([]byte(input), parent))
+		if out != exp {
+			t.Fatalf("Redaction failed for %s\nexpected: %s\nfound:    %s", input, exp, out)
+		}
+	}
+}
+
+func TestPayloadParentPath(t *testing.T) {
+	t.Run("get_leaf", testPayloadParent("GET",
+		"/restconf/data/test:test/users/user=u1/config/token", "/test/users/user/config"))
+	t.Run("get_list", testPayloadParent("GET",
+		"/restconf/data/test:test/users/user=u1", "/test/users"))
+	t.Run("put", testPayloadParent("PUT",
+		"/restconf/data/test:test/users", "/test"))
+	t.Run("post", testPayloadParent("POST",
+		"/restconf/data/test:test/users", "/test/users"))
+	t.Run("rpc", testPayloadParent("POST",
+		"/restconf/operations/test:reset-password", "/reset-password"))
+	t.Run("top", testPayloadParent("GET",
+		"/restconf/data/test:test", ""))
+	t.Run("root", testPayloadParent("GET",
+		"/restconf/data", ""))
+}
+
+func testPayloadParent(method, path, exp string) func(*testing.T) {
+	return func(t *testing.T) {
+		r := httptest.NewRequest(method, path, nil)
+		if p := payloadParentPath(r); p != exp {
+			t.Fatalf("Expected parent path '%s'; found '%s'", exp, p)
+		}
+	}
+}
+
+func TestRedactURLPath(t *testing.T) {
+	defer useRedactRules(newTestRedactRules("/test/tokens/token"))()
+	t.Run("no_keys", testRedactURL(
+		"/restconf/data/test:test/users",
+		"/restconf/data/test:test/users"))
+	t.Run("plain_key", testRedactURL(
+		"/restconf/data/test:test/users/user=admin/password",
+		"/restconf/data/test:test/users/user=admin/password"))
+	t.Run("yang_key", testRedactURL(
+		"/restconf/data/test:test/servers/server=1.1.1.1,xyz%2C123/timeout",
+		"/restconf/data/test:test/servers/server=1.1.1.1,****/timeout"))
+	t.Run("list_rule", testRedactURL(
+		"/restconf/data/test:test/tokens/token=abc",
+		"/restconf/data/test:test/tokens/token=****"))
+	t.Run("list_name", testRedactURL(
+		"/restconf/data/test:test/secrets=public,x",
+		"/restconf/data/test:test/secrets=****,****"))
+	t.Run("rpc", testRedactURL(
+		"/restconf/operations/test:clear",
+		"/restconf/operations/test:clear"))
+}
+
+func testRedactURL(path, exp string) func(*testing.T) {
+	return func(t *testing.T) {
+		if p := redactURLPath(path); p != exp {
+			t.Fatalf("Redaction failed for %s\nexpected: %s\nfound:    %s", path, exp, p)
+		}
+	}
+}
+
+func TestRedactTranslibPath(t *testing.T) {
+	defer useRedactRules(newTestRedactRules("/test/tokens/token"))()
+	t.Run("no_keys", testRedactTranslib(
+		"/test:test/users",
+		"/test:test/users"))
+	t.Run("plain_key", testRedactTranslib(
+		"/test:test/users/user[name=admin]/password",
+		"/test:test/users/user[name=admin]/password"))
+	t.Run("key_name", testRedactTranslib(
+		"/test:test/servers/server[address=1.1.1.1][shared-secret=a/b\\]c]/timeout",
+		"/test:test/servers/server[address=1.1.1.1][shared-secret=****]/timeout"))
+	t.Run("list_rule", testRedactTranslib(
+		"/test:test/tokens/token[id=abc]",
+		"/test:test/tokens/token[id=****]"))
+}
+
+func testRedactTranslib(path, exp string) func(*testing.T) {
+	return func(t *testing.T) {
+		if p := redactTranslibPath(path); p != exp {
+			t.Fatalf("Redaction failed for %s\nexpected: %s\nfound:    %s", path, exp, p)
+		}
+	}
+}
+
+func TestRedactErrorPayload(t *testing.T) {
+	defer useRedactRules(newTestRedactRules())()
+	data := string(redactErrorPayload([]byte(`{"ietf-restconf:errors":{"error":[` +
+		`{"error-type":"protocol","error-tag":"invalid-value","error-path":"/test:test/users/user[name='u1']/password",` +
+		`"error-message":"Invalid value 'p1' for 'password'","error-info":{"validation-error":{"field":"password","value":"p1"}}},` +
+		`{"error-type":"protocol","error-tag":"invalid-value","error-path":"/test:test/users/user[name='u1']/mtu",` +
+		`"error-message":"Invalid value 'x' for 'mtu'"}]}}`)))
+
+	if strings.Contains(data, "p1") {
+		t.Fatalf("Sensitive value not masked: %s", data)
+	}
+	if !strings.Contains(data, `"error-message":"****"`) || !strings.Contains(data, "Invalid value 'x' for 'mtu'") {
+		t.Fatalf("Unexpected redacted response: %s", data)
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	defer useRedactRules(newTestRedactRules())()
+	err := MultiError{
+		invalidNodeError("/test:test/users/user[name=u1]/password", "Invalid value '%v' for '%s'", "p1", "password"),
+		payloadError{Path: "/test:test/users/user[name=u1]/token", Message: "Invalid value 'p2'",
+			Info: payloadErrorInfo{Field: "token", Constraint: "length", Value: "p2"}},
+		batchError{index: 1, err: invalidNodeError("/test:test/users/user[name=u2]", "Key 'name' value '%v' mismatch", "p3")},
+	}
+
+	msg := redactError(err).Error()
+	exp := "invalid data at /test:test/users/user[name=u1]/password; " +
+		"length constraint failed at /test:test/users/user[name=u1]/token; " +
+		"invalid data at /test:test/users/user[name=u2]"
+	if msg != exp {
+		t.Fatalf("Unexpected redacted error\nexpected: %s\nfound:    %s", exp, msg)
+	}
+}
+
+func TestRedactPathsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restredact")
+	if err != nil {
+		t.Fatalf("TempDir failed; %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "paths.txt")
+	ioutil.WriteFile(filename, []byte(`
+# Sensitive nodes
+/test:test/users/user[name=*]/token
+/test:test/radius
+`), 0600)
+
+	rr := newRedactRules()
+	if err = rr.loadPathsFile(filename); err != nil {
+		t.Fatalf("loadPathsFile failed; %v", err)
+	}
+
+	exp := map[string]bool{"/test/users/user/token": true, "/test/radius": true}
+	if !reflect.DeepEqual(rr.paths, exp) {
+		t.Fatalf("Expected paths %v; found %v", exp, rr.paths)
+	}
+}
+
+func TestRedactYang(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restredact")
+	if err != nil {
+		t.Fatalf("TempDir failed; %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "redact-test.yang"), []byte(`
+module redact-test {
+	namespace "http://example.com/redact-test";
+	prefix rt;
+
+	extension default-deny-all;
+
+	container test {
+		list server {
+			key "address port";
+			leaf address { type string; }
+			leaf port { type uint16; }
+			leaf token {
+				rt:default-deny-all;
+				type string;
+			}
+			choice auth {
+				case tls {
+					container tls-keys {
+						rt:default-deny-all;
+						leaf cert { type string; }
+					}
+				}
+			}
+		}
+	}
+
+	rpc login {
+		input {
+			leaf user { type string; }
+			leaf otp {
+				rt:default-deny-all;
+				type string;
+			}
+		}
+	}
+}`), 0600)
+
+	rr, err := loadYangRedactRules(dir)
+	if err != nil {
+		t.Fatalf("loadYangRedactRules failed; %v", err)
+	}
+
+	expPaths := map[string]bool{
+		"/test/server/token":    true,
+		"/test/server/tls-keys": true,
+		"/login/input/otp":      true,
+	}
+	if !reflect.DeepEqual(rr.paths, expPaths) {
+		t.Fatalf("Expected paths %v; found %v", expPaths, rr.paths)
+	}
+
+	expKeys := map[string][]string{"/test/server": {"address", "port"}}
+	if !reflect.DeepEqual(rr.listKeys, expKeys) {
+		t.Fatalf("Expected list keys %v; found %v", expKeys, rr.listKeys)
+	}
+
+	// YANG schema should be created from the same modules
+	ms, _ := getYangModules(dir)
+	if s, err := loadYangSchema(dir); err != nil || s.modules["redact-test"] == nil {
+		t.Fatalf("loadYangSchema failed; %v", err)
+	}
+	if ms2, _ := getYangModules(dir + "/"); ms2 != ms {
+		t.Fatalf("YANG modules loaded again")
+	}
+}
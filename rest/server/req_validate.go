@@ -20,10 +20,16 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/golang/glog"
+	"github.com/openconfig/goyang/pkg/yang"
 	"gopkg.in/go-playground/validator.v9"
 )
 
@@ -54,7 +60,7 @@ func validateRequestJSON(jsn []byte, rc *RequestContext) ([]byte, error) {
 	err = json.Unmarshal(jsn, v)
 	if err != nil {
 		glog.Warningf("[%s] json decoding error; %v", rc.ID, err)
-		return nil, httpBadRequest("Invalid json")
+		return nil, jsonDecodeError(err)
 	}
 
 	//log.Printf("Received data: %s\n", jsn)
@@ -67,6 +73,7 @@ func validateRequestJSON(jsn []byte, rc *RequestContext) ([]byte, error) {
 	if !isSkipValidation(val.Type()) {
 		glog.Infof("[%s] Going to validate request", rc.ID)
 		validate := validator.New()
+		validate.RegisterTagNameFunc(jsonFieldName)
 		if val.Kind() == reflect.Slice {
 			//log.Println("Validate using Var")
 			err = validate.Var(v, "dive")
@@ -76,7 +83,7 @@ func validateRequestJSON(jsn []byte, rc *RequestContext) ([]byte, error) {
 		}
 		if err != nil {
 			glog.Warningf("[%s] validation failed: %v", rc.ID, err)
-			return nil, httpBadRequest("Content not as per schema")
+			return nil, validationErrors(err, val.Kind() != reflect.Slice)
 		}
 	} else {
 		glog.Infof("[%s] Skipping payload validation for dataType %v", rc.ID, val.Type())
@@ -92,3 +99,302 @@ func validateRequestJSON(jsn []byte, rc *RequestContext) ([]byte, error) {
 
 	return newBody, nil
 }
+
+// jsonFieldName returns the json member name of a struct field, for
+// reporting validation errors with json names.
+func jsonFieldName(f reflect.StructField) string {
+	name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// payloadError is a validation error for a node in the request payload.
+type payloadError struct {
+	Path    string // data path of the node; relative to payload root till resolved
+	Message string
+	Info    payloadErrorInfo
+}
+
+// payloadErrorInfo is the error-info data for a payloadError.
+type payloadErrorInfo struct {
+	Field      string      `json:"field"`                // json path within the payload
+	Constraint string      `json:"constraint,omitempty"` // type, mandatory, range, length, enum..
+	Expected   string      `json:"expected,omitempty"`   // constraint parameter or expected type
+	Value      interface{} `json:"value,omitempty"`      // received value
+}
+
+func (e payloadError) Error() string {
+	return e.Message
+}
+
+// jsonDecodeError creates a descriptive error for a json.Unmarshal error.
+// Returns a payloadError for type mismatches and a 400 httpError for
+// syntax errors.
+func jsonDecodeError(err error) error {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return httpBadRequest("Invalid json at offset %d; %v", e.Offset, e)
+	case *json.UnmarshalTypeError:
+		if len(e.Field) == 0 {
+			return httpBadRequest("Invalid json; expected %s, found %s", jsonKind(e.Type), e.Value)
+		}
+		field := jsonFieldPath(e.Field)
+		return payloadError{
+			Path:    field,
+			Message: fmt.Sprintf("Invalid value for '%s'; expected %s, found %s", localName(lastElem(field)), jsonKind(e.Type), e.Value),
+			Info:    payloadErrorInfo{Field: field, Constraint: "type", Expected: jsonKind(e.Type), Value: e.Value},
+		}
+	}
+	return httpBadRequest("Invalid json; %v", err)
+}
+
+// jsonFieldPath converts the dotted field path of a json.UnmarshalTypeError
+// into a "/member/list[N]/leaf" path. Array indexes, if present, become 1
+// based position predicates.
+func jsonFieldPath(field string) string {
+	var buf strings.Builder
+	for _, elem := range strings.Split(field, ".") {
+		if n, err := strconv.Atoi(elem); err == nil {
+			fmt.Fprintf(&buf, "[%d]", n+1)
+		} else {
+			buf.WriteString("/" + elem)
+		}
+	}
+	return buf.String()
+}
+
+// jsonKind returns the json value kind for a Go type.
+func jsonKind(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	}
+	return t.String()
+}
+
+// lastElem returns the last element of a slash separated path.
+func lastElem(path string) string {
+	return path[strings.LastIndexByte(path, '/')+1:]
+}
+
+// validationErrors converts validator errors into a MultiError, with
+// a payloadError for each invalid field. Error paths are relative to the
+// payload; in "/member/list[N]/leaf" format where N is the 1 based list
+// position. The first namespace element is the model type name if the
+// model is a struct; it is not part of the payload.
+func validationErrors(err error, isStruct bool) error {
+	verrs, ok := err.(validator.ValidationErrors)
+	if !ok || len(verrs) == 0 {
+		return httpBadRequest("Content not as per schema")
+	}
+
+	var errs MultiError
+	for _, fe := range verrs {
+		ns := strings.Split(fe.Namespace(), ".")
+		if isStruct {
+			ns = ns[1:]
+		}
+
+		var path strings.Builder
+		for _, elem := range ns {
+			name, index := splitIndexSuffix(elem)
+			if len(name) != 0 {
+				path.WriteString("/" + name)
+			}
+			if index >= 0 {
+				fmt.Fprintf(&path, "[%d]", index+1)
+			}
+		}
+
+		errs = append(errs, newFieldError(path.String(), fe))
+	}
+
+	return errs
+}
+
+// validatorConstraints maps the validator tags to constraint names and
+// the message phrases describing them.
+var validatorConstraints = map[string]struct{ name, phrase string }{
+	"min":   {"range", "at least"},
+	"gte":   {"range", "at least"},
+	"max":   {"range", "at most"},
+	"lte":   {"range", "at most"},
+	"gt":    {"range", "greater than"},
+	"lt":    {"range", "less than"},
+	"len":   {"length", "exactly"},
+	"eq":    {"range", "equal to"},
+	"oneof": {"enum", "one of"},
+}
+
+// newFieldError creates a payloadError for a validator field error.
+// Message includes the field name, violated constraint and the value.
+func newFieldError(path string, fe validator.FieldError) payloadError {
+	name := localName(fe.Field())
+	pe := payloadError{Path: path}
+	pe.Info = payloadErrorInfo{Field: path, Constraint: fe.Tag(), Expected: fe.Param()}
+
+	// Report only the scalar values
+	switch fe.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Float32, reflect.Float64:
+		pe.Info.Value = fe.Value()
+	}
+
+	c, known := validatorConstraints[fe.Tag()]
+	switch {
+	case fe.Tag() == "required":
+		pe.Info.Constraint = "mandatory"
+		pe.Message = fmt.Sprintf("Mandatory field '%s' is missing", name)
+
+	case known && c.name == "enum":
+		pe.Info.Constraint = c.name
+		pe.Message = fmt.Sprintf("Invalid value '%v' for '%s'; should be one of [%s]",
+			fe.Value(), name, fe.Param())
+
+	case known && (fe.Kind() == reflect.String || fe.Kind() == reflect.Slice || fe.Kind() == reflect.Map):
+		pe.Info.Constraint = "length"
+		pe.Message = fmt.Sprintf("Invalid length for '%s'; should be %s %s", name, c.phrase, fe.Param())
+
+	case known:
+		pe.Info.Constraint = c.name
+		pe.Message = fmt.Sprintf("Invalid value '%v' for '%s'; should be %s %s",
+			fe.Value(), name, c.phrase, fe.Param())
+
+	default:
+		pe.Message = fmt.Sprintf("Invalid value for '%s'; failed '%s' validation", name, fe.Tag())
+	}
+
+	return pe
+}
+
+// splitIndexSuffix splits a path element "name[N]" into the name and
+// the number N. Returns -1 for the number if there is no such suffix.
+func splitIndexSuffix(elem string) (string, int) {
+	k := strings.IndexByte(elem, '[')
+	if k < 0 || !strings.HasSuffix(elem, "]") {
+		return elem, -1
+	}
+	n, err := strconv.Atoi(elem[k+1 : len(elem)-1])
+	if err != nil {
+		return elem, -1
+	}
+	return elem[:k], n
+}
+
+// resolvePayloadErrorPaths converts the payload relative error paths
+// of validation errors into data tree paths. They are prefixed with the
+// path of the payload's parent node. List positions are replaced by the
+// key predicates if the list keys are known from the YANG schema.
+func resolvePayloadErrorPaths(err error, r *http.Request, rc *RequestContext, body []byte) error {
+	errs, ok := err.(MultiError)
+	if !ok {
+		errs = MultiError{err}
+	}
+
+	base := getPathForTranslib(r, rc)
+	if elems := splitTranslibPath(base); r.Method != "POST" && len(elems) != 0 {
+		base = "/" + strings.Join(elems[:len(elems)-1], "/")
+	}
+	base = strings.TrimSuffix(base, "/")
+
+	var data interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	d.Decode(&data)
+
+	for i, e := range errs {
+		if pe, ok := e.(payloadError); ok {
+			pe.Path = base + resolveListPositions(base, pe.Path, data)
+			errs[i] = pe
+		}
+	}
+	return errs.err()
+}
+
+// resolveListPositions replaces the list position predicates in a
+// payload relative path with list key predicates. Key values are picked
+// from the payload data. Positions are retained if the YANG schema is not
+// loaded or the list entry does not have all key values.
+func resolveListPositions(base, path string, data interface{}) string {
+	s := getYangSchema()
+	if s == nil {
+		return path
+	}
+
+	var e *yang.Entry
+	if len(base) != 0 {
+		if e = s.find(base); e == nil {
+			return path
+		}
+	}
+
+	var buf strings.Builder
+	for _, elem := range splitTranslibPath(path) {
+		name, pos := splitIndexSuffix(elem)
+		buf.WriteString("/" + name)
+
+		switch {
+		case e != nil:
+			e = dataChild(e, localName(name))
+		case strings.IndexByte(name, ':') > 0:
+			if m := s.modules[name[:strings.IndexByte(name, ':')]]; m != nil {
+				e = dataChild(m, localName(name))
+			}
+		}
+
+		m, _ := data.(map[string]interface{})
+		data = m[name]
+		if pos < 0 {
+			continue
+		}
+
+		var entry map[string]interface{}
+		if list, ok := data.([]interface{}); ok && pos > 0 && pos <= len(list) {
+			entry, _ = list[pos-1].(map[string]interface{})
+			data = entry
+		}
+
+		if keys := listKeyPredicates(e, entry); len(keys) != 0 {
+			buf.WriteString(keys)
+		} else {
+			fmt.Fprintf(&buf, "[%d]", pos)
+		}
+	}
+
+	return buf.String()
+}
+
+// listKeyPredicates returns the "[name=value]" key predicates for the
+// list entry data. Returns empty string if e is not a keyed list or any
+// of the key values are missing.
+func listKeyPredicates(e *yang.Entry, entry map[string]interface{}) string {
+	if e == nil || !e.IsList() || len(e.Key) == 0 || entry == nil {
+		return ""
+	}
+
+	var buf strings.Builder
+	for _, k := range strings.Fields(e.Key) {
+		v, ok := entry[k]
+		if !ok || v == nil {
+			return ""
+		}
+		fmt.Fprintf(&buf, "[%s=%s]", k, escapeKeyValue(fmt.Sprint(v)))
+	}
+	return buf.String()
+}
@@ -20,12 +20,14 @@
 package server
 
 import (
+	"crypto/tls"
 	"flag"
 	"net/http"
 	"path"
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/sonic-mgmt-common/translib"
@@ -45,8 +47,14 @@ type Router struct {
 	// config for this Router instance
 	config RouterConfig
 
-	// routes contains all registered route info
+	// routes contains all registered route info. A routeStore is never
+	// modified once it is in use; route changes are applied on a copy,
+	// which then replaces the current routeStore. The copy shares all
+	// routeTree nodes and handlers that are not affected by the change.
 	routes *routeStore
+
+	// routesMu guards the routes pointer
+	routesMu sync.RWMutex
 }
 
 // RouterConfig holds runtime configurations for a Router instance.
@@ -57,36 +65,47 @@ type RouterConfig struct {
 	// ServerAddr is the address to contact main server. Will be used to
 	// advertise the server's address (like yang download path).. Optional
 	ServerAddr string
+
+	// Certificates are the server's TLS certificates. Used by the
+	// readiness check to report expired certificates. Optional
+	Certificates []tls.Certificate
 }
 
 // ServeHTTP resolves and invokes the handler for http request r.
 // RESTCONF paths are served from the routeTree; rest from mux router.
 func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer doRecover(w, r)
-	path := cleanPath(r.URL.EscapedPath())
+	path := cleanPath(requestPath(r))
 	r = setContextValue(r, routerObjContextKey, router)
 
+	// Echo the request id back to client
+	rc, r := GetContext(r)
+	w.Header().Set(requestIDHeader, rc.ID)
+
+	routes := router.getRoutes()
 	if isServeFromTree(path) {
-		router.serveFromTree(path, r, w)
+		routes.serveFromTree(path, r, w)
 	} else {
-		router.routes.muxRoutes.ServeHTTP(w, r)
+		routes.muxRoutes.ServeHTTP(w, r)
 	}
 }
 
 // serveFromTree finds and invokes the handler for a path from routeTree
-func (router *Router) serveFromTree(path string, r *http.Request, w http.ResponseWriter) {
+func (rs *routeStore) serveFromTree(path string, r *http.Request, w http.ResponseWriter) {
 	var routeInfo routeMatchInfo
-	node := router.routes.rcRoutes.match(path, &routeInfo)
+	node, err := rs.rcRoutes.match(path, &routeInfo)
 
 	// Node not found..
-	if node == nil {
-		notFound(w, r)
+	if err != nil {
+		glog.V(2).Infof("NOT FOUND: %s %s from %s; %v",
+			r.Method, redactURLPath(requestPath(r)), r.RemoteAddr, err)
+		writeErrorResponse(w, r, err)
 		return
 	}
 
 	handler := node.handlers[r.Method]
 	if handler == nil && r.Method == "OPTIONS" {
-		handler = router.routes.rcOptsHandler
+		handler = rs.rcOptsHandler
 	}
 
 	// Node found, but no handler for the method
@@ -101,6 +120,24 @@ func (router *Router) serveFromTree(path string, r *http.Request, w http.Respons
 	handler.ServeHTTP(w, r)
 }
 
+// requestPath returns the request path as sent by the client, without
+// decoding. RESTCONF key values can contain percent-encoded delimiters
+// like "%2C", which should be decoded only after splitting the path into
+// elements and keys. r.URL.EscapedPath() may re-encode the path
+// differently if it contains characters that Go considers invalid in
+// an encoded path (like '|'). Falls back to r.URL.EscapedPath() if the
+// request URI is not available or is not in origin form.
+func requestPath(r *http.Request) string {
+	uri := r.RequestURI
+	if len(uri) == 0 || uri[0] != '/' {
+		return r.URL.EscapedPath()
+	}
+	if k := strings.IndexByte(uri, '?'); k >= 0 {
+		uri = uri[:k]
+	}
+	return uri
+}
+
 // cleanPath returns the canonical path for p
 func cleanPath(p string) string {
 	if p == "" {
@@ -125,7 +162,7 @@ func doRecover(w http.ResponseWriter, r *http.Request) {
 		buf := make([]byte, 64<<10)
 		buf = buf[:runtime.Stack(buf, false)]
 		glog.Errorf("Runtime error: panic serving REST request \"%s %s\", Client addr: %s",
-			r.Method, r.URL.Path, r.RemoteAddr)
+			r.Method, redactURLPath(requestPath(r)), r.RemoteAddr)
 		glog.Errorf("Panic data: %v\n%s", err, buf)
 		retErr := httpError(http.StatusInternalServerError, "unexpected error in server")
 		writeErrorResponse(w, r, retErr)
@@ -135,14 +172,25 @@ func doRecover(w http.ResponseWriter, r *http.Request) {
 // routeMatchInfo holds the matched route information in
 // request context.
 type routeMatchInfo struct {
-	path string
-	vars map[string]string
-	node *routeNode // only valid for tree based matches
+	path  string
+	vars  map[string]string
+	node  *routeNode       // only valid for tree based matches
+	elems []routeMatchElem // only valid for tree based matches
+}
+
+// routeMatchElem is a path element matched by routeTree. Holds the
+// matched routeNode and key values from the request path, in the same
+// order as the node's params. Omitted trailing keys are not included.
+type routeMatchElem struct {
+	node   *routeNode
+	values []string
 }
 
-// routeTree is the mapping of path prefix to routeNode object.
-// It holds REST API route infomation in tree format.
-type routeTree map[string]*routeNode
+// routeTree is a trie of REST API routes. Child nodes are indexed by
+// node name (path element without the key params). One name can map to
+// multiple nodes if the element appears with different key templates;
+// like "/list" and "/list={id}".
+type routeTree map[string][]*routeNode
 
 // routeNode is the node in routeTree. Each node represents one
 // element in the path. Eg, path "/one/two={id},{name}" has 2
@@ -162,7 +210,7 @@ type routeNode struct {
 // add function registers a REST API info into routeTree.
 func (t *routeTree) add(parentPrefix, path string, rr *routeRegInfo) {
 	root, next := pathSplit(path)
-	node := (*t)[root]
+	node := t.get(parentPrefix, root)
 	if node == nil {
 		node = newRouteNode(root, parentPrefix)
 		if node == nil {
@@ -171,7 +219,7 @@ func (t *routeTree) add(parentPrefix, path string, rr *routeRegInfo) {
 			return
 		}
 
-		(*t)[root] = node
+		(*t)[node.name] = append((*t)[node.name], node)
 	}
 
 	if len(next) == 0 {
@@ -180,7 +228,7 @@ func (t *routeTree) add(parentPrefix, path string, rr *routeRegInfo) {
 			node.handlers = make(map[string]http.Handler)
 		}
 
-		node.handlers[rr.method] = withMiddleware(rr.handler, rr.name)
+		node.handlers[rr.method] = rr.wrappedHandler()
 
 	} else {
 		if node.subpaths == nil {
@@ -191,46 +239,217 @@ func (t *routeTree) add(parentPrefix, path string, rr *routeRegInfo) {
 	}
 }
 
-// match resolves the routeNode for a request path.
-func (t *routeTree) match(path string, m *routeMatchInfo) *routeNode {
-	var matchedNode *routeNode
+// with returns a copy of the routeTree with the route rr added. Only the
+// nodes on the route's path are copied; rest of the tree is shared with t.
+// Returns false if the path is not valid.
+func (t routeTree) with(parentPrefix, path string, rr *routeRegInfo) (routeTree, bool) {
 	root, next := pathSplit(path)
-	name, values := pathNameValues(root)
-	numValues := len(values)
+	var node routeNode
+	if old := t.get(parentPrefix, root); old != nil {
+		node = *old
+	} else if n := newRouteNode(root, parentPrefix); n != nil {
+		node = *n
+	} else {
+		glog.Errorf("Failed to parse path node '%s'", root)
+		glog.Errorf("Ignoring route %s, %s %s", rr.name, rr.method, rr.path)
+		return t, false
+	}
 
-	for _, node := range *t {
-		if node.name != name || len(node.params) != numValues {
-			continue
+	if len(next) == 0 {
+		handlers := make(map[string]http.Handler, len(node.handlers)+1)
+		for m, h := range node.handlers {
+			handlers[m] = h
+		}
+		handlers[rr.method] = rr.wrappedHandler()
+		node.handlers = handlers
+	} else {
+		subpaths, ok := node.subpaths.with(node.path, next, rr)
+		if !ok {
+			return t, false
 		}
+		node.subpaths = subpaths
+	}
+
+	return t.withNode(node.name, node.path, &node), true
+}
 
-		// Node matched.. Collect variables
-		if numValues != 0 && m.vars == nil {
-			m.vars = make(map[string]string)
+// without returns a copy of the routeTree with the handler for method
+// removed from the path template. Nodes left without handlers and sub
+// paths are dropped. Only the nodes on the path are copied. Returns false
+// if there was no such handler.
+func (t routeTree) without(parentPrefix, path, method string) (routeTree, bool) {
+	root, next := pathSplit(path)
+	old := t.get(parentPrefix, root)
+	if old == nil {
+		return t, false
+	}
+
+	node := *old
+	if len(next) == 0 {
+		if node.handlers[method] == nil {
+			return t, false
+		}
+		handlers := make(map[string]http.Handler, len(node.handlers))
+		for m, h := range node.handlers {
+			if m != method {
+				handlers[m] = h
+			}
+		}
+		node.handlers = handlers
+	} else {
+		subpaths, ok := node.subpaths.without(node.path, next, method)
+		if !ok {
+			return t, false
 		}
-		for i, p := range node.params {
-			m.vars[p] = values[i]
+		node.subpaths = subpaths
+	}
+
+	if len(node.handlers) == 0 && len(node.subpaths) == 0 {
+		return t.withNode(node.name, node.path, nil), true
+	}
+	return t.withNode(node.name, node.path, &node), true
+}
+
+// withNode returns a copy of the routeTree with the child node for path
+// replaced by node. Node is removed if it is nil; added if it does not
+// exist already.
+func (t routeTree) withNode(name, path string, node *routeNode) routeTree {
+	var nodes []*routeNode
+	found := false
+	for _, n := range t[name] {
+		switch {
+		case n.path != path:
+			nodes = append(nodes, n)
+		case node != nil:
+			nodes = append(nodes, node)
+			found = true
 		}
+	}
+	if node != nil && !found {
+		nodes = append(nodes, node)
+	}
 
-		matchedNode = node
-		break
+	nt := make(routeTree, len(t)+1)
+	for k, v := range t {
+		nt[k] = v
+	}
+	if len(nodes) != 0 {
+		nt[name] = nodes
+	} else {
+		delete(nt, name)
+	}
+	return nt
+}
+
+// get returns the child node for path element template elem.
+func (t *routeTree) get(parentPrefix, elem string) *routeNode {
+	name, _ := pathNameValues(elem)
+	for _, node := range (*t)[name] {
+		if node.path == parentPrefix+elem {
+			return node
+		}
+	}
+	return nil
+}
+
+// match resolves the routeNode for a request path. Path elements are
+// matched by name and number of key values. A list instance element
+// can omit trailing key values (they are treated as wildcards); such
+// element will match the node with least number of key params that
+// can accommodate all the values. Returns a 404 error if a path element
+// is not known and 400 error if key values are not valid for it.
+func (t *routeTree) match(path string, m *routeMatchInfo) (*routeNode, error) {
+	root, next := pathSplit(path)
+	name, values := pathNameValues(root)
+	numValues := len(values)
+	if strings.IndexByte(name, '%') >= 0 {
+		name = unescapePathValue(name)
+	}
+	nodes := (*t)[name]
+
+	var matchedNode *routeNode
+	for _, node := range nodes {
+		n := len(node.params)
+		if n == numValues {
+			matchedNode = node
+			break
+		}
+		if numValues != 0 && n > numValues &&
+			(matchedNode == nil || n < len(matchedNode.params)) {
+			matchedNode = node
+		}
 	}
 
 	// No match
 	if matchedNode == nil {
-		return nil
+		return nil, routeMatchError(root, numValues, nodes)
 	}
 
+	// Node matched.. Collect variables
+	if numValues != 0 && m.vars == nil {
+		m.vars = make(map[string]string)
+	}
+	for i, v := range values {
+		m.vars[matchedNode.params[i]] = v
+	}
+
+	m.elems = append(m.elems, routeMatchElem{node: matchedNode, values: values})
+
 	// Full path match
 	if len(next) == 0 {
 		m.node = matchedNode
 		m.path = matchedNode.path
-		return matchedNode
+		return matchedNode, nil
 	}
 
 	// Paths matched so far.. Continue searching sub tree
 	return matchedNode.subpaths.match(next, m)
 }
 
+// routeMatchError returns an error describing why path element elem
+// did not match any of the routeNodes with same name.
+func routeMatchError(elem string, numValues int, nodes []*routeNode) error {
+	if len(nodes) == 0 {
+		return httpError(http.StatusNotFound,
+			"Resource not found; unknown path element '%s'", strings.TrimPrefix(elem, "/"))
+	}
+
+	maxKeys := 0
+	for _, node := range nodes {
+		if len(node.params) > maxKeys {
+			maxKeys = len(node.params)
+		}
+	}
+
+	name := strings.TrimPrefix(nodes[0].name, "/")
+	switch {
+	case maxKeys == 0:
+		return httpBadRequest("Path element '%s' does not accept key values", name)
+	case numValues == 0:
+		return httpError(http.StatusNotFound,
+			"Resource not found; key values required for path element '%s'", name)
+	default:
+		return httpBadRequest("Path element '%s' accepts at most %d key values; found %d",
+			name, maxKeys, numValues)
+	}
+}
+
+// find returns the routeNode for a path template. Returns nil if
+// the path is not registered in the routeTree.
+func (t *routeTree) find(path string) *routeNode {
+	return t.findNode("", path)
+}
+
+func (t *routeTree) findNode(parentPrefix, path string) *routeNode {
+	root, next := pathSplit(path)
+	node := t.get(parentPrefix, root)
+	if node == nil || len(next) == 0 {
+		return node
+	}
+
+	return node.subpaths.findNode(node.path, next)
+}
+
 // pathSplit splits a path into 2 parts - root and remaining.
 // For the last node remaining part will be an empty string.
 //
@@ -250,11 +469,15 @@ func pathSplit(p string) (string, string) {
 // pathNameValues splits the path element name and value list.
 // Path element is expected to be in "/name[=value[,value]*]" syntax.
 //
+// Values are split on literal commas only and are not decoded; caller
+// should unescape each value (a "%2C" within a value is not a separator).
+//
 // Exampels:
 // pathNameValues("/xx") returns ("/xx", nil)
-// pathNameValues("/xx=") returns ("/xx", [])
+// pathNameValues("/xx=") returns ("/xx", [""])
 // pathNameValues("/xx=yy") returns ("/xx", ["yy"])
 // pathNameValues("/xx=yy,zz") returns ("/xx", ["yy", "zz"])
+// pathNameValues("/xx=y%2Cy,zz") returns ("/xx", ["y%2Cy", "zz"])
 func pathNameValues(p string) (string, []string) {
 	if k := strings.Index(p, "="); k != -1 {
 		return p[0:k], strings.Split(p[k+1:], ",")
@@ -291,22 +514,91 @@ type routeRegInfo struct {
 	method  string
 	path    string
 	handler http.HandlerFunc
+
+	// readOnlyAllowed indicates that the route can be served in
+	// read-only mode, even if it is a write operation.
+	readOnlyAllowed bool
+
+	// wrapped is the handler with middleware; see wrappedHandler.
+	wrapped http.Handler
+}
+
+// wrappedHandler returns the route handler wrapped with the middleware
+// chain. The wrapped handler is created once and reused when the route
+// is added to other routeStores.
+func (rr *routeRegInfo) wrappedHandler() http.Handler {
+	if rr.wrapped == nil {
+		rr.wrapped = routeHandler{withMiddleware(rr.handler, rr), rr}
+	}
+	return rr.wrapped
+}
+
+// routeHandler is the http.Handler of a route in the routeTree; the
+// route handler wrapped with the middleware chain.
+type routeHandler struct {
+	http.Handler
+	route *routeRegInfo
+}
+
+// RouteOption is an optional setting for a route registered through
+// AddRoute.
+type RouteOption func(*routeRegInfo)
+
+// ReadOnlyAllowed is a RouteOption which allows the write requests of the
+// route in read-only mode. Should be used only for the routes which do
+// not change the configuration; like diagnostic RPCs.
+func ReadOnlyAllowed() RouteOption {
+	return func(rr *routeRegInfo) { rr.readOnlyAllowed = true }
 }
 
-// allRoutes is a collection of all routes
-var allRoutes = newRouteStore()
+// RouteInfo holds REST API route information, for registering routes
+// into a Router at runtime.
+type RouteInfo struct {
+	Name    string           // route name, for logging
+	Method  string           // HTTP method
+	Path    string           // path template. Eg: "/restconf/data/xx:yy/list={name}"
+	Handler http.HandlerFunc // handler function
+}
+
+var (
+	// allRoutes is a collection of all routes
+	allRoutes = newRouteStore()
+
+	// allRoutesMu guards allRoutes
+	allRoutesMu sync.Mutex
+)
 
 // AddRoute appends specified routes to the routes collection.
 // Called by init functions of swagger generated router.go files.
-func AddRoute(name, method, pattern string, handler http.HandlerFunc) {
+// Routers created by NewRouter already are not affected; the routeTree
+// shared with them is not modified, but copied on write.
+func AddRoute(name, method, pattern string, handler http.HandlerFunc, opts ...RouteOption) {
 	rr := routeRegInfo{
 		name:    name,
 		method:  strings.ToUpper(method),
 		path:    pattern,
 		handler: handler,
 	}
+	for _, opt := range opts {
+		opt(&rr)
+	}
 
-	allRoutes.addRoute(&rr)
+	allRoutesMu.Lock()
+	defer allRoutesMu.Unlock()
+
+	glog.V(2).Infof("Adding route %s, %s %s", rr.name, rr.method, rr.path)
+	rs := *allRoutes
+	rs.regs = append(rs.regs[:len(rs.regs):len(rs.regs)], &rr)
+
+	// Mux router of allRoutes is not shared; NewRouter creates a new one
+	if !isServeFromTree(rr.path) {
+		rs.addMuxRoute(&rr)
+	} else if t, ok := rs.rcRoutes.with("", rr.path, &rr); ok {
+		rs.rcRoutes = t
+		rs.rcRouteCount++
+	}
+
+	allRoutes = &rs
 }
 
 // NewRouter creates a new http router instance for the REST server.
@@ -315,20 +607,141 @@ func AddRoute(name, method, pattern string, handler http.HandlerFunc) {
 // Router instance specific configurations are accepted through a
 // RouterConfig object.
 func NewRouter(config RouterConfig) *Router {
+	allRoutesMu.Lock()
+	defer allRoutesMu.Unlock()
+
 	glog.Infof("Server has %d routes on routeTree and %d on mux router",
 		allRoutes.rcRouteCount, allRoutes.muxRouteCount)
 
-	// Add internal service API routes if not added already
-	allRoutes.addServiceRoutes(&config)
+	// Trigger background loading of YANG schema
+	getYangSchema()
 
-	router := &Router{
-		config: config,
-		routes: allRoutes,
-	}
+	// Router shares the routeTree with allRoutes, which is never modified
+	// in place. Mux router is recreated with the internal service API
+	// routes; it cannot be shared, since AddRoute modifies it.
+	router := &Router{config: config}
+	rs := *allRoutes
+	rs.resetMuxRoutes(&router.config)
+	router.routes = &rs
 
 	return router
 }
 
+// NewRouterWithRoutes creates a http router instance with only the
+// specified routes and internal service API routes. Routes registered
+// via AddRoute API are not included.
+func NewRouterWithRoutes(config RouterConfig, routes []RouteInfo) *Router {
+	router := &Router{config: config}
+	router.routes = newRouteStoreWith(toRouteRegInfos(routes), &router.config)
+	return router
+}
+
+// getRoutes returns the current routeStore of the router.
+func (router *Router) getRoutes() *routeStore {
+	router.routesMu.RLock()
+	defer router.routesMu.RUnlock()
+	return router.routes
+}
+
+// AddRoutes registers new routes into a running Router. Replaces the
+// existing route with same method and path, if any. Requests being
+// served during the update will continue to use the old routes.
+// Routes are not added to other Router instances.
+func (router *Router) AddRoutes(routes []RouteInfo) {
+	newRegs := toRouteRegInfos(routes)
+	router.updateRoutes(func(rr *routeRegInfo) bool {
+		for _, x := range newRegs {
+			if x.method == rr.method && x.path == rr.path {
+				return false
+			}
+		}
+		return true
+	}, newRegs)
+}
+
+// RemoveRoute removes the route with given method and path template
+// from a running Router. Returns false if no such route exists.
+func (router *Router) RemoveRoute(method, path string) bool {
+	method = strings.ToUpper(method)
+	return router.updateRoutes(func(rr *routeRegInfo) bool {
+		return rr.method != method || rr.path != path
+	}, nil) != 0
+}
+
+// RemoveRoutes removes all routes whose path template starts with
+// the given prefix, from a running Router. Returns the number of
+// routes removed.
+func (router *Router) RemoveRoutes(pathPrefix string) int {
+	return router.updateRoutes(func(rr *routeRegInfo) bool {
+		return !strings.HasPrefix(rr.path, pathPrefix)
+	}, nil)
+}
+
+// updateRoutes replaces the router's routeStore with a copy that has
+// only the existing routes for which keep function returns true, and the
+// new routes. RESTCONF routes are updated incrementally on the routeTree;
+// the mux router is recreated only if non-RESTCONF routes change.
+// Current routeStore is replaced only if there are changes. Returns the
+// number of routes dropped.
+func (router *Router) updateRoutes(keep func(*routeRegInfo) bool, newRegs []*routeRegInfo) int {
+	router.routesMu.Lock()
+	defer router.routesMu.Unlock()
+
+	rs := *router.routes
+	rs.regs = nil
+	muxChanged := false
+
+	for _, rr := range router.routes.regs {
+		if keep(rr) {
+			rs.regs = append(rs.regs, rr)
+		} else if !isServeFromTree(rr.path) {
+			muxChanged = true
+		} else if t, ok := rs.rcRoutes.without("", rr.path, rr.method); ok {
+			rs.rcRoutes = t
+			rs.rcRouteCount--
+		}
+	}
+
+	numRemoved := len(router.routes.regs) - len(rs.regs)
+	if numRemoved == 0 && len(newRegs) == 0 {
+		return 0
+	}
+
+	for _, rr := range newRegs {
+		glog.V(2).Infof("Adding route %s, %s %s", rr.name, rr.method, rr.path)
+		rs.regs = append(rs.regs, rr)
+		if !isServeFromTree(rr.path) {
+			muxChanged = true
+		} else if t, ok := rs.rcRoutes.with("", rr.path, rr); ok {
+			rs.rcRoutes = t
+			rs.rcRouteCount++
+		}
+	}
+
+	if muxChanged {
+		rs.resetMuxRoutes(&router.config)
+	}
+
+	router.routes = &rs
+
+	glog.Infof("Routes updated; %d removed, %d added. Server has %d routes on routeTree and %d on mux router",
+		numRemoved, len(newRegs), router.routes.rcRouteCount, router.routes.muxRouteCount)
+	return numRemoved
+}
+
+func toRouteRegInfos(routes []RouteInfo) []*routeRegInfo {
+	var regs []*routeRegInfo
+	for _, r := range routes {
+		regs = append(regs, &routeRegInfo{
+			name:    r.Name,
+			method:  strings.ToUpper(r.Method),
+			path:    r.Path,
+			handler: r.Handler,
+		})
+	}
+	return regs
+}
+
 // routeStore holds REST route information - which includes route name,
 // HTTP method, path and the handler function. All RESTCONF routes (path
 // starting with "/restconf") are maintained in a routeTree. Other routes
@@ -343,14 +756,22 @@ type routeStore struct {
 	muxOptsHandler http.Handler        // OPTIONS handler for mux routes
 	muxOptsData    map[string][]string // path to operations map for mux routes
 	muxRouteCount  uint32              // number of routes in mux router
+
+	regs []*routeRegInfo // all registered routes, in the order they were added
 }
 
 // newRouteStore creates an empty routeStore instance.
 func newRouteStore() *routeStore {
 	rs := new(routeStore)
 	rs.rcRoutes = make(routeTree)
-	rs.rcOptsHandler = withMiddleware(http.HandlerFunc(rcOptions), "optionsHandler")
+	rs.rcOptsHandler = withMiddleware(http.HandlerFunc(rcOptions), &routeRegInfo{name: "optionsHandler"})
+	rs.muxOptsHandler = withMiddleware(http.HandlerFunc(muxOptions), &routeRegInfo{name: "optionsHandler"})
+	rs.newMuxRouter()
+	return rs
+}
 
+// newMuxRouter creates an empty mux router for the routeStore.
+func (rs *routeStore) newMuxRouter() {
 	r := mux.NewRouter().StrictSlash(true).UseEncodedPath()
 	r.NotFoundHandler = http.HandlerFunc(notFound)
 	r.MethodNotAllowedHandler = http.HandlerFunc(notAllowed)
@@ -358,13 +779,38 @@ func newRouteStore() *routeStore {
 	rs.muxRoutes = r
 	rs.muxOptsRouter = r.Methods("OPTIONS").Subrouter()
 	rs.muxOptsData = make(map[string][]string)
-	rs.muxOptsHandler = withMiddleware(http.HandlerFunc(muxOptions), "optionsHandler")
+	rs.muxRouteCount = 0
+}
+
+// resetMuxRoutes recreates the mux router with the non-RESTCONF routes
+// in rs.regs and the internal service API routes. A mux router does not
+// support removing routes; hence it is replaced. Handlers are reused.
+func (rs *routeStore) resetMuxRoutes(config *RouterConfig) {
+	rs.newMuxRouter()
+	for _, rr := range rs.regs {
+		if !isServeFromTree(rr.path) {
+			rs.addMuxRoute(rr)
+		}
+	}
+
+	rs.addServiceRoutes(config)
+}
+
+// newRouteStoreWith creates a routeStore with given routes and the
+// internal service API routes.
+func newRouteStoreWith(regs []*routeRegInfo, config *RouterConfig) *routeStore {
+	rs := newRouteStore()
+	for _, rr := range regs {
+		rs.addRoute(rr)
+	}
 
+	rs.addServiceRoutes(config)
 	return rs
 }
 
 func (rs *routeStore) addRoute(rr *routeRegInfo) {
 	glog.V(2).Infof("Adding route %s, %s %s", rr.name, rr.method, rr.path)
+	rs.regs = append(rs.regs, rr)
 
 	if isServeFromTree(rr.path) {
 		rs.rcRoutes.add("", rr.path, rr)
@@ -375,7 +821,7 @@ func (rs *routeStore) addRoute(rr *routeRegInfo) {
 }
 
 func (rs *routeStore) addMuxRoute(rr *routeRegInfo) {
-	h := withMiddleware(rr.handler, rr.name)
+	h := rr.wrappedHandler()
 	rs.muxRoutes.Methods(rr.method).Path(rr.path).Handler(h)
 	rs.muxOptsRouter.Path(rr.path).Handler(rs.muxOptsHandler)
 	rs.muxOptsData[rr.path] = append(rs.muxOptsData[rr.path], rr.method)
@@ -402,6 +848,15 @@ func (rs *routeStore) addServiceRoutes(config *RouterConfig) {
 		translib.SetSchemaRootURL(strings.TrimSuffix(config.ServerAddr, "/") + yangPrefix)
 		rs.addFilesystemRoute("yangDownload", yangPrefix, translib.GetYangPath())
 	}
+
+	// Liveness and readiness probes. These are not authenticated
+	// and hence not wrapped with the default middleware chain.
+	if router.Get("healthz") == nil {
+		router.Name("healthz").Methods("GET", "HEAD").Path("/healthz").
+			HandlerFunc(healthzHandler)
+		router.Name("readyz").Methods("GET", "HEAD").Path("/readyz").
+			HandlerFunc(readyzHandler)
+	}
 }
 
 // addFilesystemRoute creates a mux route to handle file system based GET requests.
@@ -440,39 +895,102 @@ func getRouterConfig(r *http.Request) *RouterConfig {
 }
 
 // loggingMiddleware returns a handler which times and logs the request.
-// It should be the top handler in the middleware chain.
+// It should be the top handler in the middleware chain. Logs an access
+// record with request id, user, route, method, path, status, response
+// size and latency fields after the request is served.
 func loggingMiddleware(inner http.Handler, name string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rc, r := GetContext(r)
 		rc.Name = name
 
-		glog.Infof("[%s] Recevied %s request from %s", rc.ID, name, r.RemoteAddr)
+		requestLog(rc).with("remote", r.RemoteAddr).Infof("Received request")
 
 		start := time.Now()
+		sp, r := startSpan(r, name)
+		sp.setAttr("http.method", r.Method)
+		sp.setAttr("http.route", getRouteMatchInfo(r).path)
+		sp.setAttr("http.target", redactRequestURI(r))
+		sp.setAttr("request.id", rc.ID)
+
+		sw := &statusWriter{ResponseWriter: w}
+		inner.ServeHTTP(sw, r)
+
+		sp.setHTTPStatus(sw.statusCode())
+		sp.finish()
+
+		requestLog(rc).
+			with("method", r.Method).
+			with("path", redactURLPath(requestPath(r))).
+			with("status", sw.statusCode()).
+			with("bytes", sw.bytes).
+			with("latency", time.Since(start)).
+			Infof("Request completed")
+	})
+}
 
-		inner.ServeHTTP(w, r)
+// statusWriter is a http.ResponseWriter wrapper which records the
+// response status code and number of bytes written.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
 
-		glog.Infof("[%s] %s took %s", rc.ID, name, time.Since(start))
-	})
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += n
+	return n, err
+}
+
+// Flush sends any buffered data to the client, if the underlying
+// http.ResponseWriter supports it.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// statusCode returns the response status code written so far.
+// Returns 200 if nothing was written, similar to http.Server.
+func (w *statusWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
 }
 
 // withMiddleware function prepares the default middleware chain for
-// REST APIs.
-func withMiddleware(h http.Handler, name string) http.Handler {
+// REST APIs. Route specific options are taken from rr.
+func withMiddleware(h http.Handler, rr *routeRegInfo) http.Handler {
+	h = timeoutMiddleware(h, rr.name)
+	h = readOnlyMiddleware(h, rr)
 	h = authMiddleware(h)
-	return loggingMiddleware(h, name)
+	return loggingMiddleware(h, rr.name)
 }
 
 // notFound responds with HTTP 404 status
 func notFound(w http.ResponseWriter, r *http.Request) {
-	glog.V(2).Infof("NOT FOUND: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+	glog.V(2).Infof("NOT FOUND: %s %s from %s", r.Method, redactURLPath(requestPath(r)), r.RemoteAddr)
 	writeErrorResponse(w, r,
 		httpError(http.StatusNotFound, "Not Found"))
 }
 
 // notAllowed responds with HTTP 405 status
 func notAllowed(w http.ResponseWriter, r *http.Request) {
-	glog.V(2).Infof("NOT ALLOWED: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+	glog.V(2).Infof("NOT ALLOWED: %s %s from %s", r.Method, redactURLPath(requestPath(r)), r.RemoteAddr)
 	writeErrorResponse(w, r,
 		httpError(http.StatusMethodNotAllowed, "%s Not Allowed", r.Method))
 }
@@ -491,5 +1009,5 @@ func rcOptions(w http.ResponseWriter, r *http.Request) {
 func muxOptions(w http.ResponseWriter, r *http.Request) {
 	match := getRouteMatchInfo(r)
 	routr := getContextValue(r, routerObjContextKey).(*Router)
-	writeOptionsResponse(w, r, match.path, routr.routes.muxOptsData[match.path])
+	writeOptionsResponse(w, r, match.path, routr.getRoutes().muxOptsData[match.path])
 }
@@ -0,0 +1,358 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newMatchTestTree creates a routeTree with few list and container
+// routes for testing routeTree.match.
+func newMatchTestTree() routeTree {
+	t := make(routeTree)
+	for _, p := range []string{
+		"/restconf/data/test:top",
+		"/restconf/data/test:top/list",
+		"/restconf/data/test:top/list={a},{b},{c}",
+		"/restconf/data/test:top/list={a},{b},{c}/config",
+		"/restconf/data/test:top/pair={x},{y}",
+		"/restconf/data/test:top/single={id}/data",
+	} {
+		t.add("", p, &routeRegInfo{name: p, method: "GET", handler: newHandler(200)})
+	}
+	return t
+}
+
+func TestRouteMatch(t *testing.T) {
+	tree := newMatchTestTree()
+
+	t.Run("container", testRouteMatch(tree,
+		"/restconf/data/test:top",
+		"/restconf/data/test:top", nil))
+	t.Run("list", testRouteMatch(tree,
+		"/restconf/data/test:top/list",
+		"/restconf/data/test:top/list", nil))
+	t.Run("all_keys", testRouteMatch(tree,
+		"/restconf/data/test:top/list=1,2,3/config",
+		"/restconf/data/test:top/list={a},{b},{c}/config",
+		map[string]string{"a": "1", "b": "2", "c": "3"}))
+	t.Run("partial_keys", testRouteMatch(tree,
+		"/restconf/data/test:top/list=1,2",
+		"/restconf/data/test:top/list={a},{b},{c}",
+		map[string]string{"a": "1", "b": "2"}))
+	t.Run("one_key", testRouteMatch(tree,
+		"/restconf/data/test:top/list=1/config",
+		"/restconf/data/test:top/list={a},{b},{c}/config",
+		map[string]string{"a": "1"}))
+	t.Run("empty_key", testRouteMatch(tree,
+		"/restconf/data/test:top/single=/data",
+		"/restconf/data/test:top/single={id}/data",
+		map[string]string{"id": ""}))
+}
+
+func testRouteMatch(tree routeTree, path, expTemplate string, expVars map[string]string) func(*testing.T) {
+	return func(t *testing.T) {
+		var m routeMatchInfo
+		node, err := tree.match(path, &m)
+		if err != nil {
+			t.Fatalf("match failed for %s; %v", path, err)
+		}
+		if node.path != expTemplate || m.path != expTemplate {
+			t.Fatalf("Path %s matched template %s; expected %s", path, node.path, expTemplate)
+		}
+		if len(expVars) != 0 && !reflect.DeepEqual(m.vars, expVars) {
+			t.Fatalf("Expected vars %v; found %v", expVars, m.vars)
+		}
+	}
+}
+
+func TestRouteMatch_error(t *testing.T) {
+	tree := newMatchTestTree()
+
+	t.Run("unknown", testRouteMatchError(tree,
+		"/restconf/data/test:top/unknown/config", 404, "'unknown'"))
+	t.Run("unknown_child", testRouteMatchError(tree,
+		"/restconf/data/test:top/list=1,2,3/state", 404, "'state'"))
+	t.Run("unknown_module", testRouteMatchError(tree,
+		"/restconf/data/xyz:top", 404, "'xyz:top'"))
+	t.Run("too_many_keys", testRouteMatchError(tree,
+		"/restconf/data/test:top/pair=1,2,3", 400, "'pair'"))
+	t.Run("keys_for_container", testRouteMatchError(tree,
+		"/restconf/data/test:top=1", 400, "'test:top'"))
+	t.Run("keys_missing", testRouteMatchError(tree,
+		"/restconf/data/test:top/single/data", 404, "'single'"))
+}
+
+func testRouteMatchError(tree routeTree, path string, expStatus int, expMsg string) func(*testing.T) {
+	return func(t *testing.T) {
+		var m routeMatchInfo
+		node, err := tree.match(path, &m)
+		if err == nil {
+			t.Fatalf("Path %s should not have matched; found %s", path, node.path)
+		}
+
+		e, ok := err.(httpErrorType)
+		if !ok || e.status != expStatus || !strings.Contains(e.message, expMsg) {
+			t.Fatalf("Expected status %d with message %s; found %v", expStatus, expMsg, err)
+		}
+	}
+}
+
+func TestRouteFind(t *testing.T) {
+	tree := newMatchTestTree()
+	for _, p := range []string{
+		"/restconf/data/test:top",
+		"/restconf/data/test:top/list",
+		"/restconf/data/test:top/list={a},{b},{c}/config",
+	} {
+		if node := tree.find(p); node == nil || node.path != p {
+			t.Errorf("find(%s) returned %v", p, node)
+		}
+	}
+
+	if node := tree.find("/restconf/data/test:top/list={a}"); node != nil {
+		t.Errorf("find returned %s for unknown template", node.path)
+	}
+}
+
+func TestServeFromTree_error(t *testing.T) {
+	s := newEmptyRouter()
+	s.addRoute("pair", "GET", "/restconf/data/test:top/pair={x},{y}", newHandler(200))
+
+	t.Run("404", testServeStatus(s, "/restconf/data/test:top/unknown", 404))
+	t.Run("400", testServeStatus(s, "/restconf/data/test:top/pair=1,2,3", 400))
+	t.Run("200", testServeStatus(s, "/restconf/data/test:top/pair=1", 200))
+}
+
+func testServeStatus(s *Router, path string, expStatus int) func(*testing.T) {
+	return func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		verifyResponse(t, w, expStatus)
+		if expStatus >= http.StatusBadRequest && !strings.Contains(w.Body.String(), "ietf-restconf:errors") {
+			t.Fatalf("Expected RESTCONF error response; found %s", w.Body.String())
+		}
+	}
+}
+
+func TestAddRoutes(t *testing.T) {
+	s := NewRouterWithRoutes(RouterConfig{}, []RouteInfo{
+		{"one", "GET", "/restconf/data/test:one", newHandler(200)},
+	})
+
+	t.Run("initial_one", testServeStatus(s, "/restconf/data/test:one", 200))
+	t.Run("initial_two", testServeStatus(s, "/restconf/data/test:two=1", 404))
+
+	s.AddRoutes([]RouteInfo{
+		{"two", "get", "/restconf/data/test:two={id}", newHandler(201)},
+		{"mux", "GET", "/test/mux", newHandler(202)},
+	})
+
+	t.Run("added_one", testServeStatus(s, "/restconf/data/test:one", 200))
+	t.Run("added_two", testServeStatus(s, "/restconf/data/test:two=1", 201))
+	t.Run("added_mux", testServeStatus(s, "/test/mux", 202))
+	t.Run("healthz", testServeStatus(s, "/healthz", 200))
+
+	// Replace existing route
+	s.AddRoutes([]RouteInfo{{"one", "GET", "/restconf/data/test:one", newHandler(203)}})
+	t.Run("replaced_one", testServeStatus(s, "/restconf/data/test:one", 203))
+
+	if n := len(s.getRoutes().regs); n != 3 {
+		t.Fatalf("Expected 3 routes; found %d", n)
+	}
+}
+
+func TestAddRoutes_incremental(t *testing.T) {
+	s := NewRouterWithRoutes(RouterConfig{}, []RouteInfo{
+		{"one", "GET", "/restconf/data/test:top/one", newHandler(200)},
+		{"xyz", "GET", "/restconf/data/xyz:top", newHandler(200)},
+		{"mux", "GET", "/test/mux", newHandler(200)},
+	})
+
+	old := s.getRoutes()
+	oldOne := old.rcRoutes.find("/restconf/data/test:top/one")
+	oldXyz := old.rcRoutes.find("/restconf/data/xyz:top")
+
+	s.AddRoutes([]RouteInfo{{"two", "GET", "/restconf/data/test:top/two", newHandler(201)}})
+	rs := s.getRoutes()
+
+	// Unchanged nodes and mux router should be shared with old routeStore
+	if rs.rcRoutes.find("/restconf/data/test:top/one") != oldOne {
+		t.Fatalf("Sibling node was copied")
+	}
+	if rs.rcRoutes.find("/restconf/data/xyz:top") != oldXyz {
+		t.Fatalf("Unrelated node was copied")
+	}
+	if rs.muxRoutes != old.muxRoutes {
+		t.Fatalf("Mux router was recreated")
+	}
+
+	// Old routeStore should not be modified
+	if old.rcRoutes.find("/restconf/data/test:top/two") != nil {
+		t.Fatalf("Old routeStore was modified")
+	}
+
+	s.RemoveRoute("GET", "/restconf/data/test:top/one")
+	if s.getRoutes().rcRoutes.find("/restconf/data/xyz:top") != oldXyz {
+		t.Fatalf("Unrelated node was copied during remove")
+	}
+	if s.getRoutes().rcRoutes.find("/restconf/data/test:top/one") != nil {
+		t.Fatalf("Removed node still exists")
+	}
+	if n := s.getRoutes().rcRouteCount; n != 2 {
+		t.Fatalf("Expected 2 RESTCONF routes; found %d", n)
+	}
+}
+
+func TestRemoveRoutes(t *testing.T) {
+	s := NewRouterWithRoutes(RouterConfig{}, []RouteInfo{
+		{"one", "GET", "/restconf/data/test:one", newHandler(200)},
+		{"one", "PUT", "/restconf/data/test:one", newHandler(204)},
+		{"two", "GET", "/restconf/data/test:two", newHandler(200)},
+		{"three", "GET", "/restconf/data/xyz:three", newHandler(200)},
+		{"mux", "GET", "/test/mux", newHandler(200)},
+	})
+
+	if !s.RemoveRoute("put", "/restconf/data/test:one") {
+		t.Fatalf("RemoveRoute failed")
+	}
+	if s.RemoveRoute("PUT", "/restconf/data/test:one") {
+		t.Fatalf("RemoveRoute succeeded for unknown route")
+	}
+
+	t.Run("get_one", testServeStatus(s, "/restconf/data/test:one", 200))
+	t.Run("put_one", testServeMethod(s, "PUT", "/restconf/data/test:one", 405))
+
+	if n := s.RemoveRoutes("/restconf/data/test:"); n != 2 {
+		t.Fatalf("RemoveRoutes(prefix) removed %d routes; expected 2", n)
+	}
+
+	t.Run("removed_one", testServeStatus(s, "/restconf/data/test:one", 404))
+	t.Run("removed_two", testServeStatus(s, "/restconf/data/test:two", 404))
+	t.Run("three", testServeStatus(s, "/restconf/data/xyz:three", 200))
+
+	if n := s.RemoveRoutes("/test/"); n != 1 {
+		t.Fatalf("RemoveRoutes(mux) removed %d routes; expected 1", n)
+	}
+	t.Run("removed_mux", testServeStatus(s, "/test/mux", 404))
+}
+
+func TestRouterIsolation(t *testing.T) {
+	s1 := NewRouterWithRoutes(RouterConfig{}, nil)
+	s2 := NewRouterWithRoutes(RouterConfig{}, nil)
+	s1.AddRoutes([]RouteInfo{{"iso", "GET", "/restconf/data/test:isolated", newHandler(200)}})
+
+	t.Run("s1", testServeStatus(s1, "/restconf/data/test:isolated", 200))
+	t.Run("s2", testServeStatus(s2, "/restconf/data/test:isolated", 404))
+
+	if allRoutes.rcRoutes.find("/restconf/data/test:isolated") != nil {
+		t.Fatalf("Route added to a Router instance leaked into global routes")
+	}
+}
+
+func TestAddRouteAfterNewRouter(t *testing.T) {
+	allRoutesMu.Lock()
+	orig := allRoutes
+	allRoutesMu.Unlock()
+	defer func() {
+		allRoutesMu.Lock()
+		allRoutes = orig
+		allRoutesMu.Unlock()
+	}()
+
+	AddRoute("cowOne", "GET", "/restconf/data/cow-test:top/one", newHandler(200))
+	s := NewRouter(RouterConfig{})
+	old := s.getRoutes().rcRoutes.find("/restconf/data/cow-test:top")
+
+	AddRoute("cowTwo", "GET", "/restconf/data/cow-test:top/two", newHandler(200))
+	AddRoute("cowMux", "GET", "/test/cow", newHandler(200))
+
+	t.Run("one", testServeStatus(s, "/restconf/data/cow-test:top/one", 200))
+	t.Run("two", testServeStatus(s, "/restconf/data/cow-test:top/two", 404))
+	t.Run("mux", testServeStatus(s, "/test/cow", 404))
+	if s.getRoutes().rcRoutes.find("/restconf/data/cow-test:top") != old {
+		t.Fatalf("Router's routeTree was modified")
+	}
+
+	s2 := NewRouter(RouterConfig{})
+	t.Run("new_two", testServeStatus(s2, "/restconf/data/cow-test:top/two", 200))
+	t.Run("new_mux", testServeStatus(s2, "/test/cow", 200))
+}
+
+func TestRouteUpdateWhileServing(t *testing.T) {
+	s := NewRouterWithRoutes(RouterConfig{}, []RouteInfo{
+		{"stable", "GET", "/restconf/data/test:stable", newHandler(200)},
+	})
+
+	done := make(chan struct{})
+	errs := make(chan string, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, httptest.NewRequest("GET", "/restconf/data/test:stable", nil))
+				if w.Code != 200 {
+					select {
+					case errs <- fmt.Sprintf("status %d", w.Code):
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		p := fmt.Sprintf("/restconf/data/test:dynamic%d", i)
+		s.AddRoutes([]RouteInfo{{"dyn", "GET", p, newHandler(200)}})
+		s.RemoveRoute("GET", p)
+	}
+
+	close(done)
+	wg.Wait()
+
+	select {
+	case e := <-errs:
+		t.Fatalf("Request failed during route update; %s", e)
+	default:
+	}
+}
+
+func testServeMethod(s *Router, method, path string, expStatus int) func(*testing.T) {
+	return func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		verifyResponse(t, w, expStatus)
+	}
+}
@@ -0,0 +1,175 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// isActionRequest checks if a POST request on a data resource is a YANG 1.1
+// action invocation. Path is the translib path of the request. Payload of
+// an action can only be empty or the "input" node; other requests are data
+// resource creations. For the rest, waits for the YANG schema to load, if
+// yang_dir is set, and uses it to identify actions. If the schema is not
+// available, the request is treated as an action if the payload contains
+// only a module qualified "input" node and the target resource does not
+// have a data node with that name. Returns a 503 error if the request
+// context is done before the schema loading ends.
+func isActionRequest(r *http.Request, path string, body []byte) (bool, error) {
+	if !mayBeActionInput(body) {
+		return false, nil
+	}
+
+	s, err := waitYangSchema(r.Context())
+	if err != nil {
+		return false, err
+	}
+	if s != nil {
+		e := s.find(path)
+		return e != nil && e.RPC != nil, nil
+	}
+
+	node := getRouteMatchInfo(r).node
+	if node == nil || len(node.subpaths["/input"]) != 0 {
+		return false, nil
+	}
+
+	var m map[string]json.RawMessage
+	return json.Unmarshal(body, &m) == nil && len(m) == 1, nil
+}
+
+// mayBeActionInput checks if the POST request body can be the input of
+// an action - empty, not json (like xml) or a json object with only a
+// module qualified "input" member.
+func mayBeActionInput(body []byte) bool {
+	var m map[string]json.RawMessage
+	if len(bytes.TrimSpace(body)) == 0 || json.Unmarshal(body, &m) != nil {
+		return true
+	}
+	if len(m) != 1 {
+		return false
+	}
+	for name := range m {
+		return strings.IndexByte(name, ':') > 0 && localName(name) == "input"
+	}
+	return false
+}
+
+// validateRPCInput validates the input payload of an RPC or action.
+// Payload should contain only the "input" node. Its contents are validated
+// against the YANG schema, if loaded. Called only if yang_validation is
+// enabled.
+func validateRPCInput(path string, body []byte) error {
+	var input interface{}
+	if len(body) != 0 {
+		var m map[string]interface{}
+		d := json.NewDecoder(bytes.NewReader(body))
+		d.UseNumber()
+		if err := d.Decode(&m); err != nil {
+			return httpBadRequest("Invalid RPC input; %v", err)
+		}
+		for name, v := range m {
+			if len(m) != 1 || localName(name) != "input" {
+				return httpBadRequest("RPC input should contain only the 'input' node")
+			}
+			input = v
+		}
+	}
+
+	s := getYangSchema()
+	if s == nil {
+		return nil
+	}
+
+	// Unknown RPCs are left to the backend
+	e := s.find(path)
+	if e == nil || e.RPC == nil {
+		return nil
+	}
+
+	if e.RPC.Input == nil {
+		if m, ok := input.(map[string]interface{}); input != nil && (!ok || len(m) != 0) {
+			return invalidNodeError(path, "'%s' does not accept input", e.Name)
+		}
+		return nil
+	}
+
+	if input == nil {
+		input = map[string]interface{}{}
+	}
+
+	return jsonValidator{schema: s}.container(e.RPC.Input, input, path+"/input")
+}
+
+// formatRPCOutput returns the HTTP status and response payload for the
+// output data of an RPC or action. Status is 204 if there is no output
+// data. Otherwise it is 200 and the output data is wrapped in a module
+// qualified "output" node as per RFC8040, section 3.6.2; unless the
+// backend has wrapped it already.
+func formatRPCOutput(path string, data []byte) (int, []byte) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return http.StatusNoContent, nil
+	}
+
+	var m map[string]json.RawMessage
+	if json.Unmarshal(data, &m) != nil {
+		return http.StatusOK, data // not json; leave as is
+	}
+	if len(m) == 0 {
+		return http.StatusNoContent, nil
+	}
+
+	for name, v := range m {
+		if len(m) == 1 && localName(name) == "output" {
+			if v := string(bytes.TrimSpace(v)); v == "{}" || v == "null" {
+				return http.StatusNoContent, nil
+			}
+			return http.StatusOK, data
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"` + rpcModule(path) + `:output":`)
+	buf.Write(data)
+	buf.WriteString("}")
+	return http.StatusOK, buf.Bytes()
+}
+
+// rpcModule returns the module name of the RPC or action at a translib
+// path. It is the prefix of the last path element; or of the first
+// element if the last one is not prefixed.
+func rpcModule(path string) string {
+	elems := splitTranslibPath(path)
+	if len(elems) == 0 {
+		return ""
+	}
+
+	for _, elem := range []string{elems[len(elems)-1], elems[0]} {
+		name, _ := splitElemKeys(elem)
+		if k := strings.IndexByte(name, ':'); k > 0 {
+			return name[:k]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,321 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/sonic-mgmt-common/translib/tlerr"
+)
+
+// rpcTestYang is a YANG module with rpcs and actions for testing.
+const rpcTestYang = `
+module rpc-test {
+	yang-version 1.1;
+	namespace "http://example.com/rpc-test";
+	prefix rt;
+
+	container top {
+		list server {
+			key "name";
+			leaf name { type string; }
+			action reset {
+				input {
+					leaf delay { type uint8; }
+				}
+			}
+		}
+	}
+
+	rpc restart {
+		input {
+			leaf service {
+				type string;
+				mandatory true;
+			}
+			leaf mode {
+				type enumeration {
+					enum warm;
+					enum cold;
+				}
+			}
+			leaf-list args { type string; }
+			leaf timeout { type uint64; }
+			leaf target {
+				type leafref { path "/rt:top/rt:server/rt:name"; }
+			}
+			choice when {
+				leaf at { type string; }
+				leaf after { type uint32; }
+			}
+		}
+		output {
+			leaf status { type string; }
+		}
+	}
+
+	rpc ping;
+}`
+
+// useYangSchema replaces current yangSchema with s. Returns a
+// function to restore the original schema.
+func useYangSchema(s *yangSchema) func() {
+	orig := getYangSchema()
+	theYangSchema.Store(s)
+	return func() { theYangSchema.Store(orig) }
+}
+
+// loadTestYangSchema loads a yangSchema from YANG module texts.
+func loadTestYangSchema(t *testing.T, modules ...string) *yangSchema {
+	dir, err := ioutil.TempDir("", "restyang")
+	if err != nil {
+		t.Fatalf("TempDir failed; %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, m := range modules {
+		ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("m%d.yang", i)), []byte(m), 0600)
+	}
+
+	s, err := loadYangSchema(dir)
+	if err != nil {
+		t.Fatalf("loadYangSchema failed; %v", err)
+	}
+	return s
+}
+
+func TestFormatRPCOutput(t *testing.T) {
+	t.Run("none", testRPCOutput("/m:rpc", "", 204, ""))
+	t.Run("empty", testRPCOutput("/m:rpc", "{}", 204, ""))
+	t.Run("empty_output", testRPCOutput("/m:rpc", `{"m:output":{}}`, 204, ""))
+	t.Run("wrapped", testRPCOutput("/m:rpc", `{"m:output":{"x":1}}`, 200, `{"m:output":{"x":1}}`))
+	t.Run("bare", testRPCOutput("/m:rpc", `{"x":1}`, 200, `{"m:output":{"x":1}}`))
+	t.Run("action", testRPCOutput("/m:top/list[k=v]/reset", `{"x":1}`, 200, `{"m:output":{"x":1}}`))
+	t.Run("augmented_action", testRPCOutput("/m:top/n:reset", `{"x":1}`, 200, `{"n:output":{"x":1}}`))
+}
+
+func testRPCOutput(path, data string, expStatus int, expData string) func(*testing.T) {
+	return func(t *testing.T) {
+		status, out := formatRPCOutput(path, []byte(data))
+		if status != expStatus || string(out) != expData {
+			t.Fatalf("Expected status %d and data '%s'; found %d and '%s'", expStatus, expData, status, out)
+		}
+	}
+}
+
+func TestValidateRPCInput(t *testing.T) {
+	defer useYangSchema(loadTestYangSchema(t, rpcTestYang))()
+
+	t.Run("valid", testRPCInput("/rpc-test:restart",
+		`{"rpc-test:input": {"service": "bgp", "mode": "warm", "args": ["a"], "after": 5}}`, ""))
+	t.Run("no_input_node", testRPCInput("/rpc-test:restart",
+		`{"rpc-test:service": "bgp"}`, "'input'"))
+	t.Run("unknown", testRPCInput("/rpc-test:restart",
+		`{"rpc-test:input": {"service": "bgp", "force": true}}`, "'force'"))
+	t.Run("mandatory", testRPCInput("/rpc-test:restart",
+		`{"rpc-test:input": {"mode": "cold"}}`, "'service'"))
+	t.Run("bad_enum", testRPCInput("/rpc-test:restart",
+		`{"rpc-test:input": {"service": "bgp", "mode": "hot"}}`, "'hot'"))
+	t.Run("bad_int", testRPCInput("/rpc-test:restart",
+		`{"rpc-test:input": {"service": "bgp", "after": "5"}}`, "'after'"))
+	t.Run("uint64_string", testRPCInput("/rpc-test:restart",
+		`{"rpc-test:input": {"service": "bgp", "timeout": "100"}}`, ""))
+	t.Run("uint64_number", testRPCInput("/rpc-test:restart",
+		`{"rpc-test:input": {"service": "bgp", "timeout": 100}}`, ""))
+	t.Run("bad_uint64", testRPCInput("/rpc-test:restart",
+		`{"rpc-test:input": {"service": "bgp", "timeout": -1}}`, "'timeout'"))
+	t.Run("abs_leafref", testRPCInput("/rpc-test:restart",
+		`{"rpc-test:input": {"service": "bgp", "target": "s1"}}`, ""))
+	t.Run("bad_abs_leafref", testRPCInput("/rpc-test:restart",
+		`{"rpc-test:input": {"service": "bgp", "target": 1}}`, "'target'"))
+	t.Run("not_array", testRPCInput("/rpc-test:restart",
+		`{"rpc-test:input": {"service": "bgp", "args": "a"}}`, "'args'"))
+	t.Run("no_input", testRPCInput("/rpc-test:ping", "", ""))
+	t.Run("extra_input", testRPCInput("/rpc-test:ping",
+		`{"rpc-test:input": {"x": 1}}`, "does not accept input"))
+	t.Run("action", testRPCInput("/rpc-test:top/server[name=s1]/reset",
+		`{"rpc-test:input": {"delay": 10}}`, ""))
+	t.Run("action_range", testRPCInput("/rpc-test:top/server[name=s1]/reset",
+		`{"rpc-test:input": {"delay": 1000}}`, "'delay'"))
+	t.Run("unknown_rpc", testRPCInput("/rpc-test:xyz",
+		`{"rpc-test:input": {"x": 1}}`, ""))
+}
+
+func testRPCInput(path, data, expErr string) func(*testing.T) {
+	return func(t *testing.T) {
+		err := validateRPCInput(path, []byte(data))
+		if expErr == "" && err != nil {
+			t.Fatalf("Unexpected error; %v", err)
+		}
+		if expErr != "" && (err == nil || !strings.Contains(err.Error(), expErr)) {
+			t.Fatalf("Expected error with '%s'; found %v", expErr, err)
+		}
+	}
+}
+
+func TestValidateRPCInput_errorPath(t *testing.T) {
+	defer useYangSchema(loadTestYangSchema(t, rpcTestYang))()
+	err := validateRPCInput("/rpc-test:restart", []byte(`{"rpc-test:input": {"service": 1}}`))
+	if e, ok := err.(tlerr.InvalidArgsError); !ok || e.Path != "/rpc-test:restart/input/service" {
+		t.Fatalf("Expected InvalidArgsError with error path; found %#v", err)
+	}
+}
+
+func TestValidateRPCInput_multipleErrors(t *testing.T) {
+	defer useYangSchema(loadTestYangSchema(t, rpcTestYang))()
+	err := validateRPCInput("/rpc-test:restart", []byte(`{"rpc-test:input": {"mode": "hot", "after": "5"}}`))
+	errs, ok := err.(MultiError)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("Expected MultiError with 3 errors; found %#v", err)
+	}
+	for i, exp := range []string{"'after'", "'hot'", "'service'"} {
+		if !strings.Contains(errs[i].Error(), exp) {
+			t.Errorf("Expected error %d with '%s'; found %v", i, exp, errs[i])
+		}
+	}
+}
+
+func TestIsActionRequest(t *testing.T) {
+	s := newEmptyRouter()
+	var isAction bool
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		rc, r := GetContext(r)
+		isAction, _ = isActionRequest(r, getPathForTranslib(r, rc), []byte(`{"rpc-test:input":{}}`))
+	}
+	for _, p := range []string{
+		"/restconf/data/rpc-test:top/server={name}/reset",
+		"/restconf/data/rpc-test:top/server={name}",
+		"/restconf/data/rpc-test:top/server={name}/input",
+	} {
+		s.addRoute("action", "POST", p, handler)
+	}
+
+	verifyAction := func(path string, exp bool) {
+		t.Helper()
+		isAction = !exp
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", path, nil))
+		if isAction != exp {
+			t.Fatalf("isActionRequest returned %v for %s", isAction, path)
+		}
+	}
+
+	t.Run("schema", func(t *testing.T) {
+		defer useYangSchema(loadTestYangSchema(t, rpcTestYang))()
+		verifyAction("/restconf/data/rpc-test:top/server=s1/reset", true)
+		verifyAction("/restconf/data/rpc-test:top/server=s1", false)
+	})
+
+	t.Run("no_schema", func(t *testing.T) {
+		defer useYangSchema(nil)()
+		verifyAction("/restconf/data/rpc-test:top/server=s1/reset", true)
+		verifyAction("/restconf/data/rpc-test:top/server=s1", false)
+	})
+}
+
+func TestProcessAction_schemaLoading(t *testing.T) {
+	b := &recordingBackend{}
+	defer useBackend("/restconf/data/rpc-test:", b)()
+	defer useYangSchema(nil)()
+
+	// Simulate YANG schema loading in progress
+	getYangSchema()
+	<-yangSchemaReady
+	ready := make(chan struct{})
+	yangSchemaReady = ready
+	defer func() { yangSchemaReady = closedChan() }()
+
+	s := newEmptyRouter()
+	s.addRoute("reset", "POST", "/restconf/data/rpc-test:top/server={name}/reset", Process)
+
+	s.addRoute("server", "POST", "/restconf/data/rpc-test:top", Process)
+
+	r := prepareRequest(t, "POST", "/restconf/data/rpc-test:top/server=s1/reset", `{"rpc-test:input":{}}`)
+	ctx, cancel := context.WithCancel(r.Context())
+	cancel()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r.WithContext(ctx))
+	verifyResponse(t, w, 503)
+	if b.method != "" {
+		t.Fatalf("Backend called with method %s", b.method)
+	}
+
+	// Data resource creation should not wait for the schema
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "POST", "/restconf/data/rpc-test:top", `{"rpc-test:server":[{"name":"s1"}]}`))
+	verifyResponse(t, w, 201)
+	verifyBackendRequest(t, b, "Create", "/rpc-test:top", `{"rpc-test:server":[{"name":"s1"}]}`)
+
+	// Request should be processed after loading ends
+	go func() {
+		theYangSchema.Store(loadTestYangSchema(t, rpcTestYang))
+		close(ready)
+	}()
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "POST", "/restconf/data/rpc-test:top/server=s1/reset", `{"rpc-test:input":{}}`))
+	verifyResponse(t, w, 204)
+	verifyBackendRequest(t, b, "Action", "/rpc-test:top/server[name=s1]/reset", `{"rpc-test:input":{}}`)
+}
+
+func closedChan() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
+
+func TestProcessAction(t *testing.T) {
+	b := &recordingBackend{}
+	defer useBackend("/restconf/data/rpc-test:", b)()
+	defer useYangSchema(loadTestYangSchema(t, rpcTestYang))()
+
+	s := newEmptyRouter()
+	s.addRoute("reset", "POST", "/restconf/data/rpc-test:top/server={name}/reset", Process)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "POST", "/restconf/data/rpc-test:top/server=s1/reset",
+		`{"rpc-test:input":{"delay":5}}`))
+	verifyResponse(t, w, 204)
+	verifyBackendRequest(t, b, "Action", "/rpc-test:top/server[name=s1]/reset", `{"rpc-test:input":{"delay":5}}`)
+
+	b.resp = []byte(`{"status":"ok"}`)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "POST", "/restconf/data/rpc-test:top/server=s1/reset", ""))
+	verifyResponseData(t, w, 200, jsonObj{"rpc-test:output": map[string]interface{}{"status": "ok"}})
+
+	// Input is validated only if yang_validation is enabled
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "POST", "/restconf/data/rpc-test:top/server=s1/reset",
+		`{"rpc-test:input":{"delay":"x"}}`))
+	verifyResponse(t, w, 200)
+
+	defer func(v bool) { yangValidation = v }(yangValidation)
+	yangValidation = true
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "POST", "/restconf/data/rpc-test:top/server=s1/reset",
+		`{"rpc-test:input":{"delay":"x"}}`))
+	verifyResponse(t, w, 400)
+}
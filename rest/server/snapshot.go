@@ -0,0 +1,662 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/sonic-mgmt-common/translib"
+	"github.com/Azure/sonic-mgmt-common/translib/tlerr"
+	"github.com/openconfig/goyang/pkg/yang"
+)
+
+// Configuration snapshot settings
+var (
+	// snapshotDir is the directory in which the snapshots are saved;
+	// one json file per snapshot.
+	snapshotDir = "/var/lib/rest-server/snapshots"
+
+	// snapshotPaths are the RESTCONF data paths included in a snapshot,
+	// if the create-snapshot input does not specify any.
+	snapshotPaths string
+)
+
+func init() {
+	flag.StringVar(&snapshotDir, "snapshot_dir", snapshotDir,
+		"Directory for saving the configuration snapshots")
+	flag.StringVar(&snapshotPaths, "snapshot_paths", snapshotPaths,
+		"Comma separated RESTCONF data paths included in configuration snapshots by default")
+
+	AddRoute("createSnapshot", "POST", "/restconf/operations/sonic-rest-server:create-snapshot", createSnapshotHandler, ReadOnlyAllowed())
+	AddRoute("listSnapshots", "POST", "/restconf/operations/sonic-rest-server:list-snapshots", listSnapshotsHandler, ReadOnlyAllowed())
+	AddRoute("diffSnapshot", "POST", "/restconf/operations/sonic-rest-server:diff-snapshot", diffSnapshotHandler, ReadOnlyAllowed())
+	AddRoute("restoreSnapshot", "POST", "/restconf/operations/sonic-rest-server:restore-snapshot", restoreSnapshotHandler)
+	AddRoute("deleteSnapshot", "POST", "/restconf/operations/sonic-rest-server:delete-snapshot", deleteSnapshotHandler, ReadOnlyAllowed())
+}
+
+// snapshotNameExpr is the pattern for snapshot names. Names are used
+// as file names; hence restricted to a safe character set.
+var snapshotNameExpr = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// snapshotMu serializes the changes to the snapshot directory.
+var snapshotMu sync.Mutex
+
+// configSnapshot is a named copy of the configuration data at one or more
+// paths. It is also the json representation of the snapshot file.
+type configSnapshot struct {
+	Name       string         `json:"name"`
+	Comment    string         `json:"comment,omitempty"`
+	User       string         `json:"user,omitempty"`
+	CreateTime string         `json:"create-time"`
+	Data       []snapshotData `json:"data"`
+}
+
+// snapshotData is the configuration data of one path in a snapshot.
+// Payload is the RFC7951 json returned by the Backend Get; and is empty
+// if the path did not exist when the snapshot was created.
+type snapshotData struct {
+	Path         string          `json:"path"` // RESTCONF data path
+	TranslibPath string          `json:"translib-path"`
+	Payload      json.RawMessage `json:"payload,omitempty"`
+}
+
+// snapshotInfo is the json representation of a snapshot in the
+// list-snapshots output.
+type snapshotInfo struct {
+	Name       string   `json:"name"`
+	Comment    string   `json:"comment,omitempty"`
+	User       string   `json:"user,omitempty"`
+	CreateTime string   `json:"create-time"`
+	Paths      []string `json:"path"`
+}
+
+// snapshotDiff is a difference between two configuration data trees.
+// Path is an instance-identifier of the changed node. Change is "added",
+// "removed" or "modified".
+type snapshotDiff struct {
+	Path     string      `json:"path"`
+	Change   string      `json:"change"`
+	OldValue interface{} `json:"old-value,omitempty"`
+	NewValue interface{} `json:"new-value,omitempty"`
+}
+
+// snapshotInput is the input of the snapshot RPCs. Eg:
+//
+//	{"sonic-rest-server:input": {
+//	  "name": "before-upgrade",
+//	  "comment": "ACL and interface config",
+//	  "path": ["/restconf/data/openconfig-acl:acl", "/restconf/data/openconfig-interfaces:interfaces"]
+//	}}
+//
+// CompareTo is used by diff-snapshot only; current configuration is
+// compared with the snapshot if it is empty.
+type snapshotInput struct {
+	Name      string   `json:"name"`
+	Comment   string   `json:"comment"`
+	Paths     []string `json:"path"`
+	CompareTo string   `json:"compare-to"`
+}
+
+// snapshotFile returns the file path of the snapshot.
+func snapshotFile(name string) string {
+	return filepath.Join(snapshotDir, name+".json")
+}
+
+// checkSnapshotName validates a snapshot name.
+func checkSnapshotName(name string) error {
+	if !snapshotNameExpr.MatchString(name) {
+		return httpBadRequest("Invalid snapshot name '%s'", name)
+	}
+	return nil
+}
+
+// loadSnapshot reads the snapshot from its file.
+func loadSnapshot(rc *RequestContext, name string) (*configSnapshot, error) {
+	if err := checkSnapshotName(name); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(snapshotFile(name))
+	if os.IsNotExist(err) {
+		return nil, tlerr.NotFound("Snapshot '%s' not found", name)
+	}
+
+	var s configSnapshot
+	if err == nil {
+		err = json.Unmarshal(data, &s)
+	}
+	if err != nil {
+		requestLog(rc).Errorf("Failed to load snapshot '%s'; %v", name, err)
+		return nil, httpServerError("Failed to load snapshot '%s'", name)
+	}
+
+	return &s, nil
+}
+
+// snapshotBackend returns the Backend of the data route matching the
+// RESTCONF path uri.
+func snapshotBackend(r *http.Request, uri string) (Backend, error) {
+	match, err := matchDataRoute(r, cleanPath(uri))
+	if err != nil {
+		return nil, err
+	}
+	return getBackend(match.path), nil
+}
+
+// getConfigData reads the configuration data of a snapshot path through
+// its Backend. Returns nil data if the path does not exist.
+func getConfigData(r *http.Request, rc *RequestContext, d *snapshotData, version translib.Version) ([]byte, error) {
+	b, err := snapshotBackend(r, d.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	args := translibArgs{method: "GET", path: d.TranslibPath, content: "config", version: version}
+	_, data, err := invokeBackend(r.Context(), b, &args, rc)
+	if isNotFoundError(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// createSnapshotHandler serves the create-snapshot RPC, which saves the
+// configuration data at the given paths (or the snapshot_paths) as a
+// named snapshot. Fails if a snapshot with the same name exists.
+func createSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	rc, r := GetContext(r)
+	var input snapshotInput
+	var args translibArgs
+	err := parseServerRPCInput(r, &input)
+	if err == nil {
+		err = checkSnapshotName(input.Name)
+	}
+	if err == nil {
+		err = args.parseClientVersion(r, rc)
+	}
+
+	paths := input.Paths
+	if err == nil && len(paths) == 0 {
+		for _, p := range strings.Split(snapshotPaths, ",") {
+			if p = strings.TrimSpace(p); len(p) != 0 {
+				paths = append(paths, p)
+			}
+		}
+		if len(paths) == 0 {
+			err = httpBadRequest("No paths specified for the snapshot")
+		}
+	}
+
+	snap := configSnapshot{
+		Name:       input.Name,
+		Comment:    input.Comment,
+		User:       rc.Username,
+		CreateTime: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	for _, uri := range paths {
+		if err != nil {
+			break
+		}
+
+		d := snapshotData{Path: uri}
+		if d.TranslibPath, err = resolveTranslibPath(r, rc, uri); err != nil {
+			break
+		}
+		if d.TranslibPath == "/" {
+			err = httpBadRequest("Snapshot of the datastore root is not supported")
+			break
+		}
+		d.Payload, err = getConfigData(r, rc, &d, args.version)
+		snap.Data = append(snap.Data, d)
+	}
+
+	if err == nil {
+		err = saveSnapshot(rc, &snap)
+	}
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	requestLog(rc).with("paths", len(snap.Data)).Infof("Created snapshot '%s'", snap.Name)
+	data, _ := json.Marshal(map[string]interface{}{"sonic-rest-server:output": snap.info()})
+	w.Header().Set("Content-Type", mimeYangDataJSON)
+	w.Write(data)
+}
+
+// saveSnapshot writes the snapshot to a new file. Fails if the
+// snapshot file exists already.
+func saveSnapshot(rc *RequestContext, s *configSnapshot) error {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+
+	file := snapshotFile(s.Name)
+	if _, err := os.Stat(file); err == nil {
+		return tlerr.AlreadyExists("Snapshot '%s' already exists", s.Name)
+	}
+
+	data, _ := json.Marshal(s)
+	if err := writeFileAtomic(file, data); err != nil {
+		requestLog(rc).Errorf("Failed to save snapshot '%s'; %v", s.Name, err)
+		return httpServerError("Failed to save snapshot '%s'", s.Name)
+	}
+	return nil
+}
+
+// info returns the snapshotInfo for the snapshot.
+func (s *configSnapshot) info() snapshotInfo {
+	info := snapshotInfo{
+		Name:       s.Name,
+		Comment:    s.Comment,
+		User:       s.User,
+		CreateTime: s.CreateTime,
+	}
+	for _, d := range s.Data {
+		info.Paths = append(info.Paths, d.Path)
+	}
+	return info
+}
+
+// listSnapshotsHandler serves the list-snapshots RPC, which returns
+// the info of all snapshots in the order of creation.
+func listSnapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	rc, r := GetContext(r)
+	files, _ := filepath.Glob(filepath.Join(snapshotDir, "*.json"))
+	var output struct {
+		Snapshot []snapshotInfo `json:"snapshot"`
+	}
+
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".json")
+		if s, err := loadSnapshot(rc, name); err == nil {
+			output.Snapshot = append(output.Snapshot, s.info())
+		}
+	}
+
+	sort.SliceStable(output.Snapshot, func(i, j int) bool {
+		return output.Snapshot[i].CreateTime < output.Snapshot[j].CreateTime
+	})
+
+	data, _ := json.Marshal(map[string]interface{}{"sonic-rest-server:output": &output})
+	w.Header().Set("Content-Type", mimeYangDataJSON)
+	w.Write(data)
+}
+
+// deleteSnapshotHandler serves the delete-snapshot RPC.
+func deleteSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	rc, r := GetContext(r)
+	var input snapshotInput
+	err := parseServerRPCInput(r, &input)
+	if err == nil {
+		_, err = loadSnapshot(rc, input.Name)
+	}
+	if err == nil {
+		snapshotMu.Lock()
+		if os.Remove(snapshotFile(input.Name)) != nil {
+			err = httpServerError("Failed to delete snapshot '%s'", input.Name)
+		}
+		snapshotMu.Unlock()
+	}
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	requestLog(rc).Infof("Deleted snapshot '%s'", input.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// diffSnapshotHandler serves the diff-snapshot RPC, which returns the
+// differences between a snapshot and the current configuration; or
+// another snapshot given as "compare-to". Differences are reported from
+// the snapshot to the current configuration (or the other snapshot).
+func diffSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	rc, r := GetContext(r)
+	var input snapshotInput
+	var args translibArgs
+	var from, to *configSnapshot
+	err := parseServerRPCInput(r, &input)
+	if err == nil {
+		from, err = loadSnapshot(rc, input.Name)
+	}
+	if err == nil && len(input.CompareTo) != 0 {
+		to, err = loadSnapshot(rc, input.CompareTo)
+	} else if err == nil {
+		if err = args.parseClientVersion(r, rc); err == nil {
+			to, err = currentSnapshot(r, rc, from, args.version)
+		}
+	}
+
+	var diffs []snapshotDiff
+	if err == nil {
+		diffs, err = diffSnapshots(from, to)
+	}
+	if err != nil {
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	var output struct {
+		Difference []snapshotDiff `json:"difference"`
+	}
+	output.Difference = diffs
+	data, _ := json.Marshal(map[string]interface{}{"sonic-rest-server:output": &output})
+	w.Header().Set("Content-Type", mimeYangDataJSON)
+	w.Write(data)
+}
+
+// currentSnapshot reads the current configuration data of all the
+// paths in snapshot s.
+func currentSnapshot(r *http.Request, rc *RequestContext, s *configSnapshot, version translib.Version) (*configSnapshot, error) {
+	cur := &configSnapshot{CreateTime: time.Now().UTC().Format(time.RFC3339)}
+	for i := range s.Data {
+		d := &s.Data[i]
+		payload, err := getConfigData(r, rc, d, version)
+		if err != nil {
+			return nil, err
+		}
+		cur.Data = append(cur.Data, snapshotData{Path: d.Path, TranslibPath: d.TranslibPath, Payload: payload})
+	}
+	return cur, nil
+}
+
+// diffSnapshots compares the data of each path in snapshots from and to.
+// Paths present in only one of them are reported as added or removed.
+func diffSnapshots(from, to *configSnapshot) ([]snapshotDiff, error) {
+	var paths []string
+	fromData := make(map[string]json.RawMessage)
+	toData := make(map[string]json.RawMessage)
+	for _, d := range from.Data {
+		fromData[d.TranslibPath] = d.Payload
+		paths = append(paths, d.TranslibPath)
+	}
+	for _, d := range to.Data {
+		if _, ok := fromData[d.TranslibPath]; !ok {
+			paths = append(paths, d.TranslibPath)
+		}
+		toData[d.TranslibPath] = d.Payload
+	}
+
+	var sd snapshotDiffer
+	for _, path := range paths {
+		if err := sd.diffPayload(path, fromData[path], toData[path]); err != nil {
+			return nil, err
+		}
+	}
+	return sd.diffs, nil
+}
+
+// snapshotDiffer computes the differences between json data trees. It
+// uses the YANG schema, if loaded, to match the list entries by their
+// keys. Lists are compared as a whole otherwise, like leaf-lists.
+type snapshotDiffer struct {
+	diffs []snapshotDiff
+}
+
+// diffPayload compares the Backend Get responses of a translib path.
+func (sd *snapshotDiffer) diffPayload(path string, from, to json.RawMessage) error {
+	var a, b map[string]interface{}
+	if err := decodeSnapshotPayload(from, &a); err != nil {
+		return err
+	}
+	if err := decodeSnapshotPayload(to, &b); err != nil {
+		return err
+	}
+
+	// Payload members are the target node itself; hence the differences
+	// are tracked from the parent of the target.
+	var parent *yang.Entry
+	if s := getYangSchema(); s != nil {
+		if target := s.find(path); target != nil {
+			parent = dataParent(target)
+		}
+	}
+
+	elems := splitTranslibPath(path)
+	base := ""
+	if len(elems) > 1 {
+		base = "/" + strings.Join(elems[:len(elems)-1], "/")
+	}
+
+	sd.container(parent, base, a, b)
+	return nil
+}
+
+// decodeSnapshotPayload decodes a json payload, if not empty.
+func decodeSnapshotPayload(data json.RawMessage, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return httpServerError("Invalid snapshot data; %v", err)
+	}
+	return nil
+}
+
+// set fills the difference details for the node at translib path.
+func (d *snapshotDiff) set(path, change string, from, to interface{}) {
+	d.Path, d.Change, d.OldValue, d.NewValue = toInstanceID(path), change, from, to
+}
+
+// value compares the values of data node e at translib path.
+func (sd *snapshotDiffer) value(e *yang.Entry, path string, from, to interface{}) {
+	var d snapshotDiff
+	switch {
+	case from == nil && to == nil:
+		return
+	case from == nil:
+		d.set(path, "added", nil, to)
+	case to == nil:
+		d.set(path, "removed", from, nil)
+	default:
+		a, aok := from.(map[string]interface{})
+		b, bok := to.(map[string]interface{})
+		if aok && bok {
+			sd.container(e, path, a, b)
+			return
+		}
+		if e != nil && e.IsList() && sd.list(e, path, from, to) {
+			return
+		}
+		if reflect.DeepEqual(from, to) {
+			return
+		}
+		d.set(path, "modified", from, to)
+	}
+	sd.diffs = append(sd.diffs, d)
+}
+
+// container compares the members of json objects a and b, which are
+// the values of data node e at translib path.
+func (sd *snapshotDiffer) container(e *yang.Entry, path string, a, b map[string]interface{}) {
+	names := sortedKeys(a)
+	for _, name := range sortedKeys(b) {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		var c *yang.Entry
+		if e != nil {
+			c = dataChild(e, localName(name))
+		}
+		sd.value(c, path+"/"+name, a[name], b[name])
+	}
+}
+
+// list compares the entries of list e, matching them by the keys.
+// Returns false if the entries cannot be matched; like when a key
+// value is missing.
+func (sd *snapshotDiffer) list(e *yang.Entry, path string, from, to interface{}) bool {
+	a, aok := from.([]interface{})
+	b, bok := to.([]interface{})
+	if !aok || !bok || len(e.Key) == 0 {
+		return false
+	}
+
+	index := func(list []interface{}) ([]string, map[string]interface{}) {
+		var keys []string
+		entries := make(map[string]interface{})
+		for _, x := range list {
+			k := listKeyPredicates(e, x.(map[string]interface{}))
+			if _, dup := entries[k]; len(k) == 0 || dup {
+				return nil, nil
+			}
+			keys = append(keys, k)
+			entries[k] = x
+		}
+		return keys, entries
+	}
+
+	for _, x := range append(append([]interface{}{}, a...), b...) {
+		if _, ok := x.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+
+	aKeys, aEntries := index(a)
+	bKeys, bEntries := index(b)
+	if aEntries == nil || bEntries == nil {
+		return false
+	}
+
+	for _, k := range aKeys {
+		sd.value(e, path+k, aEntries[k], bEntries[k])
+	}
+	for _, k := range bKeys {
+		if _, ok := aEntries[k]; !ok {
+			sd.value(e, path+k, nil, bEntries[k])
+		}
+	}
+	return true
+}
+
+// restoreSnapshotHandler serves the restore-snapshot RPC, which replaces
+// the configuration data at each path of the snapshot with the snapshot
+// data; or deletes it if the path did not exist in the snapshot. All
+// paths are restored in one transaction; hence they should be served
+// by the same Backend, which should support BulkBackend interface.
+func restoreSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	rc, r := GetContext(r)
+	var input snapshotInput
+	var args translibArgs
+	var snap *configSnapshot
+	err := parseServerRPCInput(r, &input)
+	if err == nil {
+		err = args.parseClientVersion(r, rc)
+	}
+	if err == nil {
+		snap, err = loadSnapshot(rc, input.Name)
+	}
+	var endWrite func()
+	if err == nil {
+		var paths []string
+		for _, d := range snap.Data {
+			paths = append(paths, d.TranslibPath)
+		}
+		endWrite, err = startConfigWrite(r, rc, paths...)
+	}
+	if err == nil {
+		defer endWrite()
+		err = restoreSnapshot(r, rc, snap, args.version)
+	}
+	if err != nil {
+		requestLog(rc).Warningf("Failed to restore snapshot '%s'; %v", input.Name, err)
+		writeErrorResponse(w, r, err)
+		return
+	}
+
+	requestLog(rc).Infof("Restored snapshot '%s'", snap.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreSnapshot replaces the configuration with the snapshot data,
+// through one BulkBackend transaction.
+func restoreSnapshot(r *http.Request, rc *RequestContext, snap *configSnapshot, version translib.Version) error {
+	var bb BulkBackend
+	for i := range snap.Data {
+		b, err := snapshotBackend(r, snap.Data[i].Path)
+		if err != nil {
+			return err
+		}
+		x, ok := b.(BulkBackend)
+		if !ok || (bb != nil && x != bb) {
+			return tlerr.NotSupported("Snapshot '%s' cannot be restored in one transaction", snap.Name)
+		}
+		bb = x
+	}
+
+	// Deletes are ordered before the replaces, as required by translib
+	// bulk API. Paths which do not exist now need not be deleted.
+	var ops []BulkOperation
+	var opData []*snapshotData
+	for _, method := range []string{"DELETE", "PUT"} {
+		for i := range snap.Data {
+			d := &snap.Data[i]
+			if (len(d.Payload) == 0) != (method == "DELETE") {
+				continue
+			}
+			if method == "DELETE" {
+				data, err := getConfigData(r, rc, d, version)
+				if err != nil {
+					return err
+				}
+				if data == nil {
+					continue
+				}
+			}
+
+			ops = append(ops, BulkOperation{Method: method, Request: BackendRequest{
+				Context:       r.Context(),
+				RequestID:     rc.ID,
+				Path:          d.TranslibPath,
+				Payload:       d.Payload,
+				User:          rc.Username,
+				ClientVersion: version,
+			}})
+			opData = append(opData, d)
+		}
+	}
+
+	if len(ops) == 0 {
+		return nil
+	}
+
+	index, err := bb.Bulk(ops)
+	if err != nil && index >= 0 && index < len(opData) {
+		requestLog(rc).Warningf("Restore failed for %s", opData[index].Path)
+	}
+	return err
+}
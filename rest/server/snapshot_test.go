@@ -0,0 +1,322 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Azure/sonic-mgmt-common/translib/tlerr"
+)
+
+// useSnapshotDir creates a temporary snapshot directory. Returns a
+// function to remove it and restore the original directory.
+func useSnapshotDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "restsnap")
+	if err != nil {
+		t.Fatalf("TempDir failed; %v", err)
+	}
+
+	orig := snapshotDir
+	snapshotDir = dir
+	return func() {
+		snapshotDir = orig
+		os.RemoveAll(dir)
+	}
+}
+
+func newSnapshotTestRouter() *Router {
+	s := newEmptyRouter()
+	s.addRoute("createSnapshot", "POST", "/restconf/operations/sonic-rest-server:create-snapshot", createSnapshotHandler)
+	s.addRoute("listSnapshots", "POST", "/restconf/operations/sonic-rest-server:list-snapshots", listSnapshotsHandler)
+	s.addRoute("diffSnapshot", "POST", "/restconf/operations/sonic-rest-server:diff-snapshot", diffSnapshotHandler)
+	s.addRoute("restoreSnapshot", "POST", "/restconf/operations/sonic-rest-server:restore-snapshot", restoreSnapshotHandler)
+	s.addRoute("deleteSnapshot", "POST", "/restconf/operations/sonic-rest-server:delete-snapshot", deleteSnapshotHandler)
+	s.addRoute("top", "GET", "/restconf/data/payload-test:top", Process)
+	s.addRoute("server", "GET", "/restconf/data/payload-test:top/server={name}", Process)
+	return s
+}
+
+// snapshotRPC invokes a snapshot RPC with given input and verifies the
+// response status. Returns the response body.
+func snapshotRPC(t *testing.T, s *Router, rpc, input string, expStatus int) []byte {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "POST", "/restconf/operations/sonic-rest-server:"+rpc,
+		`{"sonic-rest-server:input": `+input+`}`))
+	verifyResponse(t, w, expStatus)
+	return w.Body.Bytes()
+}
+
+// diffTestSnapshot invokes the diff-snapshot RPC and returns the
+// differences in "change path" format.
+func diffTestSnapshot(t *testing.T, s *Router, input string) []string {
+	t.Helper()
+	var resp map[string]struct {
+		Difference []snapshotDiff `json:"difference"`
+	}
+	json.Unmarshal(snapshotRPC(t, s, "diff-snapshot", input, 200), &resp)
+
+	var diffs []string
+	for _, d := range resp["sonic-rest-server:output"].Difference {
+		diffs = append(diffs, d.Change+" "+d.Path)
+	}
+	return diffs
+}
+
+func verifyDiffs(t *testing.T, diffs []string, exp ...string) {
+	t.Helper()
+	if strings.Join(diffs, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("Expected differences:\n%s\nFound:\n%s", strings.Join(exp, "\n"), strings.Join(diffs, "\n"))
+	}
+}
+
+// writeMemData replaces the payload-test:top data in MemoryBackend b.
+func writeMemData(t *testing.T, b *MemoryBackend, data string) {
+	t.Helper()
+	_, err := b.Replace(BackendRequest{Path: "/payload-test:top", Payload: []byte(data)})
+	if err != nil {
+		t.Fatalf("Replace failed; %v", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	defer useSnapshotDir(t)()
+	defer useYangSchema(loadTestYangSchema(t, payloadTestYang))()
+	b := NewMemoryBackend()
+	b.SetListKeys("/top/server", "name")
+	defer useBackend("/restconf/data/payload-test:", b)()
+	s := newSnapshotTestRouter()
+
+	writeMemData(t, b, `{"payload-test:top": {"mtu": 1500,
+		"server": [{"name": "s1", "port": 80}, {"name": "s2", "port": 81}]}}`)
+
+	var out map[string]snapshotInfo
+	data := snapshotRPC(t, s, "create-snapshot",
+		`{"name": "snap1", "comment": "hii", "path": ["/restconf/data/payload-test:top"]}`, 200)
+	json.Unmarshal(data, &out)
+	if info := out["sonic-rest-server:output"]; info.Name != "snap1" || info.Comment != "hii" ||
+		len(info.Paths) != 1 || info.CreateTime == "" {
+		t.Fatalf("Unexpected create-snapshot output: %s", data)
+	}
+
+	t.Run("duplicate", func(t *testing.T) {
+		snapshotRPC(t, s, "create-snapshot", `{"name": "snap1", "path": ["/restconf/data/payload-test:top"]}`, 409)
+	})
+	t.Run("no_diff", func(t *testing.T) {
+		verifyDiffs(t, diffTestSnapshot(t, s, `{"name": "snap1"}`))
+	})
+
+	writeMemData(t, b, `{"payload-test:top": {"mtu": 9000, "name": "x",
+		"server": [{"name": "s3", "port": 80}, {"name": "s2", "port": 82}]}}`)
+	expDiffs := []string{
+		"modified /payload-test:top/mtu",
+		"removed /payload-test:top/server[name='s1']",
+		"modified /payload-test:top/server[name='s2']/port",
+		"added /payload-test:top/server[name='s3']",
+		"added /payload-test:top/name",
+	}
+
+	t.Run("diff_current", func(t *testing.T) {
+		verifyDiffs(t, diffTestSnapshot(t, s, `{"name": "snap1"}`), expDiffs...)
+	})
+	t.Run("diff_snapshots", func(t *testing.T) {
+		snapshotRPC(t, s, "create-snapshot", `{"name": "snap2", "path": ["/restconf/data/payload-test:top"]}`, 200)
+		verifyDiffs(t, diffTestSnapshot(t, s, `{"name": "snap1", "compare-to": "snap2"}`), expDiffs...)
+	})
+	t.Run("list", func(t *testing.T) {
+		var resp map[string]struct {
+			Snapshot []snapshotInfo `json:"snapshot"`
+		}
+		json.Unmarshal(snapshotRPC(t, s, "list-snapshots", `{}`, 200), &resp)
+		if list := resp["sonic-rest-server:output"].Snapshot; len(list) != 2 || list[0].Name != "snap1" || list[1].Name != "snap2" {
+			t.Fatalf("Unexpected snapshots: %+v", list)
+		}
+	})
+	t.Run("restore", func(t *testing.T) {
+		snapshotRPC(t, s, "restore-snapshot", `{"name": "snap1"}`, 204)
+		verifyDiffs(t, diffTestSnapshot(t, s, `{"name": "snap1"}`))
+	})
+	t.Run("delete", func(t *testing.T) {
+		snapshotRPC(t, s, "delete-snapshot", `{"name": "snap2"}`, 204)
+		snapshotRPC(t, s, "delete-snapshot", `{"name": "snap2"}`, 404)
+		snapshotRPC(t, s, "diff-snapshot", `{"name": "snap1", "compare-to": "snap2"}`, 404)
+	})
+}
+
+func TestSnapshot_missingPath(t *testing.T) {
+	defer useSnapshotDir(t)()
+	b := NewMemoryBackend()
+	defer useBackend("/restconf/data/payload-test:", b)()
+	s := newSnapshotTestRouter()
+
+	// Path does not exist; restore should delete it
+	snapshotRPC(t, s, "create-snapshot", `{"name": "empty", "path": ["/restconf/data/payload-test:top"]}`, 200)
+	writeMemData(t, b, `{"payload-test:top": {"mtu": 1500}}`)
+	verifyDiffs(t, diffTestSnapshot(t, s, `{"name": "empty"}`), "added /payload-test:top")
+
+	snapshotRPC(t, s, "restore-snapshot", `{"name": "empty"}`, 204)
+	if _, err := b.Get(BackendRequest{Path: "/payload-test:top"}); !isNotFoundError(err) {
+		t.Fatalf("Data not deleted by restore; err=%v", err)
+	}
+	snapshotRPC(t, s, "restore-snapshot", `{"name": "empty"}`, 204)
+}
+
+func TestSnapshot_noSchema(t *testing.T) {
+	defer useSnapshotDir(t)()
+	defer useYangSchema(nil)()
+	b := NewMemoryBackend()
+	defer useBackend("/restconf/data/payload-test:", b)()
+	s := newSnapshotTestRouter()
+
+	writeMemData(t, b, `{"payload-test:top": {"server": [{"name": "s1", "port": 80}]}}`)
+	snapshotRPC(t, s, "create-snapshot", `{"name": "snap1", "path": ["/restconf/data/payload-test:top"]}`, 200)
+	writeMemData(t, b, `{"payload-test:top": {"server": [{"name": "s1", "port": 81}]}}`)
+
+	// Lists are compared as a whole without the schema
+	verifyDiffs(t, diffTestSnapshot(t, s, `{"name": "snap1"}`), "modified /payload-test:top/server")
+}
+
+// missingPathBackend is a bulkTestBackend which reports the paths
+// in missing as not found.
+type missingPathBackend struct {
+	bulkTestBackend
+	missing map[string]bool
+}
+
+func (b *missingPathBackend) Get(req BackendRequest) (BackendResponse, error) {
+	if b.missing[req.Path] {
+		return BackendResponse{}, tlerr.NotFound("Resource not found")
+	}
+	return b.bulkTestBackend.Get(req)
+}
+
+func TestSnapshot_bulkRestore(t *testing.T) {
+	defer useSnapshotDir(t)()
+	b := &missingPathBackend{bulkTestBackend: bulkTestBackend{failIndex: -1}}
+	b.resp = []byte(`{"payload-test:top":{"mtu":1500}}`)
+	b.missing = map[string]bool{
+		"/payload-test:top/server[name=s1]": true,
+		"/payload-test:top/server[name=s2]": true,
+	}
+	defer useBackend("/restconf/data/payload-test:", b)()
+	s := newSnapshotTestRouter()
+
+	snapshotRPC(t, s, "create-snapshot", `{"name": "snap1", "path": ["/restconf/data/payload-test:top",
+		"/restconf/data/payload-test:top/server=s1", "/restconf/data/payload-test:top/server=s2"]}`, 200)
+
+	// s1 was created after the snapshot; should be deleted before the
+	// replace. s2 does not exist; should be skipped.
+	delete(b.missing, "/payload-test:top/server[name=s1]")
+	snapshotRPC(t, s, "restore-snapshot", `{"name": "snap1"}`, 204)
+	exp := []string{
+		"DELETE /payload-test:top/server[name=s1] ",
+		`PUT /payload-test:top {"payload-test:top":{"mtu":1500}}`,
+	}
+	if strings.Join(b.ops, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("Unexpected bulk operations: %q", b.ops)
+	}
+
+	b.failIndex = 1
+	snapshotRPC(t, s, "restore-snapshot", `{"name": "snap1"}`, 404)
+}
+
+// versionBulkBackend is a bulkTestBackend which records the client
+// version of the bulk operations.
+type versionBulkBackend struct {
+	bulkTestBackend
+	versions []string
+}
+
+func (b *versionBulkBackend) Bulk(ops []BulkOperation) (int, error) {
+	b.versions = nil
+	for _, op := range ops {
+		b.versions = append(b.versions, op.Request.ClientVersion.String())
+	}
+	return b.bulkTestBackend.Bulk(ops)
+}
+
+func TestSnapshot_restoreBackend(t *testing.T) {
+	defer useSnapshotDir(t)()
+	b1 := &versionBulkBackend{bulkTestBackend: bulkTestBackend{failIndex: -1}}
+	b1.resp = []byte(`{"payload-test:top":{"mtu":1500}}`)
+	b2 := &recordingBackend{resp: []byte(`{"payload-test:server":[{"name":"s1"}]}`)}
+	defer useBackend("/restconf/data/payload-test:", b1)()
+	defer useBackend("/restconf/data/payload-test:top/server={name}", b2)()
+	s := newSnapshotTestRouter()
+
+	// Backend is resolved from the route template
+	snapshotRPC(t, s, "create-snapshot", `{"name": "snap1", "path": ["/restconf/data/payload-test:top",
+		"/restconf/data/payload-test:top/server=s1"]}`, 200)
+	if b2.method != "Get" || b2.req.Path != "/payload-test:top/server[name=s1]" {
+		t.Fatalf("Server data not read from its backend; method=%s, path=%s", b2.method, b2.req.Path)
+	}
+
+	t.Run("not_bulk", func(t *testing.T) {
+		b1.ops, b2.method = nil, ""
+		snapshotRPC(t, s, "restore-snapshot", `{"name": "snap1"}`, 405)
+		if len(b1.ops) != 0 || b2.method != "" {
+			t.Fatalf("Failed restore modified the data; ops=%q, method=%s", b1.ops, b2.method)
+		}
+	})
+
+	t.Run("version", func(t *testing.T) {
+		snapshotRPC(t, s, "create-snapshot", `{"name": "snap2", "path": ["/restconf/data/payload-test:top"]}`, 200)
+		w := httptest.NewRecorder()
+		r := prepareRequest(t, "POST", "/restconf/operations/sonic-rest-server:restore-snapshot",
+			`{"sonic-rest-server:input": {"name": "snap2"}}`)
+		r.Header.Set("Accept-Version", "1.2.3")
+		s.ServeHTTP(w, r)
+		verifyResponse(t, w, 204)
+		if strings.Join(b1.versions, ",") != "1.2.3" {
+			t.Fatalf("Unexpected client versions %q", b1.versions)
+		}
+	})
+}
+
+func TestSnapshot_badInput(t *testing.T) {
+	defer useSnapshotDir(t)()
+	defer func(v string) { snapshotPaths = v }(snapshotPaths)
+	snapshotPaths = ""
+	s := newSnapshotTestRouter()
+
+	for name, input := range map[string]string{
+		"bad_name":  `{"name": "../x", "path": ["/restconf/data/payload-test:top"]}`,
+		"no_name":   `{"path": ["/restconf/data/payload-test:top"]}`,
+		"no_paths":  `{"name": "x"}`,
+		"root":      `{"name": "x", "path": ["/restconf/data"]}`,
+		"rpc_path":  `{"name": "x", "path": ["/restconf/operations/x:y"]}`,
+		"unknown":   `{"name": "x", "xyz": 1}`,
+		"not_array": `{"name": "x", "path": "/restconf/data/payload-test:top"}`,
+	} {
+		t.Run(name, func(t *testing.T) { snapshotRPC(t, s, "create-snapshot", input, 400) })
+	}
+
+	snapshotRPC(t, s, "diff-snapshot", `{"name": "xyz"}`, 404)
+	snapshotRPC(t, s, "restore-snapshot", `{"name": "xyz"}`, 404)
+	if files, _ := ioutil.ReadDir(snapshotDir); len(files) != 0 {
+		t.Fatalf("Unexpected snapshot files: %s", fmt.Sprint(files))
+	}
+}
@@ -0,0 +1,217 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Operation deadline configurations
+var (
+	// operationTimeout is the default deadline for serving a request;
+	// zero for no deadline.
+	operationTimeout time.Duration
+
+	// routeTimeouts are the deadlines for specific routes, indexed
+	// by route name. They override the operationTimeout.
+	routeTimeouts = routeTimeoutMap{}
+
+	// maxAbandonedGets is the maximum number of Get requests which
+	// can be running in background after their deadline.
+	maxAbandonedGets = 32
+
+	// abandonedGets is the number of Get requests running in background
+	// after their deadline. Accessed atomically.
+	abandonedGets int32
+)
+
+// States of a Get request run in background by callBackend
+const (
+	getRunning int32 = iota
+	getFinished
+	getAbandoned
+)
+
+func init() {
+	flag.DurationVar(&operationTimeout, "operation_timeout", operationTimeout,
+		"Maximum duration for serving a request; 0 for no limit")
+	flag.Var(routeTimeouts, "route_timeout",
+		"Maximum duration for serving the requests of a route, as name=duration. "+
+			"Can be repeated or comma separated. Overrides operation_timeout")
+	flag.IntVar(&maxAbandonedGets, "max_abandoned_gets", maxAbandonedGets,
+		"Maximum number of timed out GET requests that can be running in background. "+
+			"New GET requests with a deadline are rejected when reached")
+}
+
+// routeTimeoutMap is a flag.Value for route specific deadlines.
+// Accepts comma separated "name=duration" values. Eg:
+// "getAcl=30s,rpcImageInstall=10m".
+type routeTimeoutMap map[string]time.Duration
+
+func (m routeTimeoutMap) String() string {
+	var items []string
+	for name, d := range m {
+		items = append(items, name+"="+d.String())
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+func (m routeTimeoutMap) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return fmt.Errorf("invalid route timeout '%s'; should be name=duration", item)
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid duration for route '%s'", kv[0])
+		}
+		m[kv[0]] = d
+	}
+	return nil
+}
+
+// getOperationTimeout returns the deadline for serving the requests
+// of a route. Returns zero if there is no deadline.
+func getOperationTimeout(name string) time.Duration {
+	if d, ok := routeTimeouts[name]; ok {
+		return d
+	}
+	return operationTimeout
+}
+
+// timeoutMiddleware returns a handler which sets the operation deadline
+// of the route on the request context. Backend calls are bound by the
+// request context, as described in callBackend.
+func timeoutMiddleware(inner http.Handler, name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d := getOperationTimeout(name); d > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
+
+// callBackend invokes the function f of Backend b for the given method,
+// bound by the context ctx. Returns a httpError with 504 status if the
+// context deadline is exceeded; or with 503 status if the context was
+// cancelled, like when the client has closed the connection.
+//
+// Backends implementing ContextBackend are expected to stop and return
+// the context error when ctx is done; they are called synchronously.
+// Other backends (like translib) cannot be interrupted. Write requests
+// and actions to them always run to completion, even after the deadline;
+// otherwise the change could be committed after reporting a failure.
+// Get requests to them are not invoked if ctx is done already, and are
+// abandoned when ctx gets done - but they continue to run in background
+// till the backend returns; the results are discarded. At most
+// maxAbandonedGets such Get requests can be running; new ones are
+// rejected with 503 status till some of them complete.
+func callBackend(ctx context.Context, b Backend, method string, f func() (BackendResponse, error)) (BackendResponse, error) {
+	if ctx == nil || ctx.Done() == nil {
+		return f()
+	}
+
+	if cb, ok := b.(ContextBackend); ok && cb.HonoursContext() {
+		resp, err := f()
+		if err == context.DeadlineExceeded || err == context.Canceled {
+			err = contextError(err)
+		}
+		return resp, err
+	}
+
+	if method != "GET" && method != "HEAD" {
+		resp, err := f()
+		if ctx.Err() != nil {
+			glog.Warningf("%s request completed after the operation deadline or cancellation", method)
+		}
+		return resp, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return BackendResponse{}, contextError(err)
+	}
+	if n := atomic.LoadInt32(&abandonedGets); n >= int32(maxAbandonedGets) {
+		glog.Warningf("Rejecting %s request; %d timed out requests still running", method, n)
+		return BackendResponse{}, httpError(http.StatusServiceUnavailable,
+			"Too many timed out operations in progress")
+	}
+
+	type result struct {
+		resp BackendResponse
+		err  error
+	}
+
+	state := getRunning
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if !atomic.CompareAndSwapInt32(&state, getRunning, getFinished) {
+				atomic.AddInt32(&abandonedGets, -1)
+				glog.Infof("Abandoned %s request completed", method)
+			}
+		}()
+		defer func() {
+			if x := recover(); x != nil {
+				buf := make([]byte, 64<<10)
+				buf = buf[:runtime.Stack(buf, false)]
+				glog.Errorf("Runtime error: panic in backend; %v\n%s", x, buf)
+				done <- result{err: httpServerError("Internal error")}
+			}
+		}()
+		resp, err := f()
+		done <- result{resp, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.resp, res.err
+	case <-ctx.Done():
+		atomic.AddInt32(&abandonedGets, 1)
+		if !atomic.CompareAndSwapInt32(&state, getRunning, getAbandoned) {
+			// Completed meanwhile
+			atomic.AddInt32(&abandonedGets, -1)
+			res := <-done
+			return res.resp, res.err
+		}
+		return BackendResponse{}, contextError(ctx.Err())
+	}
+}
+
+// contextError returns the httpError for a context error.
+func contextError(err error) error {
+	if err == context.DeadlineExceeded {
+		return httpError(http.StatusGatewayTimeout, "Operation timed out")
+	}
+	return httpError(http.StatusServiceUnavailable, "Operation cancelled")
+}
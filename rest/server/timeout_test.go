@@ -0,0 +1,261 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowBackend is a Backend whose Get, Replace and Update block till the
+// release channel is closed, ignoring the request context (like translib).
+// Get panics if the panicMsg is set.
+type slowBackend struct {
+	recordingBackend
+	release  chan struct{}
+	panicMsg string
+}
+
+func (b *slowBackend) Get(req BackendRequest) (BackendResponse, error) {
+	if b.panicMsg != "" {
+		panic(b.panicMsg)
+	}
+	<-b.release
+	return BackendResponse{Payload: []byte(`{}`)}, nil
+}
+
+func (b *slowBackend) Replace(req BackendRequest) (BackendResponse, error) {
+	<-b.release
+	return b.recordingBackend.Replace(req)
+}
+
+func (b *slowBackend) Update(req BackendRequest) (BackendResponse, error) {
+	<-b.release
+	return b.recordingBackend.Update(req)
+}
+
+// contextBackend is a ContextBackend whose Get blocks till the
+// request context is done.
+type contextBackend struct {
+	recordingBackend
+}
+
+func (b *contextBackend) HonoursContext() bool { return true }
+
+func (b *contextBackend) Get(req BackendRequest) (BackendResponse, error) {
+	<-req.Context.Done()
+	return BackendResponse{}, req.Context.Err()
+}
+
+// useOperationTimeouts overrides the operation_timeout and route_timeout
+// values. Returns a function to restore them.
+func useOperationTimeouts(d time.Duration, routes routeTimeoutMap) func() {
+	origTimeout, origRoutes := operationTimeout, routeTimeouts
+	operationTimeout, routeTimeouts = d, routes
+	return func() { operationTimeout, routeTimeouts = origTimeout, origRoutes }
+}
+
+func TestOperationTimeout(t *testing.T) {
+	b := &slowBackend{release: make(chan struct{})}
+	defer close(b.release)
+	defer useBackend("/restconf/data/timeout-test:", b)()
+	defer useOperationTimeouts(time.Hour, routeTimeoutMap{"slow": 20 * time.Millisecond})()
+
+	s := newEmptyRouter()
+	s.addRoute("slow", "GET", "/restconf/data/timeout-test:slow", Process)
+	s.addRoute("fast", "GET", "/restconf/data/timeout-test:fast", Process)
+
+	w := httptest.NewRecorder()
+	start := time.Now()
+	s.ServeHTTP(w, prepareRequest(t, "GET", "/timeout-test:slow", ""))
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("Route timeout not applied; request took %v", d)
+	}
+	verifyResponse(t, w, 504)
+	if body := w.Body.String(); !strings.Contains(body, `"error-tag":"operation-failed"`) {
+		t.Fatalf("Unexpected error response: %s", body)
+	}
+}
+
+func TestOperationTimeout_global(t *testing.T) {
+	b := &slowBackend{release: make(chan struct{})}
+	defer close(b.release)
+	defer useBackend("/restconf/data/timeout-test:", b)()
+	defer useOperationTimeouts(20*time.Millisecond, routeTimeoutMap{})()
+
+	s := newEmptyRouter()
+	s.addRoute("slow", "GET", "/restconf/data/timeout-test:slow", Process)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "GET", "/timeout-test:slow", ""))
+	verifyResponse(t, w, 504)
+}
+
+func TestOperationTimeout_none(t *testing.T) {
+	b := &slowBackend{release: make(chan struct{})}
+	defer useBackend("/restconf/data/timeout-test:", b)()
+	defer useOperationTimeouts(0, routeTimeoutMap{})()
+
+	s := newEmptyRouter()
+	s.addRoute("slow", "GET", "/restconf/data/timeout-test:slow", Process)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(b.release)
+	}()
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "GET", "/timeout-test:slow", ""))
+	verifyResponse(t, w, 200)
+}
+
+// waitAbandonedGets waits till the abandoned Get requests complete.
+func waitAbandonedGets(t *testing.T) {
+	for i := 0; atomic.LoadInt32(&abandonedGets) != 0; i++ {
+		if i == 100 {
+			t.Fatalf("Abandoned requests not completed; count=%d", atomic.LoadInt32(&abandonedGets))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOperationTimeout_abandonedLimit(t *testing.T) {
+	b := &slowBackend{release: make(chan struct{})}
+	defer useBackend("/restconf/data/timeout-test:", b)()
+	defer useOperationTimeouts(20*time.Millisecond, routeTimeoutMap{})()
+	defer func(n int) { maxAbandonedGets = n }(maxAbandonedGets)
+	maxAbandonedGets = 1
+	waitAbandonedGets(t) // of other tests
+
+	s := newEmptyRouter()
+	s.addRoute("slow", "GET", "/restconf/data/timeout-test:slow", Process)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "GET", "/timeout-test:slow", ""))
+	verifyResponse(t, w, 504)
+
+	// Rejected while the abandoned request is running
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "GET", "/timeout-test:slow", ""))
+	verifyResponse(t, w, 503)
+
+	close(b.release)
+	waitAbandonedGets(t)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "GET", "/timeout-test:slow", ""))
+	verifyResponse(t, w, 200)
+}
+
+func TestOperationTimeout_write(t *testing.T) {
+	b := &slowBackend{release: make(chan struct{})}
+	defer useBackend("/restconf/data/timeout-test:", b)()
+	defer useOperationTimeouts(20*time.Millisecond, routeTimeoutMap{})()
+
+	s := newEmptyRouter()
+	s.addRoute("slow", "PUT", "/restconf/data/timeout-test:slow", Process)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(b.release)
+	}()
+
+	// Writes are not abandoned after the deadline
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "PUT", "/timeout-test:slow", "{}"))
+	verifyResponse(t, w, 204)
+	if b.method != "Replace" {
+		t.Fatalf("Backend not invoked; found method '%s'", b.method)
+	}
+}
+
+func TestOperationTimeout_contextBackend(t *testing.T) {
+	b := &contextBackend{}
+	defer useBackend("/restconf/data/timeout-test:", b)()
+	defer useOperationTimeouts(20*time.Millisecond, routeTimeoutMap{})()
+
+	s := newEmptyRouter()
+	s.addRoute("slow", "GET", "/restconf/data/timeout-test:slow", Process)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "GET", "/timeout-test:slow", ""))
+	verifyResponse(t, w, 504)
+}
+
+func TestOperationCancel(t *testing.T) {
+	b := &recordingBackend{}
+	defer useBackend("/restconf/data/timeout-test:", b)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := httptest.NewRecorder()
+	r := prepareRequest(t, "GET", "/timeout-test:top", "").WithContext(ctx)
+	Process(w, r)
+	verifyResponse(t, w, 503)
+	if b.method != "" {
+		t.Fatalf("Backend %s invoked for a cancelled request", b.method)
+	}
+}
+
+func TestOperationPanic(t *testing.T) {
+	b := &slowBackend{panicMsg: "hii"}
+	defer useBackend("/restconf/data/timeout-test:", b)()
+	defer useOperationTimeouts(time.Hour, routeTimeoutMap{})()
+
+	s := newEmptyRouter()
+	s.addRoute("slow", "GET", "/restconf/data/timeout-test:slow", Process)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, prepareRequest(t, "GET", "/timeout-test:slow", ""))
+	verifyResponse(t, w, 500)
+}
+
+func TestRouteTimeoutFlag(t *testing.T) {
+	t.Run("one", testRouteTimeoutFlag("getAcl=30s", "getAcl=30s", false))
+	t.Run("multi", testRouteTimeoutFlag("getAcl=30s, rpcImageInstall=10m", "getAcl=30s,rpcImageInstall=10m0s", false))
+	t.Run("no_value", testRouteTimeoutFlag("getAcl", "", true))
+	t.Run("no_name", testRouteTimeoutFlag("=1s", "", true))
+	t.Run("bad_duration", testRouteTimeoutFlag("getAcl=30", "", true))
+	t.Run("negative", testRouteTimeoutFlag("getAcl=-1s", "", true))
+}
+
+func testRouteTimeoutFlag(value, exp string, expErr bool) func(*testing.T) {
+	return func(t *testing.T) {
+		m := routeTimeoutMap{}
+		err := m.Set(value)
+		if expErr {
+			if err == nil {
+				t.Fatalf("Set(%q) did not fail", value)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("Set(%q) failed; %v", value, err)
+		}
+		if s := m.String(); s != exp {
+			t.Fatalf("Expected '%s'; found '%s'", exp, s)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Tracing configurations
+var (
+	// traceExporter is the span exporter type -- "otlp" or "file".
+	// Tracing is disabled if it is empty.
+	traceExporter string
+
+	// traceEndpoint is the OTLP/HTTP traces endpoint URL
+	traceEndpoint = "http://localhost:4318/v1/traces"
+
+	// traceFile is the output file path for file exporter
+	traceFile = "/tmp/rest_server_traces.json"
+
+	// traceFlushInterval is the max duration spans are buffered
+	// before exporting.
+	traceFlushInterval = 5 * time.Second
+)
+
+func init() {
+	flag.StringVar(&traceExporter, "trace_exporter", "", "Trace exporter - otlp|file. Tracing is disabled if not set")
+	flag.StringVar(&traceEndpoint, "trace_endpoint", traceEndpoint, "OTLP/HTTP endpoint URL for exporting traces")
+	flag.StringVar(&traceFile, "trace_file", traceFile, "Output file for the file trace exporter")
+	flag.DurationVar(&traceFlushInterval, "trace_flush_interval", traceFlushInterval, "Trace export interval")
+}
+
+// OTLP span kind and status code values
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+
+	spanStatusUnset = 0
+	spanStatusOK    = 1
+	spanStatusError = 2
+)
+
+// span represents a traced operation. Follows OpenTelemetry span data
+// model, so that the spans can be exported in OTLP format.
+type span struct {
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	status   int
+	message  string
+	sampled  bool
+}
+
+// startSpan creates a new span for request r. The span will be child
+// of current span in the request's context, or the client provided
+// traceparent. Returns the new span and a copy of request r with the
+// new span as current span. Span will not be exported if tracing is
+// not enabled; but the returned span object can still be used.
+func startSpan(r *http.Request, name string) (*span, *http.Request) {
+	rc, r := GetContext(r)
+	sp := &span{
+		traceID: rc.TraceID,
+		spanID:  newSpanID(),
+		name:    name,
+		kind:    spanKindInternal,
+		start:   time.Now(),
+		sampled: rc.TraceFlags != "00" && getTracer() != nil,
+	}
+
+	if parent, ok := getContextValue(r, spanContextKey).(*span); ok {
+		sp.parentID = parent.spanID
+	} else {
+		sp.parentID = rc.ParentSpanID
+		sp.kind = spanKindServer
+	}
+
+	r = setContextValue(r, spanContextKey, sp)
+	return sp, r
+}
+
+// newSpanID returns a random 8 byte span id, hex encoded.
+func newSpanID() string {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		// Should not happen.. Fallback to time+counter based id
+		n := uint64(time.Now().UnixNano())<<16 | atomic.AddUint64(&requestCounter, 1)&0xffff
+		binary.BigEndian.PutUint64(id[:], n)
+	}
+
+	return hex.EncodeToString(id[:])
+}
+
+// setAttr sets a span attribute. Value can be a string, bool, int or
+// float64. Other types are converted to string.
+func (sp *span) setAttr(key string, value interface{}) {
+	if sp.attrs == nil {
+		sp.attrs = make(map[string]interface{})
+	}
+	sp.attrs[key] = value
+}
+
+// setError marks the span as failed due to error err.
+func (sp *span) setError(err error) {
+	sp.status = spanStatusError
+	sp.message = err.Error()
+}
+
+// setHTTPStatus sets the "http.status_code" attribute and marks the
+// span as failed if the status code indicates a server error.
+func (sp *span) setHTTPStatus(status int) {
+	sp.setAttr("http.status_code", status)
+	if status >= 500 {
+		sp.status = spanStatusError
+		sp.message = http.StatusText(status)
+	} else if sp.status == spanStatusUnset {
+		sp.status = spanStatusOK
+	}
+}
+
+// finish ends the span and queues it for exporting.
+func (sp *span) finish() {
+	sp.end = time.Now()
+	if sp.sampled {
+		getTracer().enqueue(sp)
+	}
+}
+
+// tracer collects finished spans and exports them in batches
+// through a spanWriter.
+type tracer struct {
+	mu     sync.Mutex
+	spans  []*span
+	writer spanWriter
+	flushC chan struct{}
+}
+
+// spanWriter writes an OTLP JSON encoded ExportTraceServiceRequest
+// message to a destination.
+type spanWriter func(data []byte) error
+
+// maxQueuedSpans is the number of buffered spans, which triggers an
+// immediate export. New spans are dropped if the buffer reaches
+// 4 times this value (export is not keeping up).
+const maxQueuedSpans = 512
+
+var (
+	theTracer  *tracer
+	tracerOnce sync.Once
+)
+
+// getTracer returns the tracer instance, as per the trace_* command line
+// parameters. Returns nil if tracing is disabled.
+func getTracer() *tracer {
+	tracerOnce.Do(func() {
+		var w spanWriter
+		switch traceExporter {
+		case "":
+			return
+		case "otlp":
+			glog.Infof("Exporting traces to %s", traceEndpoint)
+			w = newOTLPWriter(traceEndpoint)
+		case "file":
+			glog.Infof("Exporting traces to file %s", traceFile)
+			w = newFileWriter(traceFile)
+		default:
+			glog.Errorf("Unknown trace exporter '%s'; tracing disabled", traceExporter)
+			return
+		}
+
+		theTracer = newTracer(w)
+		go theTracer.run(traceFlushInterval)
+	})
+
+	return theTracer
+}
+
+// newTracer creates a tracer instance which uses spanWriter w
+// to export spans. Caller should start the export loop.
+func newTracer(w spanWriter) *tracer {
+	return &tracer{writer: w, flushC: make(chan struct{}, 1)}
+}
+
+// enqueue adds a finished span to the export buffer.
+func (t *tracer) enqueue(sp *span) {
+	t.mu.Lock()
+	n := len(t.spans)
+	if n < 4*maxQueuedSpans {
+		t.spans = append(t.spans, sp)
+	}
+	t.mu.Unlock()
+
+	if n+1 == maxQueuedSpans {
+		select {
+		case t.flushC <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run exports the buffered spans periodically. Never returns.
+func (t *tracer) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.flushC:
+		}
+
+		t.flush()
+	}
+}
+
+// flush exports all buffered spans.
+func (t *tracer) flush() {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(toOTLPTraces(spans))
+	if err == nil {
+		err = t.writer(data)
+	}
+	if err != nil {
+		glog.Warningf("Failed to export %d spans; %v", len(spans), err)
+	}
+}
+
+// newOTLPWriter returns a spanWriter to post spans to an OTLP/HTTP
+// collector endpoint using JSON encoding.
+func newOTLPWriter(endpoint string) spanWriter {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(data []byte) error {
+		resp, err := client.Post(endpoint, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("collector returned status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// newFileWriter returns a spanWriter to write spans to a file, one
+// OTLP JSON message per line. Useful for offline testing; the file can
+// be replayed into a collector through its OTLP JSON file receiver.
+func newFileWriter(filename string) spanWriter {
+	return func(data []byte) error {
+		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+
+		data = append(data, '\n')
+		_, err = f.Write(data)
+		if err1 := f.Close(); err == nil {
+			err = err1
+		}
+		return err
+	}
+}
+
+// OTLP JSON encoding types. See opentelemetry-proto's trace.proto and
+// the OTLP specification for JSON protobuf encoding rules.
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId,omitempty"`
+	Name         string         `json:"name"`
+	Kind         int            `json:"kind"`
+	StartTime    string         `json:"startTimeUnixNano"`
+	EndTime      string         `json:"endTimeUnixNano"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	Status       struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// toOTLPTraces converts spans into OTLP ExportTraceServiceRequest
+// message structure.
+func toOTLPTraces(spans []*span) *otlpTraces {
+	var ss otlpScopeSpans
+	ss.Scope.Name = "github.com/Azure/sonic-mgmt-framework/rest/server"
+
+	for _, sp := range spans {
+		osp := otlpSpan{
+			TraceID:      sp.traceID,
+			SpanID:       sp.spanID,
+			ParentSpanID: sp.parentID,
+			Name:         sp.name,
+			Kind:         sp.kind,
+			StartTime:    strconv.FormatInt(sp.start.UnixNano(), 10),
+			EndTime:      strconv.FormatInt(sp.end.UnixNano(), 10),
+		}
+		for k, v := range sp.attrs {
+			osp.Attributes = append(osp.Attributes, toOTLPKeyValue(k, v))
+		}
+		osp.Status.Code = sp.status
+		osp.Status.Message = sp.message
+		ss.Spans = append(ss.Spans, osp)
+	}
+
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{ss}}
+	rs.Resource.Attributes = []otlpKeyValue{
+		toOTLPKeyValue("service.name", "rest_server"),
+	}
+
+	return &otlpTraces{ResourceSpans: []otlpResourceSpans{rs}}
+}
+
+// toOTLPKeyValue creates an OTLP attribute object.
+func toOTLPKeyValue(k string, v interface{}) otlpKeyValue {
+	var av map[string]interface{}
+	switch v := v.(type) {
+	case string:
+		av = map[string]interface{}{"stringValue": v}
+	case bool:
+		av = map[string]interface{}{"boolValue": v}
+	case int:
+		av = map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case float64:
+		av = map[string]interface{}{"doubleValue": v}
+	default:
+		av = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+
+	return otlpKeyValue{Key: k, Value: av}
+}
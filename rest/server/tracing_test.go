@@ -0,0 +1,184 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// enableTestTracer installs a tracer which collects the exported OTLP
+// messages into a slice. Returns a function to disable it again.
+func enableTestTracer(out *[]otlpTraces) func() {
+	tracerOnce.Do(func() {})
+	theTracer = newTracer(func(data []byte) error {
+		var msg otlpTraces
+		err := json.Unmarshal(data, &msg)
+		*out = append(*out, msg)
+		return err
+	})
+
+	return func() { theTracer = nil }
+}
+
+func TestTracing_spans(t *testing.T) {
+	var exported []otlpTraces
+	defer enableTestTracer(&exported)()
+
+	s := newEmptyRouter()
+	s.addRoute("traceTest", "GET", "/restconf/data/api-tests:trace",
+		func(w http.ResponseWriter, r *http.Request) {
+			sp, _ := startSpan(r, "inner")
+			sp.finish()
+			w.WriteHeader(204)
+		})
+
+	r := httptest.NewRequest("GET", "/restconf/data/api-tests:trace", nil)
+	r.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+	theTracer.flush()
+
+	if len(exported) != 1 || len(exported[0].ResourceSpans) != 1 {
+		t.Fatalf("Expected 1 export message; found %v", exported)
+	}
+
+	spans := exported[0].ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans; found %d", len(spans))
+	}
+
+	inner, root := spans[0], spans[1]
+	if root.Name != "traceTest" || root.Kind != spanKindServer ||
+		root.ParentSpanID != "b7ad6b7169203331" {
+		t.Fatalf("Bad root span %+v", root)
+	}
+	if inner.Name != "inner" || inner.Kind != spanKindInternal ||
+		inner.ParentSpanID != root.SpanID {
+		t.Fatalf("Bad inner span %+v", inner)
+	}
+	for _, sp := range spans {
+		if sp.TraceID != "0af7651916cd43dd8448eb211c80319c" {
+			t.Fatalf("Bad trace-id in span %+v", sp)
+		}
+	}
+
+	attrs := make(map[string]map[string]interface{})
+	for _, kv := range root.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs["http.route"]["stringValue"] != "/restconf/data/api-tests:trace" ||
+		attrs["http.method"]["stringValue"] != "GET" ||
+		attrs["http.status_code"]["intValue"] != "204" {
+		t.Fatalf("Bad root span attributes %v", attrs)
+	}
+	if root.Status.Code != spanStatusOK {
+		t.Fatalf("Bad root span status %v", root.Status)
+	}
+}
+
+func TestTracing_notSampled(t *testing.T) {
+	var exported []otlpTraces
+	defer enableTestTracer(&exported)()
+
+	r := httptest.NewRequest("GET", "/restconf/data/api-tests:trace", nil)
+	r.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	sp, _ := startSpan(r, "test")
+	sp.finish()
+	theTracer.flush()
+
+	if len(exported) != 0 {
+		t.Fatalf("Unsampled span was exported: %v", exported)
+	}
+}
+
+func TestTracing_disabled(t *testing.T) {
+	tracerOnce.Do(func() {})
+	sp, _ := startSpan(httptest.NewRequest("GET", "/test", nil), "test")
+	sp.setAttr("key", "value")
+	sp.finish()
+
+	if sp.sampled {
+		t.Fatalf("Span should not be sampled when tracing is disabled")
+	}
+}
+
+func TestTracing_fileWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resttrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "trace.json")
+	tr := newTracer(newFileWriter(fname))
+	for i := 0; i < 2; i++ {
+		sp, _ := startSpan(httptest.NewRequest("GET", "/test", nil), "test")
+		tr.enqueue(sp)
+		tr.flush()
+	}
+
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := 0
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var msg otlpTraces
+		if err := dec.Decode(&msg); err != nil {
+			t.Fatalf("Bad trace file content; %v", err)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Fatalf("Expected 2 export messages in file; found %d", lines)
+	}
+}
+
+func TestNewSpanID(t *testing.T) {
+	id1, id2 := newSpanID(), newSpanID()
+	if len(id1) != 16 || isAllZeros(id1) || id1 == id2 {
+		t.Fatalf("Invalid span ids %s and %s", id1, id2)
+	}
+}
+
+func TestStatusWriter_flush(t *testing.T) {
+	var flushed bool
+	s := newEmptyRouter()
+	s.addRoute("flush", "GET", "/test/flush", func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if flushed = ok; ok {
+			f.Flush()
+		}
+	})
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/test/flush", nil))
+	if !flushed || !w.Flushed {
+		t.Fatalf("Response writer does not support http.Flusher")
+	}
+}
@@ -0,0 +1,625 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/Azure/sonic-mgmt-common/translib/tlerr"
+	"github.com/golang/glog"
+	"github.com/openconfig/goyang/pkg/yang"
+)
+
+// yangDir is the directory of YANG files served by the REST server.
+// They are used for identifying actions and sensitive data nodes; and
+// for validating request payloads and RPC inputs if yang_validation
+// is enabled.
+var yangDir = "/usr/models/yang"
+
+func init() {
+	flag.StringVar(&yangDir, "yang_dir", yangDir,
+		"Directory of YANG files, for validating requests and masking sensitive data in logs; empty to disable")
+}
+
+// yangSchema holds the YANG schema tree of all modules.
+type yangSchema struct {
+	modules    map[string]*yang.Entry // module entries, indexed by name
+	prefixes   map[string]*yang.Entry // module entries, indexed by prefix
+	namespaces map[string]string      // module names, indexed by namespace
+}
+
+var (
+	theYangSchema   atomic.Value // holds *yangSchema
+	yangInitOnce    sync.Once
+	yangSchemaReady = make(chan struct{}) // closed after loading ends
+)
+
+// getYangSchema returns the schema loaded from yang_dir. YANG files are
+// loaded in background during the first call; returns nil till then or
+// if loading fails. Use waitYangSchema to wait for the loading to end.
+func getYangSchema() *yangSchema {
+	yangInitOnce.Do(func() {
+		theYangSchema.Store((*yangSchema)(nil))
+		if yangDir == "" {
+			close(yangSchemaReady)
+			return
+		}
+
+		go func() {
+			defer close(yangSchemaReady)
+			s, err := loadYangSchema(yangDir)
+			if err != nil {
+				glog.Errorf("Failed to load YANG schema; %v", err)
+				return
+			}
+
+			theYangSchema.Store(s)
+			glog.Infof("Loaded %d YANG modules", len(s.modules))
+		}()
+	})
+
+	return theYangSchema.Load().(*yangSchema)
+}
+
+// waitYangSchema waits for the background loading of YANG schema to end
+// and returns the schema; nil if loading failed or yang_dir is not set.
+// Returns a 503 error if ctx is done before that.
+func waitYangSchema(ctx context.Context) (*yangSchema, error) {
+	getYangSchema()
+	select {
+	case <-yangSchemaReady:
+		return getYangSchema(), nil
+	default:
+	}
+
+	glog.V(1).Infof("Waiting for YANG schema to load")
+	select {
+	case <-yangSchemaReady:
+		return getYangSchema(), nil
+	case <-ctx.Done():
+		return nil, httpError(http.StatusServiceUnavailable, "YANG schema is not loaded yet; try again later")
+	}
+}
+
+// loadYangModules reads and processes all YANG files in a directory.
+func loadYangModules(dir string) (ms *yang.Modules, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while processing YANG; %v", r)
+		}
+	}()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.yang"))
+	if err != nil || len(files) == 0 {
+		return nil, fmt.Errorf("no YANG files in '%s'", dir)
+	}
+
+	yang.AddPath(dir)
+	ms = yang.NewModules()
+	for _, f := range files {
+		if err := ms.Read(f); err != nil {
+			glog.V(1).Infof("Skipping %s; %v", f, err)
+		}
+	}
+
+	if errs := ms.Process(); len(errs) != 0 {
+		glog.V(1).Infof("YANG processing reported %d errors; first error: %v", len(errs), errs[0])
+	}
+
+	return ms, nil
+}
+
+// yangModulesCache holds the YANG modules loaded from each directory.
+// Both the yangSchema and the redaction rules are derived from the same
+// modules; hence the YANG files are parsed only once.
+var yangModulesCache = struct {
+	mu      sync.Mutex
+	entries map[string]*yangModulesEntry
+}{entries: make(map[string]*yangModulesEntry)}
+
+// yangModulesEntry is a yangModulesCache entry.
+type yangModulesEntry struct {
+	once sync.Once
+	ms   *yang.Modules
+	err  error
+}
+
+// getYangModules returns the YANG modules from a directory. Modules are
+// loaded during the first call for the directory; concurrent callers
+// wait for it to complete.
+func getYangModules(dir string) (*yang.Modules, error) {
+	dir = filepath.Clean(dir)
+	yangModulesCache.mu.Lock()
+	e := yangModulesCache.entries[dir]
+	if e == nil {
+		e = new(yangModulesEntry)
+		yangModulesCache.entries[dir] = e
+	}
+	yangModulesCache.mu.Unlock()
+
+	e.once.Do(func() { e.ms, e.err = loadYangModules(dir) })
+	return e.ms, e.err
+}
+
+// loadYangSchema creates a yangSchema from the YANG files in a directory.
+func loadYangSchema(dir string) (s *yangSchema, err error) {
+	ms, err := getYangModules(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while processing YANG; %v", r)
+		}
+	}()
+
+	s = &yangSchema{
+		modules:    make(map[string]*yang.Entry),
+		prefixes:   make(map[string]*yang.Entry),
+		namespaces: make(map[string]string),
+	}
+	for _, m := range ms.Modules {
+		e := yang.ToEntry(m)
+		s.modules[m.Name] = e
+		s.prefixes[m.GetPrefix()] = e
+		if m.Namespace != nil {
+			s.namespaces[m.Namespace.Name] = m.Name
+		}
+	}
+
+	return s, nil
+}
+
+// find returns the schema entry for a translib path. Returns nil if the
+// path is not valid. Module prefix is mandatory for the first element.
+func (s *yangSchema) find(path string) *yang.Entry {
+	var e *yang.Entry
+	for _, elem := range splitTranslibPath(path) {
+		name, _ := splitElemKeys(elem)
+		if e == nil {
+			k := len(name) - len(localName(name)) - 1
+			if k <= 0 || s.modules[name[:k]] == nil {
+				return nil
+			}
+			e = s.modules[name[:k]]
+		}
+
+		if e = dataChild(e, localName(name)); e == nil {
+			return nil
+		}
+	}
+
+	return e
+}
+
+// dataChild returns the child data node of e by name. Looks through the
+// choice and case nodes, which do not appear in data tree.
+func dataChild(e *yang.Entry, name string) *yang.Entry {
+	if c := e.Dir[name]; c != nil && !c.IsChoice() && !c.IsCase() {
+		return c
+	}
+	for _, c := range e.Dir {
+		if c.IsChoice() || c.IsCase() {
+			if x := dataChild(c, name); x != nil {
+				return x
+			}
+		}
+	}
+	return nil
+}
+
+// dataChildren returns all child data nodes of e, looking through the
+// choice and case nodes.
+func dataChildren(e *yang.Entry, children map[string]*yang.Entry) map[string]*yang.Entry {
+	if children == nil {
+		children = make(map[string]*yang.Entry)
+	}
+	for name, c := range e.Dir {
+		if c.IsChoice() || c.IsCase() {
+			dataChildren(c, children)
+		} else {
+			children[name] = c
+		}
+	}
+	return children
+}
+
+// isMandatory checks if a data node is marked as mandatory.
+func isMandatory(e *yang.Entry) bool {
+	if leaf, ok := e.Node.(*yang.Leaf); ok {
+		return leaf.Mandatory != nil && leaf.Mandatory.Name == "true"
+	}
+	return e.Mandatory == yang.TSTrue
+}
+
+// jsonValidator validates json data (decoded with UseNumber option)
+// against the schema entries, as per RFC7951 encoding rules.
+type jsonValidator struct {
+	schema *yangSchema
+
+	// merge indicates that the data will be merged with existing data,
+	// as in PATCH. Mandatory nodes and list keys need not be present.
+	merge bool
+}
+
+// numberString returns the string form of a 64 bit number json value.
+// RFC7951 encodes them as strings; but numbers are also accepted, as
+// translib does.
+func numberString(v interface{}) (string, bool) {
+	switch n := v.(type) {
+	case string:
+		return n, true
+	case json.Number:
+		return string(n), true
+	}
+	return "", false
+}
+
+// value validates a json value against the schema entry e. Path is the
+// instance path of the value, for error messages. Returns an
+// InvalidArgsError for the invalid node; or a MultiError if there are
+// more than one invalid nodes.
+func (jv jsonValidator) value(e *yang.Entry, v interface{}, path string) error {
+	switch {
+	case e.IsLeaf():
+		if reason := jv.leafValueError(e, e.Type, v); len(reason) != 0 {
+			return invalidNodeError(path, "Invalid value '%v' for '%s'; %s", v, e.Name, reason)
+		}
+
+	case e.IsLeafList(), e.IsList():
+		list, ok := v.([]interface{})
+		if !ok {
+			return invalidNodeError(path, "'%s' should be an array", e.Name)
+		}
+		var errs MultiError
+		for _, x := range list {
+			if e.IsList() {
+				entry, _ := x.(map[string]interface{})
+				errs = errs.append(jv.listEntry(e, x, path+listKeyPredicates(e, entry)))
+			} else if reason := jv.leafValueError(e, e.Type, x); len(reason) != 0 {
+				errs = errs.append(invalidNodeError(path, "Invalid value '%v' for '%s'; %s", x, e.Name, reason))
+			}
+		}
+		return errs.err()
+
+	default:
+		return jv.container(e, v, path)
+	}
+
+	return nil
+}
+
+// listEntry validates a list entry json object. All the key leaves
+// should be present, unless merging.
+func (jv jsonValidator) listEntry(e *yang.Entry, v interface{}, path string) error {
+	if err := jv.container(e, v, path); err != nil || jv.merge {
+		return err
+	}
+
+	var errs MultiError
+	m := v.(map[string]interface{})
+	for _, k := range strings.Fields(e.Key) {
+		if _, ok := m[k]; !ok && !hasPrefixedMember(m, k) {
+			errs = errs.append(invalidNodeError(path, "Key '%s' missing in '%s' entry", k, e.Name))
+		}
+	}
+	return errs.err()
+}
+
+func (jv jsonValidator) container(e *yang.Entry, v interface{}, path string) error {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return invalidNodeError(path, "'%s' should be an object", e.Name)
+	}
+
+	var errs MultiError
+	children := dataChildren(e, nil)
+	for _, name := range sortedKeys(m) {
+		if c := children[localName(name)]; c == nil {
+			errs = errs.append(invalidNodeError(path, "Unknown element '%s'", name))
+		} else {
+			errs = errs.append(jv.value(c, m[name], path+"/"+name))
+		}
+	}
+
+	if jv.merge {
+		return errs.err()
+	}
+
+	var missing []string
+	for name, c := range children {
+		if _, ok := m[name]; !ok && isMandatory(c) && !hasPrefixedMember(m, name) {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		errs = errs.append(invalidNodeError(path, "Mandatory element '%s' missing", name))
+	}
+
+	return errs.err()
+}
+
+// sortedKeys returns the member names of a json object in sorted order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hasPrefixedMember checks if json object m has a member with given
+// local name, with any module prefix.
+func hasPrefixedMember(m map[string]interface{}, name string) bool {
+	for k := range m {
+		if localName(k) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// leafValueError checks if json value v is a valid RFC7951 encoding for
+// the YANG type t of leaf or leaf-list e; and satisfies the range, length
+// and pattern restrictions of the type. Returns the reason if it is not
+// valid; empty string otherwise. Leafref values are checked against the
+// type of the referred leaf, if it can be resolved.
+func (jv jsonValidator) leafValueError(e *yang.Entry, t *yang.YangType, v interface{}) string {
+	if t == nil {
+		return ""
+	}
+
+	switch t.Kind {
+	case yang.Yint8, yang.Yint16, yang.Yint32, yang.Yuint8, yang.Yuint16, yang.Yuint32:
+		n, ok := v.(json.Number)
+		if !ok || !isValidInt(string(n), t.Kind) {
+			return "expected " + t.Kind.String()
+		}
+		return checkRange(t.Range, string(n), -1)
+	case yang.Yint64, yang.Yuint64:
+		s, ok := numberString(v)
+		if !ok || !isValidInt(s, t.Kind) {
+			return "expected " + t.Kind.String()
+		}
+		return checkRange(t.Range, s, -1)
+	case yang.Ydecimal64:
+		s, ok := numberString(v)
+		if _, err := strconv.ParseFloat(s, 64); !ok || err != nil {
+			return "expected decimal64"
+		}
+		return checkRange(t.Range, s, t.FractionDigits)
+	case yang.Ybool:
+		if _, ok := v.(bool); !ok {
+			return "expected boolean"
+		}
+	case yang.Yempty:
+		if x, ok := v.([]interface{}); !ok || len(x) != 1 || x[0] != nil {
+			return "expected [null]"
+		}
+	case yang.Yenum:
+		if s, ok := v.(string); !ok || (t.Enum != nil && !t.Enum.IsDefined(s)) {
+			return "not a valid enum"
+		}
+	case yang.Ybits:
+		s, ok := v.(string)
+		if !ok {
+			return "expected string"
+		}
+		for _, b := range strings.Fields(s) {
+			if t.Bit != nil && !t.Bit.IsDefined(b) {
+				return "unknown bit '" + b + "'"
+			}
+		}
+	case yang.Ystring:
+		s, ok := v.(string)
+		if !ok {
+			return "expected string"
+		}
+		if reason := checkLength(t.Length, utf8.RuneCountInString(s)); len(reason) != 0 {
+			return reason
+		}
+		return checkPatterns(t.Pattern, s)
+	case yang.Ybinary:
+		s, ok := v.(string)
+		if !ok {
+			return "expected base64 string"
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return "expected base64 string"
+		}
+		return checkLength(t.Length, len(b))
+	case yang.Yidentityref, yang.YinstanceIdentifier:
+		if _, ok := v.(string); !ok {
+			return "expected string"
+		}
+	case yang.Yleafref:
+		if target := jv.leafrefTarget(e, t.Path); target != nil && target.Type != nil {
+			return jv.leafValueError(target, target.Type, v)
+		}
+	case yang.Yunion:
+		for _, ut := range t.Type {
+			if jv.leafValueError(e, ut, v) == "" {
+				return ""
+			}
+		}
+		if len(t.Type) != 0 {
+			return "does not match any of the union types"
+		}
+	}
+
+	return ""
+}
+
+// leafrefPredicateExpr matches the predicates in leafref paths
+var leafrefPredicateExpr = regexp.MustCompile(`\[[^\]]*\]`)
+
+// leafrefTarget resolves the leaf referred by leafref path of entry e.
+// Predicates in the path are ignored. Returns nil if the path cannot be
+// resolved or refers to another leafref.
+func (jv jsonValidator) leafrefTarget(e *yang.Entry, path string) *yang.Entry {
+	path = leafrefPredicateExpr.ReplaceAllString(path, "")
+	parts := strings.Split(path, "/")
+	target := e
+
+	if parts[0] == "" { // absolute path
+		if jv.schema == nil || len(parts) < 2 {
+			return nil
+		}
+		k := strings.IndexByte(parts[1], ':')
+		if k < 0 || jv.schema.prefixes[parts[1][:k]] == nil {
+			return nil
+		}
+		target = jv.schema.prefixes[parts[1][:k]]
+		parts = parts[1:]
+	}
+
+	for _, p := range parts {
+		switch p = strings.TrimSpace(p); p {
+		case "", ".":
+		case "..":
+			target = dataParent(target)
+		default:
+			target = dataChild(target, localName(p))
+		}
+		if target == nil {
+			return nil
+		}
+	}
+
+	if !target.IsLeaf() && !target.IsLeafList() || target.Type == nil || target.Type.Kind == yang.Yleafref {
+		return nil
+	}
+	return target
+}
+
+// dataParent returns the parent data node of e, skipping the choice
+// and case nodes.
+func dataParent(e *yang.Entry) *yang.Entry {
+	p := e.Parent
+	for p != nil && (p.IsChoice() || p.IsCase()) {
+		p = p.Parent
+	}
+	return p
+}
+
+// checkRange checks if the number s is within the YANG range r. Number
+// is parsed as a decimal64 with fracDigits fraction digits if fracDigits
+// is not negative. Returns the reason if it is not valid.
+func checkRange(r yang.YangRange, s string, fracDigits int) string {
+	var n yang.Number
+	var err error
+	if fracDigits >= 0 {
+		n, err = yang.DecimalValueFromString(s, fracDigits)
+	} else {
+		n, err = yang.ParseNumber(s)
+	}
+	if err != nil {
+		return err.Error()
+	}
+	if len(r) == 0 || inRange(r, n) {
+		return ""
+	}
+	return "out of range " + r.String()
+}
+
+// checkLength checks if the length n is within the YANG length
+// restriction r. Returns the reason if it is not valid.
+func checkLength(r yang.YangRange, n int) string {
+	if len(r) == 0 || inRange(r, yang.FromInt(int64(n))) {
+		return ""
+	}
+	return "length should be " + r.String()
+}
+
+// inRange checks if the number n is within any of the ranges in r.
+func inRange(r yang.YangRange, n yang.Number) bool {
+	for _, x := range r {
+		if !n.Less(x.Min) && !x.Max.Less(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// yangPatterns caches the compiled YANG patterns. Patterns which cannot
+// be compiled as Go regular expressions are cached as nil and ignored.
+var yangPatterns sync.Map // pattern string to *regexp.Regexp
+
+// checkPatterns checks if the string s matches all the YANG patterns.
+// Returns the reason if it does not.
+func checkPatterns(patterns []string, s string) string {
+	for _, p := range patterns {
+		v, ok := yangPatterns.Load(p)
+		if !ok {
+			// YANG patterns are XSD regular expressions, which are
+			// implicitly anchored at both ends.
+			re, err := regexp.Compile("^(?:" + p + ")$")
+			if err != nil {
+				glog.V(1).Infof("Ignoring pattern '%s'; %v", p, err)
+				re = nil
+			}
+			v, _ = yangPatterns.LoadOrStore(p, re)
+		}
+		if re := v.(*regexp.Regexp); re != nil && !re.MatchString(s) {
+			return "does not match pattern '" + p + "'"
+		}
+	}
+	return ""
+}
+
+// intBitSizes are the bit sizes of YANG integer types.
+var intBitSizes = map[yang.TypeKind]int{
+	yang.Yint8: 8, yang.Yint16: 16, yang.Yint32: 32, yang.Yint64: 64,
+	yang.Yuint8: 8, yang.Yuint16: 16, yang.Yuint32: 32, yang.Yuint64: 64,
+}
+
+// isValidInt checks if s is a valid value for a YANG integer type.
+func isValidInt(s string, kind yang.TypeKind) bool {
+	var err error
+	switch kind {
+	case yang.Yuint8, yang.Yuint16, yang.Yuint32, yang.Yuint64:
+		_, err = strconv.ParseUint(s, 10, intBitSizes[kind])
+	default:
+		_, err = strconv.ParseInt(s, 10, intBitSizes[kind])
+	}
+	return err == nil
+}
+
+// invalidNodeError creates an InvalidArgsError for the data node at path.
+func invalidNodeError(path, msg string, args ...interface{}) error {
+	return tlerr.InvalidArgsError{Format: msg, Args: args, Path: path}
+}
@@ -0,0 +1,275 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"net/http"
+	"strings"
+
+	"github.com/openconfig/goyang/pkg/yang"
+)
+
+// yangValidation indicates whether the request payloads and RPC inputs
+// should be validated against the YANG schema loaded from yang_dir.
+var yangValidation bool
+
+func init() {
+	flag.BoolVar(&yangValidation, "yang_validation", yangValidation,
+		"Validate JSON and XML request payloads and RPC inputs against the YANG schema")
+}
+
+// validateYangPayload validates the payload of a PUT, PATCH or POST request
+// on a data resource against the YANG schema. Unlike RequestValidate, it
+// does not depend on the generated Go structs and supports XML payloads
+// also. Checks the value types, ranges, lengths and patterns of the leaf
+// nodes, mandatory nodes and list keys. Key values of the target list
+// instance should match the keys in the URI. Mandatory nodes and keys are
+// not required for PATCH, since the data is merged with existing data.
+// Validation is skipped if the schema is not loaded or the target node
+// is not found in the schema. RPC and action inputs are validated by
+// validateRPCInput instead.
+func validateYangPayload(r *http.Request, rc *RequestContext, ct *MediaType, body []byte) error {
+	s := getYangSchema()
+	if s == nil || len(body) == 0 || !strings.HasPrefix(requestPath(r), restconfDataPathPrefix) {
+		return nil
+	}
+	if r.Method != "PUT" && r.Method != "PATCH" && r.Method != "POST" {
+		return nil
+	}
+
+	path := getPathForTranslib(r, rc)
+	var target *yang.Entry
+	if len(splitTranslibPath(path)) != 0 {
+		if target = s.find(path); target == nil || target.RPC != nil {
+			return nil
+		}
+	}
+
+	// Payload nodes are children of the target for POST; and the
+	// target node itself for PUT and PATCH.
+	parent := target
+	if r.Method != "POST" && target != nil {
+		parent = dataParent(target)
+	}
+
+	var data interface{}
+	switch {
+	case ct.isJSON():
+		d := json.NewDecoder(bytes.NewReader(body))
+		d.UseNumber()
+		if err := d.Decode(&data); err != nil {
+			return jsonDecodeError(err)
+		}
+	case ct.isXML() && parent != nil:
+		var err error
+		if data, err = xmlToJSON(s, parent, body); err != nil {
+			return err
+		}
+	default:
+		requestLog(rc).Infof("Skipping YANG validation for content-type '%s'", ct.Type)
+		return nil
+	}
+
+	jv := jsonValidator{schema: s, merge: r.Method == "PATCH"}
+	if r.Method == "POST" || target == nil {
+		return jv.members(target, data, strings.TrimSuffix(path, "/"))
+	}
+	return jv.target(target, data, path)
+}
+
+// target validates the PUT or PATCH payload for the target node e. Payload
+// should contain only the target node; and only one list entry if e is a
+// list instance.
+func (jv jsonValidator) target(e *yang.Entry, data interface{}, path string) error {
+	m, ok := data.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return invalidNodeError(path, "Payload should contain only the '%s' node", e.Name)
+	}
+
+	for name, v := range m {
+		if localName(name) != e.Name {
+			return invalidNodeError(path, "Payload should contain only the '%s' node", e.Name)
+		}
+
+		last := splitTranslibPath(path)
+		elem, keys := splitElemKeys(last[len(last)-1])
+		if !e.IsList() || len(keys) == 0 {
+			return jv.value(e, v, path)
+		}
+
+		// List instance
+		list, ok := v.([]interface{})
+		if !ok || len(list) != 1 {
+			return invalidNodeError(path, "'%s' should be an array with one entry", e.Name)
+		}
+		if err := jv.value(e, v, strings.TrimSuffix(path, last[len(last)-1])+elem); err != nil {
+			return err
+		}
+		return checkURIKeys(path, keys, list[0])
+	}
+
+	return nil
+}
+
+// members validates the payload members as child nodes of entry e. Members
+// should be top level data nodes of a module if e is nil.
+func (jv jsonValidator) members(e *yang.Entry, data interface{}, path string) error {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return invalidNodeError(path, "Payload should be an object")
+	}
+
+	var errs MultiError
+	for _, name := range sortedKeys(m) {
+		var c *yang.Entry
+		if e != nil {
+			c = dataChild(e, localName(name))
+		} else if k := strings.IndexByte(name, ':'); k > 0 && jv.schema.modules[name[:k]] != nil {
+			c = dataChild(jv.schema.modules[name[:k]], localName(name))
+		}
+
+		if c == nil {
+			errs = errs.append(invalidNodeError(path, "Unknown element '%s'", name))
+		} else {
+			errs = errs.append(jv.value(c, m[name], path+"/"+name))
+		}
+	}
+
+	return errs.err()
+}
+
+// xmlNode is a generic xml element.
+type xmlNode struct {
+	XMLName  xml.Name
+	Text     string    `xml:",chardata"`
+	Children []xmlNode `xml:",any"`
+}
+
+// xmlToJSON converts an xml payload into json form (as decoded by
+// json.Decoder with UseNumber option), as per RFC7951 encoding rules.
+// Root element of the payload should be a child of schema entry parent.
+// Schema is used for identifying the lists and the value types; nodes
+// not in the schema are retained, so that the validator reports them.
+func xmlToJSON(s *yangSchema, parent *yang.Entry, body []byte) (interface{}, error) {
+	var root xmlNode
+	if err := xml.Unmarshal(body, &root); err != nil {
+		return nil, httpBadRequest("Invalid xml; %v", err)
+	}
+
+	m := make(map[string]interface{})
+	xc := xmlConverter{schema: s}
+	xc.addMember(m, parent, &root)
+	return m, nil
+}
+
+// xmlConverter converts xml elements into json values.
+type xmlConverter struct {
+	schema *yangSchema
+}
+
+// addMember adds the json value of xml element n to the json object m,
+// which represents the data node of schema entry parent. Member name is
+// qualified with the module name if the element namespace is different
+// from that of the parent. List and leaf-list elements are appended
+// to arrays.
+func (xc xmlConverter) addMember(m map[string]interface{}, parent *yang.Entry, n *xmlNode) {
+	ns := n.XMLName.Space
+	var e *yang.Entry
+	if parent != nil {
+		if e = dataChild(parent, n.XMLName.Local); e != nil && e.Namespace().Name != ns {
+			e = nil // will be reported as unknown element
+		}
+	}
+
+	name := n.XMLName.Local
+	if module := xc.schema.namespaces[ns]; len(module) != 0 && (parent == nil || parent.Namespace().Name != ns) {
+		name = module + ":" + name
+	}
+
+	v := xc.value(e, n)
+	if e != nil && (e.IsList() || e.IsLeafList()) {
+		list, _ := m[name].([]interface{})
+		m[name] = append(list, v)
+	} else {
+		m[name] = v
+	}
+}
+
+// value returns the json value of xml element n, for schema entry e.
+func (xc xmlConverter) value(e *yang.Entry, n *xmlNode) interface{} {
+	if e != nil && (e.IsLeaf() || e.IsLeafList()) {
+		return xc.leafValue(e, e.Type, n.Text)
+	}
+	if e == nil && len(n.Children) == 0 {
+		return n.Text
+	}
+
+	m := make(map[string]interface{})
+	for i := range n.Children {
+		xc.addMember(m, e, &n.Children[i])
+	}
+	return m
+}
+
+// leafValue returns the json value of xml text s, for leaf type t.
+// Returns the text as is if it is not a valid value for the type.
+func (xc xmlConverter) leafValue(e *yang.Entry, t *yang.YangType, s string) interface{} {
+	if t == nil {
+		return s
+	}
+
+	switch t.Kind {
+	case yang.Yint8, yang.Yint16, yang.Yint32, yang.Yuint8, yang.Yuint16, yang.Yuint32:
+		if v := strings.TrimSpace(s); isValidInt(v, t.Kind) {
+			return json.Number(v)
+		}
+	case yang.Yint64, yang.Yuint64, yang.Ydecimal64:
+		return strings.TrimSpace(s)
+	case yang.Ybool:
+		switch strings.TrimSpace(s) {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+	case yang.Yempty:
+		if len(strings.TrimSpace(s)) == 0 {
+			return []interface{}{nil}
+		}
+	case yang.Yleafref:
+		jv := jsonValidator{schema: xc.schema}
+		if target := jv.leafrefTarget(e, t.Path); target != nil {
+			return xc.leafValue(target, target.Type, s)
+		}
+	case yang.Yunion:
+		jv := jsonValidator{schema: xc.schema}
+		for _, ut := range t.Type {
+			if v := xc.leafValue(e, ut, s); jv.leafValueError(e, ut, v) == "" {
+				return v
+			}
+		}
+	}
+
+	return s
+}
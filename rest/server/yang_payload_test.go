@@ -0,0 +1,180 @@
+////////////////////////////////////////////////////////////////////////////////
+//                                                                            //
+//  Copyright 2020 Broadcom. The term Broadcom refers to Broadcom Inc. and/or //
+//  its subsidiaries.                                                         //
+//                                                                            //
+//  Licensed under the Apache License, Version 2.0 (the "License");           //
+//  you may not use this file except in compliance with the License.          //
+//  You may obtain a copy of the License at                                   //
+//                                                                            //
+//     http://www.apache.org/licenses/LICENSE-2.0                             //
+//                                                                            //
+//  Unless required by applicable law or agreed to in writing, software       //
+//  distributed under the License is distributed on an "AS IS" BASIS,         //
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.  //
+//  See the License for the specific language governing permissions and       //
+//  limitations under the License.                                            //
+//                                                                            //
+////////////////////////////////////////////////////////////////////////////////
+
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// payloadTestYang is a YANG module for testing the payload validation.
+const payloadTestYang = `
+module payload-test {
+	namespace "http://example.com/payload-test";
+	prefix pt;
+
+	container top {
+		leaf name {
+			type string {
+				length "1..8";
+				pattern '[a-z][a-z0-9]*';
+			}
+		}
+		leaf mtu {
+			type uint16 { range "68..9216"; }
+		}
+		leaf speed {
+			type decimal64 {
+				fraction-digits 2;
+				range "0.5..100";
+			}
+		}
+		leaf mode {
+			type union {
+				type uint8;
+				type enumeration { enum auto; }
+			}
+		}
+		leaf enabled { type boolean; }
+		leaf primary {
+			type leafref { path "../server/name"; }
+		}
+		leaf default-mtu {
+			type leafref { path "/pt:top/pt:mtu"; }
+		}
+		list server {
+			key "name";
+			leaf name { type string; }
+			leaf port {
+				type uint16;
+				mandatory true;
+			}
+			leaf-list tags { type string; }
+		}
+	}
+}`
+
+func TestYangPayload(t *testing.T) {
+	defer useYangSchema(loadTestYangSchema(t, payloadTestYang))()
+	defer useBackend("/restconf/data/payload-test:", &recordingBackend{})()
+	defer func(v bool) { yangValidation = v }(yangValidation)
+	yangValidation = true
+
+	s := newEmptyRouter()
+	for _, m := range []string{"PUT", "PATCH", "POST"} {
+		s.addRoute("top", m, "/restconf/data/payload-test:top", Process)
+		for _, leaf := range []string{"name", "mtu", "speed", "mode", "primary", "default-mtu"} {
+			s.addRoute(leaf, m, "/restconf/data/payload-test:top/"+leaf, Process)
+		}
+		s.addRoute("server", m, "/restconf/data/payload-test:top/server={name}", Process)
+	}
+
+	t.Run("valid", testYangPayload(s, "PATCH", "/payload-test:top",
+		`{"payload-test:top": {"name": "eth1", "mtu": 9000, "speed": "2.5", "mode": "auto", "enabled": true,
+		  "server": [{"name": "s1", "port": 80, "tags": ["a"]}]}}`, 204, ""))
+	t.Run("range", testYangPayload(s, "PUT", "/payload-test:top/mtu",
+		`{"payload-test:mtu": 10}`, 400, "range"))
+	t.Run("decimal_range", testYangPayload(s, "PUT", "/payload-test:top/speed",
+		`{"payload-test:speed": "0.25"}`, 400, "range"))
+	t.Run("length", testYangPayload(s, "PUT", "/payload-test:top/name",
+		`{"payload-test:name": "abcdefghij"}`, 400, "length"))
+	t.Run("pattern", testYangPayload(s, "PUT", "/payload-test:top/name",
+		`{"payload-test:name": "Eth1"}`, 400, "pattern"))
+	t.Run("union", testYangPayload(s, "PUT", "/payload-test:top/mode",
+		`{"payload-test:mode": 5}`, 204, ""))
+	t.Run("bad_union", testYangPayload(s, "PUT", "/payload-test:top/mode",
+		`{"payload-test:mode": "manual"}`, 400, "union"))
+	t.Run("leafref", testYangPayload(s, "PUT", "/payload-test:top/primary",
+		`{"payload-test:primary": "s1"}`, 204, ""))
+	t.Run("bad_leafref", testYangPayload(s, "PUT", "/payload-test:top/primary",
+		`{"payload-test:primary": 1}`, 400, "'primary'"))
+	t.Run("abs_leafref", testYangPayload(s, "PUT", "/payload-test:top/default-mtu",
+		`{"payload-test:default-mtu": 10}`, 400, "range"))
+	t.Run("unknown", testYangPayload(s, "PATCH", "/payload-test:top",
+		`{"payload-test:top": {"speed-mode": "auto"}}`, 400, "'speed-mode'"))
+	t.Run("wrong_node", testYangPayload(s, "PUT", "/payload-test:top/mtu",
+		`{"payload-test:name": "x"}`, 400, "'mtu'"))
+	t.Run("mandatory", testYangPayload(s, "PUT", "/payload-test:top/server=s1",
+		`{"payload-test:server": [{"name": "s1"}]}`, 400, "'port'"))
+	t.Run("merge", testYangPayload(s, "PATCH", "/payload-test:top/server=s1",
+		`{"payload-test:server": [{"tags": ["x"]}]}`, 204, ""))
+	t.Run("key_mismatch", testYangPayload(s, "PUT", "/payload-test:top/server=s1",
+		`{"payload-test:server": [{"name": "s2", "port": 80}]}`, 400, "does not match"))
+	t.Run("multiple_entries", testYangPayload(s, "PUT", "/payload-test:top/server=s1",
+		`{"payload-test:server": [{"name": "s1", "port": 80}, {"name": "s2", "port": 80}]}`, 400, "one entry"))
+	t.Run("missing_key", testYangPayload(s, "POST", "/payload-test:top",
+		`{"payload-test:server": [{"port": 80}]}`, 400, "Key 'name'"))
+	t.Run("post", testYangPayload(s, "POST", "/payload-test:top",
+		`{"payload-test:server": [{"name": "s3", "port": 80}]}`, 201, ""))
+}
+
+func TestYangPayload_xml(t *testing.T) {
+	defer useYangSchema(loadTestYangSchema(t, payloadTestYang))()
+	defer useBackend("/restconf/data/payload-test:", &recordingBackend{})()
+	defer func(v bool) { yangValidation = v }(yangValidation)
+	yangValidation = true
+
+	s := newEmptyRouter()
+	s.addRoute("top", "PUT", "/restconf/data/payload-test:top", Process)
+	s.addRoute("top", "POST", "/restconf/data/payload-test:top", Process)
+
+	t.Run("valid", testYangPayload(s, "PUT", "/payload-test:top",
+		`<top xmlns="http://example.com/payload-test"><mtu>1500</mtu><enabled>true</enabled><mode>10</mode>
+		 <server><name>s1</name><port>80</port><tags>a</tags><tags>b</tags></server>
+		 <server><name>s2</name><port>80</port></server></top>`, 204, ""))
+	t.Run("range", testYangPayload(s, "PUT", "/payload-test:top",
+		`<top xmlns="http://example.com/payload-test"><mtu>10</mtu></top>`, 400, "range"))
+	t.Run("bool", testYangPayload(s, "PUT", "/payload-test:top",
+		`<top xmlns="http://example.com/payload-test"><enabled>yes</enabled></top>`, 400, "boolean"))
+	t.Run("mandatory", testYangPayload(s, "POST", "/payload-test:top",
+		`<server xmlns="http://example.com/payload-test"><name>s1</name></server>`, 400, "'port'"))
+	t.Run("namespace", testYangPayload(s, "PUT", "/payload-test:top",
+		`<top xmlns="http://example.com/payload-test"><mtu xmlns="urn:x">1500</mtu></top>`, 400, "'mtu'"))
+	t.Run("syntax", testYangPayload(s, "PUT", "/payload-test:top",
+		`<top xmlns="http://example.com/payload-test"><mtu>1500</top>`, 400, "xml"))
+}
+
+func TestYangPayload_disabled(t *testing.T) {
+	defer useYangSchema(loadTestYangSchema(t, payloadTestYang))()
+	defer useBackend("/restconf/data/payload-test:", &recordingBackend{})()
+
+	s := newEmptyRouter()
+	s.addRoute("mtu", "PUT", "/restconf/data/payload-test:top/mtu", Process)
+	testYangPayload(s, "PUT", "/payload-test:top/mtu", `{"payload-test:mtu": 10}`, 204, "")(t)
+}
+
+func testYangPayload(s *Router, method, path, data string, expStatus int, expErr string) func(*testing.T) {
+	return func(t *testing.T) {
+		r := prepareRequest(t, method, path, data)
+		if strings.HasPrefix(data, "<") {
+			rc, _ := GetContext(r)
+			r.Header.Set("Content-Type", "application/yang-data+xml")
+			rc.Consumes.Add("application/yang-data+xml")
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		verifyResponse(t, w, expStatus)
+		if body := w.Body.String(); !strings.Contains(body, expErr) {
+			t.Fatalf("Expected error with '%s'; found %s", expErr, body)
+		}
+	}
+}